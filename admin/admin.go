@@ -0,0 +1,260 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/goccha/logging/log"
+	"github.com/goccha/redis-verse/guards"
+	"github.com/goccha/redis-verse/locks"
+	"github.com/goccha/redis-verse/redis"
+	"github.com/goccha/redis-verse/sessions"
+)
+
+const (
+	ResourceLock    = "lock"
+	ResourceCounter = "counter"
+)
+
+// Action 認可の対象となる操作
+type Action struct {
+	Method   string // GETまたはDELETE
+	Resource string // lockまたはcounter
+	Key      string // 一覧の場合はパターン
+	DryRun   bool
+}
+
+// ForbiddenError 認可で拒否された
+type ForbiddenError struct {
+	Reason string
+}
+
+func (err *ForbiddenError) Error() string {
+	if err.Reason == "" {
+		return "forbidden"
+	}
+	return err.Reason
+}
+
+func IsForbidden(err error) bool {
+	var e *ForbiddenError
+	return errors.As(err, &e)
+}
+
+// AuditEvent ロック解除・カウンターリセットの監査ログ
+type AuditEvent struct {
+	Time     time.Time `json:"time"`
+	Actor    string    `json:"actor"`
+	Resource string    `json:"resource"`
+	Key      string    `json:"key"`
+	DryRun   bool      `json:"dry_run"`
+	Changed  bool      `json:"changed"`
+	Error    string    `json:"error,omitempty"`
+}
+
+// Handler ロックとLockCounterの状態を確認・操作する管理用API
+//
+//	GET    /locks?pattern=*          ロック中のキーを一覧する
+//	DELETE /locks/{key}              ロックを解除する
+//	GET    /counters/{key}           LockCounterに記録されている試行を取得する
+//	DELETE /counters/{key}           試行を削除する。unlock=trueの場合はロックも解除する
+//
+// DELETEはdry_run=trueを指定すると変更せずに結果を返す
+type Handler struct {
+	Authorize func(r *http.Request, a Action) error   // nilの場合はすべて拒否する
+	Actor     func(r *http.Request) string            // 監査ログに記録する操作者。既定値はセッションのユーザーID
+	Audit     func(ctx context.Context, e AuditEvent) // 既定値はログ出力
+}
+
+func New(authorize func(r *http.Request, a Action) error) *Handler {
+	return &Handler{
+		Authorize: authorize,
+	}
+}
+
+func (h *Handler) authorize(r *http.Request, a Action) error {
+	if h.Authorize == nil {
+		return &ForbiddenError{Reason: "admin: authorization is not configured"}
+	}
+	return h.Authorize(r, a)
+}
+
+func (h *Handler) actor(r *http.Request) string {
+	if h.Actor != nil {
+		return h.Actor(r)
+	}
+	if s := sessions.FromContext(r.Context()); s != nil {
+		return s.UserID
+	}
+	return r.RemoteAddr
+}
+
+func (h *Handler) audit(ctx context.Context, e AuditEvent) {
+	if h.Audit != nil {
+		h.Audit(ctx, e)
+		return
+	}
+	log.Info(ctx).Str("actor", e.Actor).Str("resource", e.Resource).Str("key", e.Key).
+		Bool("dry_run", e.DryRun).Bool("changed", e.Changed).Str("error", e.Error).Msg("admin: audit")
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/")
+	resource, key := path, ""
+	if i := strings.Index(path, "/"); i >= 0 {
+		resource, key = path[:i], path[i+1:]
+	}
+	switch resource {
+	case "locks":
+		resource = ResourceLock
+	case "counters":
+		resource = ResourceCounter
+	default:
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+	a := Action{Method: r.Method, Resource: resource, Key: key}
+	switch {
+	case r.Method == http.MethodGet && resource == ResourceLock && key == "":
+		a.Key = r.URL.Query().Get("pattern")
+	case r.Method == http.MethodDelete && key != "":
+		a.DryRun, _ = strconv.ParseBool(r.URL.Query().Get("dry_run"))
+	case r.Method == http.MethodGet && key != "":
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	if err := h.authorize(r, a); err != nil {
+		writeError(w, http.StatusForbidden, err.Error())
+		return
+	}
+	ctx := r.Context()
+	var v interface{}
+	var err error
+	switch {
+	case r.Method == http.MethodDelete:
+		v, err = h.delete(ctx, r, a)
+	case resource == ResourceLock && key == "":
+		v, err = locks.List(ctx, a.Key)
+	case resource == ResourceLock:
+		v, err = lock(ctx, key)
+	default:
+		v, err = counter(ctx, key)
+	}
+	if err != nil {
+		// 内部のエラーはログにだけ出力し、応答には含めない
+		log.Error(ctx).Err(err).Str("path", r.URL.Path).Send()
+		writeError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+		return
+	}
+	writeJSON(w, http.StatusOK, v)
+}
+
+// Lock ロックの状態
+type Lock struct {
+	Key    string        `json:"key"`
+	Locked bool          `json:"locked"`
+	TTL    time.Duration `json:"ttl,omitempty"`
+}
+
+func lock(ctx context.Context, key string) (*Lock, error) {
+	ttl, err := redis.Primary().TTL(ctx, locks.LockKey(key)).Result()
+	if err != nil {
+		return nil, err
+	}
+	if ttl == -2 {
+		return &Lock{Key: key}, nil
+	}
+	return &Lock{Key: key, Locked: true, TTL: ttl}, nil
+}
+
+// Counter LockCounterの状態
+type Counter struct {
+	Key      string      `json:"key"`
+	Attempts []time.Time `json:"attempts"`
+	Locked   bool        `json:"locked"`
+}
+
+func counter(ctx context.Context, key string) (*Counter, error) {
+	attempts, err := guards.Attempts(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	l, err := lock(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	return &Counter{Key: key, Attempts: attempts, Locked: l.Locked}, nil
+}
+
+// Result DELETEの結果。DryRunの場合は変更前の状態を返す
+type Result struct {
+	Key     string      `json:"key"`
+	DryRun  bool        `json:"dry_run"`
+	Changed bool        `json:"changed"`
+	Before  interface{} `json:"before"`
+}
+
+func (h *Handler) delete(ctx context.Context, r *http.Request, a Action) (res *Result, err error) {
+	e := AuditEvent{Time: redis.Now(), Actor: h.actor(r), Resource: a.Resource, Key: a.Key, DryRun: a.DryRun}
+	defer func() {
+		if err != nil {
+			e.Error = err.Error()
+		} else {
+			e.Changed = res.Changed
+		}
+		h.audit(ctx, e)
+	}()
+	res = &Result{Key: a.Key, DryRun: a.DryRun}
+	unlock, _ := strconv.ParseBool(r.URL.Query().Get("unlock"))
+	if a.Resource == ResourceLock {
+		before, err := lock(ctx, a.Key)
+		if err != nil {
+			return nil, err
+		}
+		res.Before = before
+		if a.DryRun {
+			res.Changed = before.Locked
+			return res, nil
+		}
+		res.Changed, err = locks.Unlock(ctx, a.Key)
+		return res, err
+	}
+	before, err := counter(ctx, a.Key)
+	if err != nil {
+		return nil, err
+	}
+	res.Before = before
+	if a.DryRun {
+		res.Changed = len(before.Attempts) > 0 || (unlock && before.Locked)
+		return res, nil
+	}
+	if res.Changed, err = guards.Reset(ctx, a.Key); err != nil {
+		return nil, err
+	}
+	if unlock {
+		unlocked, err := locks.Unlock(ctx, a.Key)
+		if err != nil {
+			return nil, err
+		}
+		res.Changed = res.Changed || unlocked
+	}
+	return res, nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Debug(context.Background()).Err(err).Send()
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
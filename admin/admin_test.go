@@ -0,0 +1,96 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/goccha/redis-verse/redis"
+	"github.com/goccha/redis-verse/redistest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMain(m *testing.M) {
+	redistest.Main(m)
+}
+
+func request(h http.Handler, method, target string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, target, nil)
+	r.Header.Set("X-Operator", "alice")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func TestHandler(t *testing.T) {
+	var events []AuditEvent
+	h := New(func(r *http.Request, a Action) error {
+		if a.Method == http.MethodDelete && r.Header.Get("X-Operator") == "" {
+			return &ForbiddenError{Reason: "operator required"}
+		}
+		return nil
+	})
+	h.Actor = func(r *http.Request) string { return r.Header.Get("X-Operator") }
+	h.Audit = func(ctx context.Context, e AuditEvent) { events = append(events, e) }
+
+	s := redistest.Server()
+	assert.Nil(t, s.Set("blocks://admin:user-1", redis.Locked))
+	s.SetTTL("blocks://admin:user-1", time.Hour)
+	assert.Nil(t, s.Set("lock-count://admin:user-2", "[1700000000]"))
+
+	w := request(h, http.MethodGet, "/locks?pattern=admin:*")
+	assert.Equal(t, http.StatusOK, w.Code)
+	var entries []map[string]interface{}
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &entries))
+	assert.Len(t, entries, 1)
+	assert.Equal(t, "admin:user-1", entries[0]["key"])
+
+	w = request(h, http.MethodGet, "/counters/admin:user-2")
+	var c Counter
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &c))
+	assert.Len(t, c.Attempts, 1)
+	assert.False(t, c.Locked)
+
+	w = request(h, http.MethodDelete, "/locks/admin:user-1?dry_run=true")
+	assert.Equal(t, http.StatusOK, w.Code)
+	var res Result
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &res))
+	assert.True(t, res.DryRun)
+	assert.True(t, res.Changed)
+	assert.True(t, s.Exists("blocks://admin:user-1"))
+
+	w = request(h, http.MethodDelete, "/locks/admin:user-1")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.False(t, s.Exists("blocks://admin:user-1"))
+
+	w = request(h, http.MethodDelete, "/counters/admin:user-2?unlock=true")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.False(t, s.Exists("lock-count://admin:user-2"))
+
+	if assert.Len(t, events, 3) {
+		assert.Equal(t, AuditEvent{Time: events[0].Time, Actor: "alice", Resource: ResourceLock, Key: "admin:user-1", DryRun: true, Changed: true}, events[0])
+		assert.False(t, events[1].DryRun)
+		assert.Equal(t, ResourceCounter, events[2].Resource)
+		assert.True(t, events[2].Changed)
+	}
+
+	r := httptest.NewRequest(http.MethodDelete, "/locks/admin:user-1", nil)
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.JSONEq(t, `{"error":"operator required"}`, w.Body.String())
+
+	// 内部のエラーは応答に含めない
+	assert.Nil(t, s.Set("lock-count://admin:user-3", "invalid"))
+	w = request(h, http.MethodGet, "/counters/admin:user-3")
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.JSONEq(t, `{"error":"Internal Server Error"}`, w.Body.String())
+	s.Del("lock-count://admin:user-3")
+
+	assert.Equal(t, http.StatusMethodNotAllowed, request(h, http.MethodPost, "/locks/admin:user-1").Code)
+	assert.Equal(t, http.StatusNotFound, request(h, http.MethodGet, "/sessions").Code)
+	assert.Equal(t, http.StatusForbidden, request(&Handler{}, http.MethodGet, "/locks").Code)
+}
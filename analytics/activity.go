@@ -0,0 +1,158 @@
+package analytics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/goccha/redis-verse/redis"
+	goredis "github.com/redis/go-redis/v9"
+)
+
+// Activity ビットマップで区間ごとに活動したIDを記録する。IDをビットの位置に使うため、連番のIDを想定する
+type Activity struct {
+	series
+}
+
+func NewActivity(name string, bucket Bucket, retention time.Duration) *Activity {
+	return &Activity{series{kind: "activity", name: name, Bucket: bucket, Retention: retention}}
+}
+
+// Mark tを含む区間にidsが活動したことを記録する
+func (a *Activity) Mark(ctx context.Context, t time.Time, ids ...int64) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	key := a.Key(t)
+	return redis.Universal(ctx, func(ctx context.Context, c redis.Cmdable) error {
+		_, err := c.Pipelined(ctx, func(pipe goredis.Pipeliner) error {
+			for _, id := range ids {
+				pipe.SetBit(ctx, key, id, 1)
+			}
+			if at, ok := a.expireAt(t); ok {
+				pipe.ExpireAt(ctx, key, at)
+			}
+			return nil
+		})
+		return err
+	})
+}
+
+// Active tを含む区間にidが活動したかを返す
+func (a *Activity) Active(ctx context.Context, t time.Time, id int64) (active bool, err error) {
+	err = redis.ReadOnly(ctx, func(ctx context.Context, c redis.Cmdable) error {
+		v, err := c.GetBit(ctx, a.Key(t), id).Result()
+		active = v == 1
+		return err
+	})
+	return
+}
+
+// Count tを含む区間に活動したIDの数
+func (a *Activity) Count(ctx context.Context, t time.Time) (count int64, err error) {
+	err = redis.ReadOnly(ctx, func(ctx context.Context, c redis.Cmdable) error {
+		count, err = c.BitCount(ctx, a.Key(t), nil).Result()
+		return err
+	})
+	return
+}
+
+// CountRange fromからtoまでのいずれかの区間に活動したIDの数。日次の記録から月間の活動数を求める場合に使う
+func (a *Activity) CountRange(ctx context.Context, from, to time.Time) (int64, error) {
+	return Union(a.Keys(from, to)...).Count(ctx)
+}
+
+type Op string
+
+const (
+	And Op = "AND"
+	Or  Op = "OR"
+)
+
+// Cohort 複数のビットマップをBITOPで組み合わせた集合。
+// クラスターではすべてのキーが同じスロットにある必要があるため、ハッシュタグが異なるキーは組み合わせられない。
+// 異なるActivityを組み合わせる場合は同じNamespaceを設定する
+type Cohort struct {
+	Op   Op
+	Keys []string
+}
+
+// Intersect すべてのキーで活動したIDの集合
+func Intersect(keys ...string) *Cohort {
+	return &Cohort{Op: And, Keys: keys}
+}
+
+// Union いずれかのキーで活動したIDの集合
+func Union(keys ...string) *Cohort {
+	return &Cohort{Op: Or, Keys: keys}
+}
+
+// Count 集合に含まれるIDの数
+func (c *Cohort) Count(ctx context.Context) (count int64, err error) {
+	err = c.eval(ctx, func(ctx context.Context, pipe goredis.Pipeliner, dest string) func() {
+		cmd := pipe.BitCount(ctx, dest, nil)
+		return func() {
+			count = cmd.Val()
+		}
+	})
+	return
+}
+
+// Members 集合に含まれるIDを昇順で返す
+func (c *Cohort) Members(ctx context.Context) (ids []int64, err error) {
+	err = c.eval(ctx, func(ctx context.Context, pipe goredis.Pipeliner, dest string) func() {
+		cmd := pipe.Get(ctx, dest)
+		return func() {
+			ids = members([]byte(cmd.Val()))
+		}
+	})
+	return
+}
+
+// eval 一時キーにBITOPの結果を保存してfで読み込み、一時キーを削除する
+func (c *Cohort) eval(ctx context.Context, f func(ctx context.Context, pipe goredis.Pipeliner, dest string) func()) error {
+	if len(c.Keys) == 0 {
+		return errors.New("analytics: cohort requires at least one key")
+	}
+	tag := hashTag(c.Keys[0])
+	for _, key := range c.Keys[1:] {
+		if hashTag(key) != tag {
+			return fmt.Errorf("analytics: cohort keys must share a hash tag (set the same Namespace): %s, %s", c.Keys[0], key)
+		}
+	}
+	dest := tempKey(c.Keys[0])
+	return redis.Universal(ctx, func(ctx context.Context, cmd redis.Cmdable) error {
+		var result func()
+		_, err := cmd.TxPipelined(ctx, func(pipe goredis.Pipeliner) error {
+			switch c.Op {
+			case Or:
+				pipe.BitOpOr(ctx, dest, c.Keys...)
+			default:
+				pipe.BitOpAnd(ctx, dest, c.Keys...)
+			}
+			pipe.Expire(ctx, dest, tempExpiration)
+			result = f(ctx, pipe, dest)
+			pipe.Del(ctx, dest)
+			return nil
+		})
+		if err != nil && !redis.IsNil(err) {
+			return err
+		}
+		result()
+		return nil
+	})
+}
+
+// members ビットが立っている位置。SETBITのビット0は先頭バイトの最上位ビット
+func members(b []byte) []int64 {
+	var ids []int64
+	for i, v := range b {
+		for bit := 0; bit < 8; bit++ {
+			if v&(0x80>>bit) != 0 {
+				ids = append(ids, int64(i*8+bit))
+			}
+		}
+	}
+	return ids
+}
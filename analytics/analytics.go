@@ -0,0 +1,147 @@
+package analytics
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	Prefix = "analytics"
+)
+
+// Bucket 集計の時間単位
+type Bucket int
+
+const (
+	Daily Bucket = iota
+	Hourly
+	Monthly
+)
+
+func (b Bucket) String() string {
+	switch b {
+	case Hourly:
+		return "hourly"
+	case Monthly:
+		return "monthly"
+	}
+	return "daily"
+}
+
+// Start tを含む区間の開始時刻
+func (b Bucket) Start(t time.Time) time.Time {
+	switch b {
+	case Hourly:
+		return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), 0, 0, 0, t.Location())
+	case Monthly:
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, t.Location())
+	}
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+}
+
+// Next tを含む区間の次の区間の開始時刻
+func (b Bucket) Next(t time.Time) time.Time {
+	start := b.Start(t)
+	switch b {
+	case Hourly:
+		return start.Add(time.Hour)
+	case Monthly:
+		return start.AddDate(0, 1, 0)
+	}
+	return start.AddDate(0, 0, 1)
+}
+
+// Label キーに使う区間の表記
+func (b Bucket) Label(t time.Time) string {
+	switch b {
+	case Hourly:
+		return t.Format("2006-01-02T15")
+	case Monthly:
+		return t.Format("2006-01")
+	}
+	return t.Format("2006-01-02")
+}
+
+var location atomic.Value
+
+func init() {
+	location.Store(time.UTC)
+}
+
+// SetLocation 区間の計算に使うタイムゾーンの既定値を設定する。既定値はUTC
+func SetLocation(loc *time.Location) {
+	if loc == nil {
+		loc = time.UTC
+	}
+	location.Store(loc)
+}
+
+// series 時間で区切ったキーの系列
+type series struct {
+	kind      string
+	name      string
+	Bucket    Bucket
+	Retention time.Duration  // 区間の終了からキーを保持する期間。0の場合は期限を設定しない
+	Location  *time.Location // 区間の計算に使うタイムゾーン。既定値はSetLocationで設定した値
+	Namespace string         // ハッシュタグに使う名前空間。異なる系列をCohortで組み合わせる場合に同じ値を設定する。空の場合は系列名
+}
+
+func (s *series) location() *time.Location {
+	if s.Location == nil {
+		return location.Load().(*time.Location)
+	}
+	return s.Location
+}
+
+// Key tを含む区間のキー。クラスターでも同じ系列のキーを組み合わせられるようにハッシュタグを付ける
+func (s *series) Key(t time.Time) string {
+	label := s.Bucket.Label(t.In(s.location()))
+	if s.Namespace != "" {
+		return fmt.Sprintf("%s://%s/{%s}/%s/%s", Prefix, s.kind, s.Namespace, s.name, label)
+	}
+	return fmt.Sprintf("%s://%s/{%s}/%s", Prefix, s.kind, s.name, label)
+}
+
+// Keys fromからtoまでの区間のキー
+func (s *series) Keys(from, to time.Time) []string {
+	loc := s.location()
+	t, end := s.Bucket.Start(from.In(loc)), to.In(loc)
+	var keys []string
+	for !t.After(end) {
+		keys = append(keys, s.Key(t))
+		t = s.Bucket.Next(t)
+	}
+	return keys
+}
+
+// expireAt tを含む区間のキーの有効期限
+func (s *series) expireAt(t time.Time) (time.Time, bool) {
+	if s.Retention <= 0 {
+		return time.Time{}, false
+	}
+	return s.Bucket.Next(t.In(s.location())).Add(s.Retention), true
+}
+
+// hashTag クラスターでスロットの計算に使う部分。ハッシュタグがない場合はキー全体
+func hashTag(key string) string {
+	if s := strings.IndexByte(key, '{'); s >= 0 {
+		if e := strings.IndexByte(key[s+1:], '}'); e > 0 {
+			return key[s+1 : s+1+e]
+		}
+	}
+	return key
+}
+
+// tempKey 集計に使う一時キー。keyと同じスロットに配置する
+func tempKey(key string) string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return key + "/tmp/" + hex.EncodeToString(b)
+}
+
+// tempExpiration 一時キーの削除に失敗した場合の有効期限
+const tempExpiration = time.Minute
@@ -0,0 +1,127 @@
+package analytics
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/goccha/redis-verse/redistest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMain(m *testing.M) {
+	redistest.Main(m)
+}
+
+func TestBucket(t *testing.T) {
+	jst := time.FixedZone("JST", 9*60*60)
+	u := &Unique{series{kind: "unique", name: "visitors", Location: jst}}
+	// UTCでは前日でもJSTでは翌日
+	at := time.Date(2024, 1, 31, 20, 0, 0, 0, time.UTC)
+	assert.Equal(t, "analytics://unique/{visitors}/2024-02-01", u.Key(at))
+	u.Location = nil
+	assert.Equal(t, "analytics://unique/{visitors}/2024-01-31", u.Key(at))
+	SetLocation(jst)
+	assert.Equal(t, "analytics://unique/{visitors}/2024-02-01", u.Key(at))
+	SetLocation(nil)
+
+	u.Bucket = Hourly
+	assert.Equal(t, "analytics://unique/{visitors}/2024-01-31T20", u.Key(at))
+	u.Bucket = Monthly
+	assert.Equal(t, []string{
+		"analytics://unique/{visitors}/2023-12",
+		"analytics://unique/{visitors}/2024-01",
+		"analytics://unique/{visitors}/2024-02",
+	}, u.Keys(time.Date(2023, 12, 31, 0, 0, 0, 0, time.UTC), time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)))
+}
+
+func TestUnique(t *testing.T) {
+	ctx := context.Background()
+	u := NewUnique(redistest.New(t).Prefix+"visitors", Daily, 24*time.Hour)
+	day := time.Now().UTC()
+	for i := 0; i < 3; i++ {
+		d := day.AddDate(0, 0, i)
+		for j := 0; j < 100; j++ {
+			_, err := u.Add(ctx, d, fmt.Sprintf("user-%d", i*50+j))
+			assert.Nil(t, err)
+		}
+	}
+	changed, err := u.Add(ctx, day.AddDate(0, 0, 3), "user-0")
+	assert.Nil(t, err)
+	assert.True(t, changed)
+	assert.True(t, redistest.Server().TTL(u.Key(day)) > 24*time.Hour)
+
+	n, err := u.Count(ctx, day, day)
+	assert.Nil(t, err)
+	assert.InDelta(t, 100, n, 3)
+	n, err = u.Count(ctx, day, day.AddDate(0, 0, 2))
+	assert.Nil(t, err)
+	assert.InDelta(t, 200, n, 5)
+	for _, key := range redistest.Server().Keys() {
+		assert.NotContains(t, key, "/tmp/")
+	}
+}
+
+func TestActivity(t *testing.T) {
+	ctx := context.Background()
+	a := NewActivity(redistest.New(t).Prefix+"dau", Daily, 0)
+	day := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	assert.Nil(t, a.Mark(ctx, day, 1, 2, 3, 10))
+	assert.Nil(t, a.Mark(ctx, day.AddDate(0, 0, 1), 2, 3, 4))
+	assert.Nil(t, a.Mark(ctx, day.AddDate(0, 0, 2), 3, 5))
+
+	ok, err := a.Active(ctx, day, 10)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	ok, err = a.Active(ctx, day, 4)
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	n, err := a.Count(ctx, day)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(4), n)
+	assert.Equal(t, time.Duration(0), redistest.Server().TTL(a.Key(day)))
+
+	n, err = a.CountRange(ctx, day, day.AddDate(0, 0, 2))
+	assert.Nil(t, err)
+	assert.Equal(t, int64(6), n)
+
+	retained := Intersect(a.Keys(day, day.AddDate(0, 0, 2))...)
+	n, err = retained.Count(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), n)
+	ids, err := retained.Members(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, []int64{3}, ids)
+
+	ids, err = Union(a.Key(day), a.Key(day.AddDate(0, 0, 1))).Members(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, []int64{1, 2, 3, 4, 10}, ids)
+
+	ids, err = Intersect(a.Key(day.AddDate(0, 0, 10))).Members(ctx)
+	assert.Nil(t, err)
+	assert.Empty(t, ids)
+	for _, key := range redistest.Server().Keys() {
+		assert.NotContains(t, key, "/tmp/")
+	}
+}
+
+func TestCohort_Namespace(t *testing.T) {
+	ctx := context.Background()
+	day := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	purchases := NewActivity("purchases", Daily, 0)
+	logins := NewActivity("logins", Daily, 0)
+	assert.Nil(t, purchases.Mark(ctx, day, 1, 2))
+	assert.Nil(t, logins.Mark(ctx, day, 2, 3))
+	// 異なる系列はクラスターで同じスロットにならないため組み合わせられない
+	_, err := Intersect(purchases.Key(day), logins.Key(day)).Count(ctx)
+	assert.NotNil(t, err)
+
+	purchases.Namespace, logins.Namespace = "shop", "shop"
+	assert.Equal(t, "analytics://activity/{shop}/purchases/2024-03-01", purchases.Key(day))
+	assert.Nil(t, purchases.Mark(ctx, day, 1, 2))
+	assert.Nil(t, logins.Mark(ctx, day, 2, 3))
+	ids, err := Intersect(purchases.Key(day), logins.Key(day)).Members(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, []int64{2}, ids)
+}
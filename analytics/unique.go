@@ -0,0 +1,79 @@
+package analytics
+
+import (
+	"context"
+	"time"
+
+	"github.com/goccha/redis-verse/redis"
+	goredis "github.com/redis/go-redis/v9"
+)
+
+// Unique HyperLogLogで区間ごとのユニーク数を数える
+type Unique struct {
+	series
+}
+
+func NewUnique(name string, bucket Bucket, retention time.Duration) *Unique {
+	return &Unique{series{kind: "unique", name: name, Bucket: bucket, Retention: retention}}
+}
+
+// Add tを含む区間にidsを追加する。推定値が変化した場合はtrueを返す
+func (u *Unique) Add(ctx context.Context, t time.Time, ids ...string) (changed bool, err error) {
+	if len(ids) == 0 {
+		return false, nil
+	}
+	key := u.Key(t)
+	values := make([]interface{}, len(ids))
+	for i, id := range ids {
+		values[i] = id
+	}
+	err = redis.Universal(ctx, func(ctx context.Context, c redis.Cmdable) error {
+		var add *goredis.IntCmd
+		_, err := c.Pipelined(ctx, func(pipe goredis.Pipeliner) error {
+			add = pipe.PFAdd(ctx, key, values...)
+			if at, ok := u.expireAt(t); ok {
+				pipe.ExpireAt(ctx, key, at)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		changed = add.Val() > 0
+		return nil
+	})
+	return
+}
+
+// Count fromからtoまでの区間のユニーク数の推定値を返す。
+// 複数の区間の場合は一時キーにPFMERGEしてから数える
+func (u *Unique) Count(ctx context.Context, from, to time.Time) (count int64, err error) {
+	keys := u.Keys(from, to)
+	switch len(keys) {
+	case 0:
+		return 0, nil
+	case 1:
+		err = redis.ReadOnly(ctx, func(ctx context.Context, c redis.Cmdable) error {
+			count, err = c.PFCount(ctx, keys[0]).Result()
+			return err
+		})
+		return
+	}
+	tmp := tempKey(keys[0])
+	err = redis.Universal(ctx, func(ctx context.Context, c redis.Cmdable) error {
+		var cmd *goredis.IntCmd
+		_, err := c.TxPipelined(ctx, func(pipe goredis.Pipeliner) error {
+			pipe.PFMerge(ctx, tmp, keys...)
+			pipe.Expire(ctx, tmp, tempExpiration)
+			cmd = pipe.PFCount(ctx, tmp)
+			pipe.Del(ctx, tmp)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		count = cmd.Val()
+		return nil
+	})
+	return
+}
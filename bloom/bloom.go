@@ -0,0 +1,385 @@
+package bloom
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/goccha/redis-verse/redis"
+	goredis "github.com/redis/go-redis/v9"
+)
+
+const (
+	Prefix = "bloom"
+)
+
+const (
+	defaultGrowth     = 2
+	defaultTightening = 0.5
+	// maxBits Redisの文字列の上限
+	maxBits = 1 << 32
+	// batchSize 1回のパイプラインで送る要素の数
+	batchSize = 256
+)
+
+// Filter SETBIT/GETBITで実装したBloomフィルタ。ビットの位置はクライアントで計算する。
+// Growthが1より大きい場合は、最新の層がCapacityに達すると容量を増やした層を追加する
+type Filter struct {
+	name       string
+	Capacity   int64         // 最初の層に登録する要素数
+	FPRate     float64       // フィルタ全体の偽陽性率の上限
+	Growth     int64         // 層を追加する際の容量の倍率。既定値は2。1の場合は層を追加しない
+	Tightening float64       // 層を追加する際の偽陽性率の倍率。既定値は0.5
+	Expiration time.Duration // 0の場合は期限なし
+}
+
+func New(name string, capacity int64, fpRate float64) *Filter {
+	return &Filter{name: name, Capacity: capacity, FPRate: fpRate}
+}
+
+func (f *Filter) Name() string {
+	return f.name
+}
+
+func (f *Filter) meta() string {
+	return fmt.Sprintf("%s://{%s}/meta", Prefix, f.name)
+}
+
+// Key i番目の層のキー。同じフィルタのキーは同じスロットに配置する
+func (f *Filter) Key(i int) string {
+	return fmt.Sprintf("%s://{%s}/%d", Prefix, f.name, i)
+}
+
+func (f *Filter) growth() int64 {
+	if f.Growth <= 0 {
+		return defaultGrowth
+	}
+	return f.Growth
+}
+
+func (f *Filter) tightening() float64 {
+	if f.Tightening <= 0 || f.Tightening >= 1 {
+		return defaultTightening
+	}
+	return f.Tightening
+}
+
+// layer 層の大きさとハッシュ関数の数
+type layer struct {
+	capacity int64
+	bits     uint64
+	hashes   int
+}
+
+// layer i番目の層。各層の偽陽性率の合計がFPRateを超えないように、最初の層はFPRate*(1-Tightening)とする
+func (f *Filter) layer(i int) (layer, error) {
+	if f.Capacity <= 0 || f.FPRate <= 0 || f.FPRate >= 1 {
+		return layer{}, fmt.Errorf("bloom: invalid capacity %d or false positive rate %g of %s", f.Capacity, f.FPRate, f.name)
+	}
+	r := f.tightening()
+	if f.growth() == 1 {
+		r = 0
+	}
+	capacity := float64(f.Capacity) * math.Pow(float64(f.growth()), float64(i))
+	p := f.FPRate * (1 - r) * math.Pow(r, float64(i))
+	bits := math.Ceil(-capacity * math.Log(p) / (math.Ln2 * math.Ln2))
+	if bits > maxBits {
+		return layer{}, fmt.Errorf("bloom: layer %d of %s requires %.0f bits", i, f.name, bits)
+	}
+	hashes := int(math.Round(bits / capacity * math.Ln2))
+	if hashes < 1 {
+		hashes = 1
+	}
+	return layer{capacity: int64(capacity), bits: uint64(bits), hashes: hashes}, nil
+}
+
+// hash 2つのハッシュ値からビットの位置を求める(Kirsch-Mitzenmacher)
+type hash struct {
+	h1, h2 uint64
+}
+
+// newHash FNVは末尾の文字の違いが上位ビットに拡散しにくいため、splitmix64で撹拌する
+func newHash(item string) hash {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(item))
+	sum := h.Sum64()
+	return hash{h1: mix(sum), h2: mix(sum^0x9e3779b97f4a7c15) | 1}
+}
+
+func mix(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+func (h hash) positions(l layer) []int64 {
+	v := make([]int64, l.hashes)
+	for i := range v {
+		v[i] = int64((h.h1 + uint64(i)*h.h2) % l.bits)
+	}
+	return v
+}
+
+// grow 最新の層の登録数を加算し、容量に達した場合は層を追加する。
+// KEYS: メタデータ
+// ARGV: 層, 追加した数, 層の容量, 層を追加するか, 有効期限(ms)
+var grow = goredis.NewScript(`
+local count = redis.call('HINCRBY', KEYS[1], 'count:' .. ARGV[1], ARGV[2])
+local layers = tonumber(redis.call('HGET', KEYS[1], 'layers') or '1')
+if ARGV[4] == '1' and count >= tonumber(ARGV[3]) and layers == tonumber(ARGV[1]) + 1 then
+	layers = layers + 1
+	redis.call('HSET', KEYS[1], 'layers', layers)
+end
+if tonumber(ARGV[5]) > 0 then
+	redis.call('PEXPIRE', KEYS[1], ARGV[5])
+end
+return layers`)
+
+func (f *Filter) layers(ctx context.Context, c redis.Cmdable) (int, error) {
+	n, err := c.HGet(ctx, f.meta(), "layers").Int()
+	if redis.IsNil(err) {
+		return 1, nil
+	} else if err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// contains 各要素が層のいずれかに含まれるかを調べる。
+// 要素の多い新しい層から順に調べ、見つかった要素は以前の層を調べない。GETBITはbatchSizeの要素ごとにパイプラインで送る
+func (f *Filter) contains(ctx context.Context, c redis.Cmdable, hashes []hash, layers int) ([]bool, error) {
+	found := make([]bool, len(hashes))
+	for i := layers - 1; i >= 0; i-- {
+		var pending []int
+		for j := range hashes {
+			if !found[j] {
+				pending = append(pending, j)
+			}
+		}
+		if len(pending) == 0 {
+			break
+		}
+		l, err := f.layer(i)
+		if err != nil {
+			return nil, err
+		}
+		key := f.Key(i)
+		for start := 0; start < len(pending); start += batchSize {
+			end := start + batchSize
+			if end > len(pending) {
+				end = len(pending)
+			}
+			batch := pending[start:end]
+			cmds := make([][]*goredis.IntCmd, len(batch))
+			if _, err = c.Pipelined(ctx, func(pipe goredis.Pipeliner) error {
+				for k, j := range batch {
+					for _, pos := range hashes[j].positions(l) {
+						cmds[k] = append(cmds[k], pipe.GetBit(ctx, key, pos))
+					}
+				}
+				return nil
+			}); err != nil {
+				return nil, err
+			}
+		next:
+			for k, j := range batch {
+				for _, cmd := range cmds[k] {
+					if cmd.Val() == 0 {
+						continue next
+					}
+				}
+				found[j] = true
+			}
+		}
+	}
+	return found, nil
+}
+
+// Add 要素を登録する。既に登録されている(偽陽性を含む)要素はfalseを返す
+func (f *Filter) Add(ctx context.Context, items ...string) (added []bool, err error) {
+	if len(items) == 0 {
+		return nil, nil
+	}
+	hashes := make([]hash, len(items))
+	for i, item := range items {
+		hashes[i] = newHash(item)
+	}
+	err = redis.Universal(ctx, func(ctx context.Context, c redis.Cmdable) error {
+		added = make([]bool, len(items))
+		for done := 0; done < len(items); {
+			info, err := f.info(ctx, c)
+			if err != nil {
+				return err
+			}
+			l, err := f.layer(info.Layers - 1)
+			if err != nil {
+				return err
+			}
+			// 最新の層の容量とパイプラインの大きさを超えないように分割して登録する
+			size := len(items) - done
+			if size > batchSize {
+				size = batchSize
+			}
+			if remaining := l.capacity - info.counts[info.Layers-1]; f.growth() > 1 && remaining < int64(size) {
+				if remaining <= 0 {
+					// 最新の層が容量に達している場合は、1件ずつ登録せずに層を追加してから分割し直す
+					if err = f.count(ctx, c, info.Layers-1, 0, l); err != nil {
+						return err
+					}
+					continue
+				}
+				size = int(remaining)
+			}
+			if err = f.add(ctx, c, hashes[done:done+size], added[done:done+size], info.Layers, l); err != nil {
+				return err
+			}
+			done += size
+		}
+		return nil
+	})
+	return
+}
+
+// add 最新の層にビットを立て、新たに登録した要素をaddedに設定する
+func (f *Filter) add(ctx context.Context, c redis.Cmdable, hashes []hash, added []bool, n int, l layer) error {
+	// 以前の層に含まれる要素は最新の層に登録しない
+	for i := range added {
+		added[i] = true
+	}
+	if n > 1 {
+		found, err := f.contains(ctx, c, hashes, n-1)
+		if err != nil {
+			return err
+		}
+		for i, ok := range found {
+			added[i] = !ok
+		}
+	}
+	current := n - 1
+	key := f.Key(current)
+	cmds := make([][]*goredis.IntCmd, len(hashes))
+	if _, err := c.Pipelined(ctx, func(pipe goredis.Pipeliner) error {
+		for i, h := range hashes {
+			if !added[i] {
+				continue
+			}
+			for _, pos := range h.positions(l) {
+				cmds[i] = append(cmds[i], pipe.SetBit(ctx, key, pos, 1))
+			}
+		}
+		if f.Expiration > 0 {
+			for i := 0; i < n; i++ {
+				pipe.Expire(ctx, f.Key(i), f.Expiration)
+			}
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+	// 1つでも新たにビットを立てた要素は未登録だった
+	var count int
+	for i, bits := range cmds {
+		if !added[i] {
+			continue
+		}
+		added[i] = false
+		for _, cmd := range bits {
+			if cmd.Val() == 0 {
+				added[i] = true
+				count++
+				break
+			}
+		}
+	}
+	if count == 0 {
+		return nil
+	}
+	return f.count(ctx, c, current, count, l)
+}
+
+// count current番目の層の登録数にnを加算し、容量に達した場合は層を追加する
+func (f *Filter) count(ctx context.Context, c redis.Cmdable, current, n int, l layer) error {
+	scalable := "0"
+	if f.growth() > 1 {
+		scalable = "1"
+	}
+	return grow.Run(ctx, c, []string{f.meta()}, current, n, l.capacity, scalable, f.Expiration.Milliseconds()).Err()
+}
+
+// Exists 各要素が登録されているかを返す。未登録の要素をFPRateの確率でtrueとする
+func (f *Filter) Exists(ctx context.Context, items ...string) (found []bool, err error) {
+	if len(items) == 0 {
+		return nil, nil
+	}
+	hashes := make([]hash, len(items))
+	for i, item := range items {
+		hashes[i] = newHash(item)
+	}
+	err = redis.ReadOnly(ctx, func(ctx context.Context, c redis.Cmdable) error {
+		n, err := f.layers(ctx, c)
+		if err != nil {
+			return err
+		}
+		found, err = f.contains(ctx, c, hashes, n)
+		return err
+	})
+	return
+}
+
+// Info フィルタの状態
+type Info struct {
+	Layers int
+	Count  int64 // 登録した要素数の概算
+	counts map[int]int64
+}
+
+func (f *Filter) Info(ctx context.Context) (info Info, err error) {
+	err = redis.ReadOnly(ctx, func(ctx context.Context, c redis.Cmdable) error {
+		info, err = f.info(ctx, c)
+		return err
+	})
+	return
+}
+
+func (f *Filter) info(ctx context.Context, c redis.Cmdable) (Info, error) {
+	values, err := c.HGetAll(ctx, f.meta()).Result()
+	if err != nil {
+		return Info{}, err
+	}
+	info := Info{Layers: 1, counts: make(map[int]int64)}
+	for k, v := range values {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return Info{}, fmt.Errorf("bloom: invalid %s of %s: %w", k, f.name, err)
+		}
+		if k == "layers" {
+			info.Layers = int(n)
+		} else if i, err := strconv.Atoi(strings.TrimPrefix(k, "count:")); err == nil {
+			info.counts[i] = n
+			info.Count += n
+		}
+	}
+	return info, nil
+}
+
+// Clear すべての層を削除する
+func (f *Filter) Clear(ctx context.Context) error {
+	return redis.Universal(ctx, func(ctx context.Context, c redis.Cmdable) error {
+		n, err := f.layers(ctx, c)
+		if err != nil {
+			return err
+		}
+		keys := []string{f.meta()}
+		for i := 0; i < n; i++ {
+			keys = append(keys, f.Key(i))
+		}
+		return c.Del(ctx, keys...).Err()
+	})
+}
@@ -0,0 +1,142 @@
+package bloom
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/goccha/redis-verse/redistest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMain(m *testing.M) {
+	redistest.Main(m)
+}
+
+func items(prefix string, from, to int) []string {
+	v := make([]string, 0, to-from)
+	for i := from; i < to; i++ {
+		v = append(v, fmt.Sprintf("%s-%d", prefix, i))
+	}
+	return v
+}
+
+// falsePositiveRate 登録していない要素がtrueになる割合
+func falsePositiveRate(t *testing.T, f *Filter, n int) float64 {
+	found, err := f.Exists(context.Background(), items("absent", 0, n)...)
+	assert.Nil(t, err)
+	var fp int
+	for _, ok := range found {
+		if ok {
+			fp++
+		}
+	}
+	return float64(fp) / float64(n)
+}
+
+func TestLayer(t *testing.T) {
+	f := New("layer", 1000, 0.01)
+	l, err := f.layer(0)
+	assert.Nil(t, err)
+	// FPRate*(1-Tightening) = 0.005
+	assert.Equal(t, uint64(11028), l.bits)
+	assert.Equal(t, 8, l.hashes)
+	l, err = f.layer(1)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(2000), l.capacity)
+	assert.Equal(t, 9, l.hashes)
+
+	f.Growth = 1
+	l, _ = f.layer(0)
+	assert.Equal(t, uint64(9586), l.bits)
+	assert.Equal(t, 7, l.hashes)
+
+	_, err = New("invalid", 1000, 1).layer(0)
+	assert.NotNil(t, err)
+	_, err = New("huge", 1<<32, 0.001).layer(0)
+	assert.NotNil(t, err)
+}
+
+func TestFilter(t *testing.T) {
+	ctx := context.Background()
+	f := New(redistest.New(t).Prefix+"seen", 1000, 0.01)
+	f.Growth = 1
+	f.Expiration = time.Hour
+	added, err := f.Add(ctx, items("member", 0, 1000)...)
+	assert.Nil(t, err)
+	var n int
+	for _, ok := range added {
+		if ok {
+			n++
+		}
+	}
+	assert.InDelta(t, 1000, n, 10)
+	added, err = f.Add(ctx, "member-0", "member-999")
+	assert.Nil(t, err)
+	assert.Equal(t, []bool{false, false}, added)
+	assert.Equal(t, time.Hour, redistest.Server().TTL(f.Key(0)))
+
+	// 偽陰性はない
+	found, err := f.Exists(ctx, items("member", 0, 1000)...)
+	assert.Nil(t, err)
+	for _, ok := range found {
+		assert.True(t, ok)
+	}
+	rate := falsePositiveRate(t, f, 20000)
+	assert.True(t, rate <= 0.015, rate)
+
+	info, err := f.Info(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, info.Layers)
+	assert.Equal(t, int64(n), info.Count)
+
+	assert.Nil(t, f.Clear(ctx))
+	found, err = f.Exists(ctx, "member-0")
+	assert.Nil(t, err)
+	assert.Equal(t, []bool{false}, found)
+}
+
+func TestScalable(t *testing.T) {
+	ctx := context.Background()
+	f := New(redistest.New(t).Prefix+"scalable", 500, 0.01)
+	for i := 0; i < 3500; i += 250 {
+		_, err := f.Add(ctx, items("member", i, i+250)...)
+		assert.Nil(t, err)
+	}
+	info, err := f.Info(ctx)
+	assert.Nil(t, err)
+	// 500 + 1000 + 2000 >= 3500
+	assert.Equal(t, 3, info.Layers)
+	assert.InDelta(t, 3500, info.Count, 70)
+
+	found, err := f.Exists(ctx, items("member", 0, 3500)...)
+	assert.Nil(t, err)
+	for _, ok := range found {
+		assert.True(t, ok)
+	}
+	// 以前の層に含まれる要素は最新の層に登録しない
+	added, err := f.Add(ctx, "member-0")
+	assert.Nil(t, err)
+	assert.Equal(t, []bool{false}, added)
+
+	rate := falsePositiveRate(t, f, 20000)
+	assert.True(t, rate <= 0.015, rate)
+}
+
+func TestScalable_Full(t *testing.T) {
+	ctx := context.Background()
+	f := New(redistest.New(t).Prefix+"full", 100, 0.01)
+	_, err := f.Add(ctx, items("member", 0, 50)...)
+	assert.Nil(t, err)
+	// 容量を減らしたなどで最新の層が容量に達している場合は、層を追加してからまとめて登録する
+	f.Capacity = 50
+	redistest.Server().HSet(f.meta(), "count:0", "60")
+	_, err = f.Add(ctx, items("member", 50, 100)...)
+	assert.Nil(t, err)
+	info, err := f.Info(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, info.Layers)
+	assert.Equal(t, int64(60), info.counts[0])
+	assert.InDelta(t, 50, info.counts[1], 2)
+}
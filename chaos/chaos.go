@@ -0,0 +1,243 @@
+package chaos
+
+import (
+	"context"
+	"math/rand"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/goccha/redis-verse/redis"
+	goredis "github.com/redis/go-redis/v9"
+)
+
+// Role 障害を注入するクライアント。
+// リーダーがプライマリと同じクライアントの場合(ReaderHostが未指定等)は区別できないため、Readerのルールは一致せず、Primaryのルールが読み込みにも一致する
+type Role int
+
+const (
+	Any Role = iota
+	Primary
+	Reader
+)
+
+// RedisError Redisサーバーが返すエラーを模したエラー
+type RedisError string
+
+func (e RedisError) Error() string {
+	return string(e)
+}
+
+func (e RedisError) RedisError() {}
+
+var (
+	ErrReadOnly = RedisError("READONLY You can't write against a read only replica.")
+	ErrLoading  = RedisError("LOADING Redis is loading the dataset in memory")
+	ErrOOM      = RedisError("OOM command not allowed when used memory > 'maxmemory'.")
+	// ErrConnReset 接続が切断された場合と同じエラー。エラーを返すだけで接続は切断しないため、コネクションプールの再接続は発生しない
+	ErrConnReset = &net.OpError{Op: "read", Net: "tcp", Err: syscall.ECONNRESET}
+	ErrRefused   = &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}
+)
+
+func Moved(slot int, addr string) RedisError {
+	return RedisError("MOVED " + strconv.Itoa(slot) + " " + addr)
+}
+
+func Ask(slot int, addr string) RedisError {
+	return RedisError("ASK " + strconv.Itoa(slot) + " " + addr)
+}
+
+// Rule 障害の注入条件。接続を切断するのではなく、遅延とエラーをコマンドの結果に注入する
+type Rule struct {
+	Commands  []string      // 対象コマンド。空の場合は全て
+	Keys      string        // 対象キーのパターン(KEYSと同じglob形式)。空の場合は全て
+	Role      Role          // 対象クライアント。Readerはリーダーがプライマリと別のクライアントの場合だけ一致する
+	Latency   time.Duration // 追加する遅延
+	Jitter    time.Duration // 遅延のゆらぎ
+	ErrorRate float64       // エラーを返す確率(0〜1)
+	Err       error         // 返すエラー。未指定の場合はErrConnReset
+	Refuse    bool          // 新しい接続を拒否する(既存の接続は切断されない)
+}
+
+func (r *Rule) match(role Role, cmd goredis.Cmder) bool {
+	if r.Role != Any && r.Role != role {
+		return false
+	}
+	if len(r.Commands) > 0 {
+		found := false
+		for _, c := range r.Commands {
+			if strings.EqualFold(c, cmd.Name()) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if r.Keys != "" {
+		found := false
+		for _, k := range redis.CommandKeys(cmd) {
+			if redis.MatchKey(r.Keys, k) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+func (r *Rule) err() error {
+	if r.Err != nil {
+		return r.Err
+	}
+	return ErrConnReset
+}
+
+// Builder 任意のBuilderで作成したクライアントに障害を注入する
+type Builder struct {
+	Builder redis.Builder
+	mu      sync.RWMutex
+	rules   []Rule
+	rand    *rand.Rand
+}
+
+func Wrap(b redis.Builder, rules ...Rule) *Builder {
+	return &Builder{
+		Builder: b,
+		rules:   rules,
+		rand:    rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+func (b *Builder) Build(ctx context.Context, db ...int) (primary *redis.PrimaryClient, reader *redis.ReaderClient, err error) {
+	if primary, reader, err = b.Builder.Build(ctx, db...); err != nil {
+		return
+	}
+	primary.Client.AddHook(&hook{builder: b, role: Primary})
+	if c, ok := reader.Client.(interface{ AddHook(goredis.Hook) }); ok && reader.Client != primary.Client {
+		c.AddHook(&hook{builder: b, role: Reader})
+	}
+	return
+}
+
+// Set 注入ルールを置き換える
+func (b *Builder) Set(rules ...Rule) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.rules = rules
+}
+
+func (b *Builder) Add(rule Rule) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.rules = append(b.rules, rule)
+}
+
+func (b *Builder) Clear() {
+	b.Set()
+}
+
+func (b *Builder) Seed(seed int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.rand = rand.New(rand.NewSource(seed))
+}
+
+func (b *Builder) float64() float64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.rand == nil {
+		b.rand = rand.New(rand.NewSource(time.Now().UnixNano()))
+	}
+	return b.rand.Float64()
+}
+
+func (b *Builder) matched(role Role, cmds []goredis.Cmder) []Rule {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	var rules []Rule
+	for _, r := range b.rules {
+		for _, cmd := range cmds {
+			if r.match(role, cmd) {
+				rules = append(rules, r)
+				break
+			}
+		}
+	}
+	return rules
+}
+
+func (b *Builder) refused(role Role) bool {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for _, r := range b.rules {
+		if r.Refuse && (r.Role == Any || r.Role == role) {
+			return true
+		}
+	}
+	return false
+}
+
+func (b *Builder) inject(ctx context.Context, role Role, cmds []goredis.Cmder) error {
+	for _, r := range b.matched(role, cmds) {
+		if d := r.Latency; d > 0 || r.Jitter > 0 {
+			if r.Jitter > 0 {
+				d += time.Duration(b.float64() * float64(r.Jitter))
+			}
+			t := time.NewTimer(d)
+			select {
+			case <-ctx.Done():
+				t.Stop()
+				return ctx.Err()
+			case <-t.C:
+			}
+		}
+		if r.ErrorRate > 0 && b.float64() < r.ErrorRate {
+			return r.err()
+		}
+	}
+	return nil
+}
+
+type hook struct {
+	builder *Builder
+	role    Role
+}
+
+func (h *hook) DialHook(next goredis.DialHook) goredis.DialHook {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if h.builder.refused(h.role) {
+			return nil, ErrRefused
+		}
+		return next(ctx, network, addr)
+	}
+}
+
+func (h *hook) ProcessHook(next goredis.ProcessHook) goredis.ProcessHook {
+	return func(ctx context.Context, cmd goredis.Cmder) error {
+		if err := h.builder.inject(ctx, h.role, []goredis.Cmder{cmd}); err != nil {
+			cmd.SetErr(err)
+			return err
+		}
+		return next(ctx, cmd)
+	}
+}
+
+func (h *hook) ProcessPipelineHook(next goredis.ProcessPipelineHook) goredis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []goredis.Cmder) error {
+		if err := h.builder.inject(ctx, h.role, cmds); err != nil {
+			for _, cmd := range cmds {
+				cmd.SetErr(err)
+			}
+			return err
+		}
+		return next(ctx, cmds)
+	}
+}
@@ -0,0 +1,91 @@
+package chaos
+
+import (
+	"context"
+	"errors"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/goccha/redis-verse/redis"
+	"github.com/goccha/redis-verse/redistest"
+	"github.com/stretchr/testify/assert"
+)
+
+var builder *Builder
+
+func TestMain(m *testing.M) {
+	ctx := context.Background()
+	builder = Wrap(&redistest.MemoryBuilder{})
+	if err := redis.Setup(ctx, builder); err != nil {
+		panic(err)
+	}
+	if err := redis.WaitForActivation(ctx); err != nil {
+		os.Exit(1)
+	}
+	code := m.Run()
+	os.Exit(code)
+}
+
+func TestBuilder_Error(t *testing.T) {
+	ctx := context.Background()
+	defer builder.Clear()
+	builder.Set(Rule{
+		Commands:  []string{"set"},
+		Keys:      "chaos://*",
+		ErrorRate: 1,
+		Err:       ErrReadOnly,
+	})
+	// *は/にも一致する
+	err := redis.Primary().Set(ctx, "chaos://readonly/1", "1", time.Minute).Err()
+	assert.Equal(t, ErrReadOnly, err)
+	builder.Set(Rule{
+		Commands:  []string{"set"},
+		Keys:      "chaos://readonly/*",
+		ErrorRate: 1,
+		Err:       ErrReadOnly,
+	})
+	assert.Nil(t, redis.Primary().Set(ctx, "chaos://other/1", "1", time.Minute).Err())
+	assert.True(t, redis.IsNil(redis.Primary().Get(ctx, "chaos://readonly/1").Err()))
+
+	builder.Set(Rule{Keys: "chaos://readonly/*", ErrorRate: 1, Err: Moved(3999, "127.0.0.1:6381")})
+	err = redis.Primary().Get(ctx, "chaos://readonly/1").Err()
+	assert.EqualError(t, err, "MOVED 3999 127.0.0.1:6381")
+
+	builder.Set(Rule{Keys: "chaos://readonly/*", ErrorRate: 1})
+	err = redis.Primary().Get(ctx, "chaos://readonly/1").Err()
+	assert.True(t, errors.Is(err, syscall.ECONNRESET))
+
+	builder.Clear()
+	assert.Nil(t, redis.Primary().Set(ctx, "chaos://readonly/1", "1", time.Minute).Err())
+}
+
+func TestBuilder_Latency(t *testing.T) {
+	ctx := context.Background()
+	defer builder.Clear()
+	builder.Set(Rule{Commands: []string{"get"}, Latency: 100 * time.Millisecond})
+	start := time.Now()
+	_ = redis.Primary().Get(ctx, "chaos://latency").Err()
+	assert.True(t, time.Since(start) >= 100*time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	err := redis.Primary().Get(ctx, "chaos://latency").Err()
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+}
+
+func TestBuilder_ErrorRate(t *testing.T) {
+	ctx := context.Background()
+	defer builder.Clear()
+	builder.Seed(1)
+	builder.Set(Rule{Commands: []string{"incr"}, ErrorRate: 0.5, Err: ErrLoading})
+	failed := 0
+	for i := 0; i < 200; i++ {
+		if err := redis.Primary().Incr(ctx, "chaos://rate").Err(); err != nil {
+			assert.Equal(t, ErrLoading, err)
+			failed++
+		}
+	}
+	assert.InDelta(t, 100, failed, 30)
+}
@@ -0,0 +1,261 @@
+// redis-verse ロック、ガード、スロットルの状態を確認・操作するコマンド
+//
+//	redis-verse [-config file] [-profile name] [-name connection] [-json] <command> [args]
+//
+//	locks [pattern]                       ロック中のキーと残り時間を一覧する
+//	unlock <key>...                       ロックを解除する
+//	attempts <key>                        LockCounterに記録されている試行を表示する
+//	reset [-unlock] <key>...              LockCounterの試行を削除する
+//	throttle [-rate n] [-per d] <id>...   スロットルの使用状況を表示する
+//
+// 接続先は-configまたはREDIS_CONFIG_FILEが指定されていれば設定ファイル、それ以外は環境変数から決定する
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/go-redis/redis_rate/v10"
+	"github.com/goccha/redis-verse/guards"
+	"github.com/goccha/redis-verse/locks"
+	"github.com/goccha/redis-verse/redis"
+	"github.com/goccha/redis-verse/throttles"
+)
+
+func main() {
+	if err := run(context.Background(), os.Args[1:], os.Stdout, os.Stderr); err != nil {
+		if !errors.Is(err, flag.ErrHelp) {
+			fmt.Fprintln(os.Stderr, "redis-verse:", err)
+		}
+		os.Exit(2)
+	}
+}
+
+type options struct {
+	config  string
+	profile string
+	name    string
+	json    bool
+	out     io.Writer
+}
+
+// newBuilder 接続に使うBuilder。テストで差し替える
+var newBuilder = func(o *options) redis.Builder {
+	if o.config != "" || redis.ConfigFile() != "" {
+		return &redis.ConfigBuilder{Path: o.config, Profile: o.profile, Name: o.name}
+	}
+	return &redis.EnvBuilder{}
+}
+
+var commands = map[string]func(ctx context.Context, o *options, args []string) error{
+	"locks":    listLocks,
+	"unlock":   unlock,
+	"attempts": attempts,
+	"reset":    reset,
+	"throttle": throttle,
+}
+
+func run(ctx context.Context, args []string, stdout, stderr io.Writer) error {
+	o := &options{out: stdout}
+	fs := flag.NewFlagSet("redis-verse", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.StringVar(&o.config, "config", "", "config file (default $REDIS_CONFIG_FILE)")
+	fs.StringVar(&o.profile, "profile", "", "config profile (default $REDIS_PROFILE)")
+	fs.StringVar(&o.name, "name", "", "connection name in the config file")
+	fs.BoolVar(&o.json, "json", false, "output JSON")
+	fs.Usage = func() {
+		fmt.Fprintln(stderr, "usage: redis-verse [flags] locks|unlock|attempts|reset|throttle [args]")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return flag.ErrHelp
+	}
+	command, ok := commands[fs.Arg(0)]
+	if !ok {
+		fs.Usage()
+		return fmt.Errorf("unknown command %q", fs.Arg(0))
+	}
+	if err := redis.Setup(ctx, newBuilder(o)); err != nil {
+		return err
+	}
+	defer func() {
+		_ = redis.Shutdown(ctx)
+	}()
+	return command(ctx, o, fs.Args()[1:])
+}
+
+// print JSONまたはタブ区切りの表で出力する
+func (o *options) print(v interface{}, header string, rows func(w io.Writer)) error {
+	if o.json {
+		enc := json.NewEncoder(o.out)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	}
+	w := tabwriter.NewWriter(o.out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, header)
+	rows(w)
+	return w.Flush()
+}
+
+func requireArgs(args []string, usage string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: redis-verse %s", usage)
+	}
+	return nil
+}
+
+func listLocks(ctx context.Context, o *options, args []string) error {
+	pattern := ""
+	if len(args) > 0 {
+		pattern = args[0]
+	}
+	entries, err := locks.List(ctx, pattern)
+	if err != nil {
+		return err
+	}
+	values := make([]lock, 0, len(entries))
+	for _, e := range entries {
+		values = append(values, lock{Key: e.Key, TTL: milliseconds(e.TTL), Expires: e.Expires})
+	}
+	return o.print(values, "KEY\tTTL\tEXPIRES", func(w io.Writer) {
+		for _, e := range entries {
+			expires := "-"
+			if e.Expires != nil {
+				expires = e.Expires.Format(time.RFC3339)
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\n", e.Key, e.TTL.Round(time.Second), expires)
+		}
+	})
+}
+
+// lock JSONで出力するロック。残り時間はミリ秒で、期限がない場合は-1
+type lock struct {
+	Key     string     `json:"key"`
+	TTL     int64      `json:"ttl_ms"`
+	Expires *time.Time `json:"expires"`
+}
+
+func milliseconds(d time.Duration) int64 {
+	if d < 0 {
+		return -1
+	}
+	return d.Milliseconds()
+}
+
+type result struct {
+	Key     string `json:"key"`
+	Changed bool   `json:"changed"`
+}
+
+func printResults(o *options, results []result, label string) error {
+	return o.print(results, "KEY\t"+label, func(w io.Writer) {
+		for _, r := range results {
+			fmt.Fprintf(w, "%s\t%t\n", r.Key, r.Changed)
+		}
+	})
+}
+
+func unlock(ctx context.Context, o *options, args []string) error {
+	if err := requireArgs(args, "unlock <key>..."); err != nil {
+		return err
+	}
+	results := make([]result, 0, len(args))
+	for _, key := range args {
+		ok, err := locks.Unlock(ctx, key)
+		if err != nil {
+			return err
+		}
+		results = append(results, result{Key: key, Changed: ok})
+	}
+	return printResults(o, results, "UNLOCKED")
+}
+
+func attempts(ctx context.Context, o *options, args []string) error {
+	if err := requireArgs(args, "attempts <key>"); err != nil {
+		return err
+	}
+	values, err := guards.Attempts(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	return o.print(values, "EXPIRES", func(w io.Writer) {
+		for _, v := range values {
+			fmt.Fprintln(w, v.Format(time.RFC3339))
+		}
+	})
+}
+
+func reset(ctx context.Context, o *options, args []string) error {
+	fs := flag.NewFlagSet("reset", flag.ContinueOnError)
+	withUnlock := fs.Bool("unlock", false, "also unlock the key")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := requireArgs(fs.Args(), "reset [-unlock] <key>..."); err != nil {
+		return err
+	}
+	results := make([]result, 0, fs.NArg())
+	for _, key := range fs.Args() {
+		ok, err := guards.Reset(ctx, key)
+		if err != nil {
+			return err
+		}
+		if *withUnlock {
+			unlocked, err := locks.Unlock(ctx, key)
+			if err != nil {
+				return err
+			}
+			ok = ok || unlocked
+		}
+		results = append(results, result{Key: key, Changed: ok})
+	}
+	return printResults(o, results, "RESET")
+}
+
+type usage struct {
+	ID         string `json:"id"`
+	Limit      string `json:"limit"`
+	Remaining  int    `json:"remaining"`
+	ResetAfter int64  `json:"reset_after_ms"`
+}
+
+func throttle(ctx context.Context, o *options, args []string) error {
+	fs := flag.NewFlagSet("throttle", flag.ContinueOnError)
+	rate := fs.Int("rate", 1, "requests per period")
+	per := fs.Duration("per", time.Second, "period")
+	burst := fs.Int("burst", 0, "burst (default rate)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := requireArgs(fs.Args(), "throttle [-rate n] [-per d] [-burst n] <id>..."); err != nil {
+		return err
+	}
+	limit := redis_rate.Limit{Rate: *rate, Period: *per, Burst: *burst}
+	if limit.Burst <= 0 {
+		limit.Burst = limit.Rate
+	}
+	values := make([]usage, 0, fs.NArg())
+	for _, id := range fs.Args() {
+		r, err := throttles.Usage(ctx, id, limit)
+		if err != nil {
+			return err
+		}
+		values = append(values, usage{ID: id, Limit: limit.String(), Remaining: r.Remaining, ResetAfter: milliseconds(r.ResetAfter)})
+	}
+	return o.print(values, "ID\tLIMIT\tREMAINING\tRESET_AFTER", func(w io.Writer) {
+		for _, v := range values {
+			fmt.Fprintf(w, "%s\t%s\t%d\t%s\n", v.ID, v.Limit, v.Remaining, time.Duration(v.ResetAfter)*time.Millisecond)
+		}
+	})
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/goccha/redis-verse/redis"
+	"github.com/goccha/redis-verse/redistest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRun(t *testing.T) {
+	ctx := context.Background()
+	b := &redistest.MemoryBuilder{}
+	defer b.Close()
+	newBuilder = func(o *options) redis.Builder { return b }
+	exec := func(args ...string) (string, error) {
+		var out bytes.Buffer
+		err := run(ctx, args, &out, &out)
+		return out.String(), err
+	}
+	if _, err := exec("locks"); err != nil {
+		t.Fatal(err)
+	}
+	assert.Nil(t, b.Server().Set("blocks://user-1", redis.Locked))
+	b.Server().SetTTL("blocks://user-1", time.Hour)
+	assert.Nil(t, b.Server().Set("blocks://user-2", redis.Locked))
+	assert.Nil(t, b.Server().Set("lock-count://user-1", "[1700000000,1700000060]"))
+
+	out, err := exec("locks")
+	assert.Nil(t, err)
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	assert.Len(t, lines, 3)
+	assert.True(t, strings.HasPrefix(lines[1], "user-1  1h0m0s"), out)
+
+	out, err = exec("-json", "locks", "user-2")
+	assert.Nil(t, err)
+	var entries []map[string]interface{}
+	assert.Nil(t, json.Unmarshal([]byte(out), &entries))
+	assert.Len(t, entries, 1)
+	assert.Equal(t, "user-2", entries[0]["key"])
+	assert.Nil(t, entries[0]["expires"])
+	assert.Equal(t, float64(-1), entries[0]["ttl_ms"])
+
+	out, err = exec("-json", "locks", "user-1")
+	assert.Nil(t, err)
+	assert.Nil(t, json.Unmarshal([]byte(out), &entries))
+	assert.Equal(t, float64(time.Hour.Milliseconds()), entries[0]["ttl_ms"])
+
+	out, err = exec("-json", "attempts", "user-1")
+	assert.Nil(t, err)
+	var attempts []time.Time
+	assert.Nil(t, json.Unmarshal([]byte(out), &attempts))
+	assert.Equal(t, []int64{1700000000, 1700000060}, []int64{attempts[0].Unix(), attempts[1].Unix()})
+
+	out, err = exec("-json", "reset", "-unlock", "user-1", "user-3")
+	assert.Nil(t, err)
+	var results []result
+	assert.Nil(t, json.Unmarshal([]byte(out), &results))
+	assert.Equal(t, []result{{Key: "user-1", Changed: true}, {Key: "user-3", Changed: false}}, results)
+	assert.False(t, b.Server().Exists("lock-count://user-1"))
+	assert.False(t, b.Server().Exists("blocks://user-1"))
+
+	out, err = exec("unlock", "user-2")
+	assert.Nil(t, err)
+	assert.Contains(t, out, "user-2  true")
+
+	out, err = exec("-json", "throttle", "-rate", "10", "-per", "1m", "api:user-1")
+	assert.Nil(t, err)
+	var usages []usage
+	assert.Nil(t, json.Unmarshal([]byte(out), &usages))
+	assert.Equal(t, 10, usages[0].Remaining)
+	assert.Equal(t, "10 req/m (burst 10)", usages[0].Limit)
+	assert.Contains(t, out, `"reset_after_ms": 0`)
+
+	_, err = exec("unknown")
+	assert.EqualError(t, err, `unknown command "unknown"`)
+	_, err = exec("attempts")
+	assert.EqualError(t, err, "usage: redis-verse attempts <key>")
+}
@@ -0,0 +1,239 @@
+package counters
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/goccha/logging/log"
+	"github.com/goccha/redis-verse/redis"
+	goredis "github.com/redis/go-redis/v9"
+)
+
+const (
+	Prefix = "counter"
+)
+
+// ErrStopped Stopの後にローカルで集計しようとした
+var ErrStopped = errors.New("counters: counter is stopped")
+
+// Counter INCRを複数のキー(シャード)に分散するカウンター。読み込み時にすべてのシャードを合計する
+type Counter struct {
+	name       string
+	Shards     int           // シャードの数。既定値は16
+	Spread     bool          // シャードをクラスターの異なるスロットに配置する。falseの場合はハッシュタグで同じスロットにまとめる
+	Expiration time.Duration // 書き込むたびにすべてのシャードに設定する有効期限。0の場合は設定しない
+	Interval   time.Duration // 0より大きい場合は増分をローカルで集計し、間隔ごとに書き込む
+	pending    int64
+	started    int32
+	mu         sync.RWMutex // 加算は読み込みロック、開始と停止は書き込みロックで排他する
+	flushing   sync.Mutex   // FlushとResetを排他し、失敗したFlushが持ち越す増分がResetの後に残らないようにする
+	stopped    bool
+	stop       chan struct{}
+	done       chan struct{}
+	unregister func()
+}
+
+func New(name string, shards int) *Counter {
+	return &Counter{name: name, Shards: shards}
+}
+
+func (c *Counter) shards() int {
+	if c.Shards <= 0 {
+		return 16
+	}
+	return c.Shards
+}
+
+func (c *Counter) Name() string {
+	return c.name
+}
+
+// Key シャードのキー
+func (c *Counter) Key(shard int) string {
+	if c.Spread {
+		return fmt.Sprintf("%s://%s/%d", Prefix, c.name, shard)
+	}
+	return fmt.Sprintf("%s://{%s}/%d", Prefix, c.name, shard)
+}
+
+// Keys すべてのシャードのキー
+func (c *Counter) Keys() []string {
+	keys := make([]string, c.shards())
+	for i := range keys {
+		keys[i] = c.Key(i)
+	}
+	return keys
+}
+
+func (c *Counter) Increment(ctx context.Context) error {
+	return c.IncrementBy(ctx, 1)
+}
+
+// IncrementBy ランダムに選んだシャードにnを加算する。Intervalが指定されている場合はローカルで集計する。
+// ローカルで集計する場合、Stopの後はErrStoppedを返す
+func (c *Counter) IncrementBy(ctx context.Context, n int64) error {
+	if c.Interval <= 0 {
+		return c.write(ctx, n)
+	}
+	c.mu.RLock()
+	if c.stopped {
+		c.mu.RUnlock()
+		return ErrStopped
+	}
+	atomic.AddInt64(&c.pending, n)
+	started := atomic.LoadInt32(&c.started) == 1
+	c.mu.RUnlock()
+	if !started {
+		c.start()
+	}
+	return nil
+}
+
+// write ランダムに選んだシャードにnを加算する。
+// Expirationが指定されている場合は、書き込まれないシャードだけが先に期限切れにならないよう、すべてのシャードの有効期限を延長する
+func (c *Counter) write(ctx context.Context, n int64) error {
+	key := c.Key(rand.Intn(c.shards())) //nolint:gosec
+	return redis.Universal(ctx, func(ctx context.Context, cmd redis.Cmdable) error {
+		if c.Expiration <= 0 {
+			return cmd.IncrBy(ctx, key, n).Err()
+		}
+		_, err := cmd.Pipelined(ctx, func(pipe goredis.Pipeliner) error {
+			pipe.IncrBy(ctx, key, n)
+			for _, k := range c.Keys() {
+				pipe.Expire(ctx, k, c.Expiration)
+			}
+			return nil
+		})
+		return err
+	})
+}
+
+// Get すべてのシャードの合計を返す。ローカルで集計中の増分も含む。
+// 同じスロットの場合はMGET、異なるスロットの場合はパイプラインで読み込む
+func (c *Counter) Get(ctx context.Context) (total int64, err error) {
+	keys := c.Keys()
+	var values []interface{}
+	err = redis.ReadOnly(ctx, func(ctx context.Context, cmd redis.Cmdable) error {
+		if !c.Spread {
+			values, err = cmd.MGet(ctx, keys...).Result()
+			return err
+		}
+		cmds, err := cmd.Pipelined(ctx, func(pipe goredis.Pipeliner) error {
+			for _, key := range keys {
+				pipe.Get(ctx, key)
+			}
+			return nil
+		})
+		if err != nil && !redis.IsNil(err) {
+			return err
+		}
+		values = make([]interface{}, len(cmds))
+		for i, v := range cmds {
+			if s, err := v.(*goredis.StringCmd).Result(); err == nil {
+				values[i] = s
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	for i, v := range values {
+		s, ok := v.(string)
+		if !ok {
+			continue
+		}
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("counters: %s is not an integer: %w", keys[i], err)
+		}
+		total += n
+	}
+	return total + atomic.LoadInt64(&c.pending), nil
+}
+
+// Reset すべてのシャードとローカルで集計中の増分を削除する
+func (c *Counter) Reset(ctx context.Context) error {
+	c.flushing.Lock()
+	defer c.flushing.Unlock()
+	atomic.StoreInt64(&c.pending, 0)
+	keys := c.Keys()
+	return redis.Universal(ctx, func(ctx context.Context, cmd redis.Cmdable) error {
+		if !c.Spread {
+			return cmd.Del(ctx, keys...).Err()
+		}
+		_, err := cmd.Pipelined(ctx, func(pipe goredis.Pipeliner) error {
+			for _, key := range keys {
+				pipe.Del(ctx, key)
+			}
+			return nil
+		})
+		return err
+	})
+}
+
+// Flush ローカルで集計中の増分を書き込む。失敗した場合は次回に持ち越す
+func (c *Counter) Flush(ctx context.Context) error {
+	c.flushing.Lock()
+	defer c.flushing.Unlock()
+	n := atomic.SwapInt64(&c.pending, 0)
+	if n == 0 {
+		return nil
+	}
+	if err := c.write(ctx, n); err != nil {
+		atomic.AddInt64(&c.pending, n)
+		return err
+	}
+	return nil
+}
+
+func (c *Counter) start() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.stopped || c.stop != nil {
+		return
+	}
+	c.stop = make(chan struct{})
+	c.done = make(chan struct{})
+	go c.flush(c.stop, c.done)
+	c.unregister = redis.Register(redis.ComponentFunc(c.Stop))
+	atomic.StoreInt32(&c.started, 1)
+}
+
+func (c *Counter) flush(stop, done chan struct{}) {
+	defer close(done)
+	ticker := time.NewTicker(c.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			ctx := context.Background()
+			if err := c.Flush(ctx); err != nil {
+				log.Warn(ctx).Err(err).Str("counter", c.name).Msg("counters: failed to flush")
+			}
+		}
+	}
+}
+
+// Stop ローカル集計を停止し、残りの増分を書き込む。停止後にローカルで集計する加算はErrStoppedになる
+func (c *Counter) Stop(ctx context.Context) error {
+	c.mu.Lock()
+	stop, done, unregister := c.stop, c.done, c.unregister
+	c.stop, c.done, c.unregister = nil, nil, nil
+	c.stopped = true
+	c.mu.Unlock()
+	if stop != nil {
+		close(stop)
+		<-done
+		unregister()
+	}
+	return c.Flush(ctx)
+}
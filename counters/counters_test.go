@@ -0,0 +1,183 @@
+package counters
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/goccha/redis-verse/redistest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMain(m *testing.M) {
+	redistest.Main(m)
+}
+
+// stored Redisに書き込まれた合計
+func stored(t *testing.T, c *Counter) (total int64) {
+	for _, key := range c.Keys() {
+		if v, err := redistest.Server().Get(key); err == nil {
+			n, err := strconv.ParseInt(v, 10, 64)
+			assert.Nil(t, err)
+			total += n
+		}
+	}
+	return
+}
+
+func TestCounter(t *testing.T) {
+	ctx := context.Background()
+	name := redistest.New(t).Prefix + "page-views"
+	c := New(name, 4)
+	assert.Equal(t, "counter://{"+name+"}/3", c.Key(3))
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, c.Increment(ctx))
+	}
+	assert.Nil(t, c.IncrementBy(ctx, 50))
+	n, err := c.Get(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(150), n)
+	used := 0
+	for _, key := range c.Keys() {
+		if redistest.Server().Exists(key) {
+			used++
+		}
+	}
+	assert.Greater(t, used, 1)
+
+	assert.Nil(t, c.Reset(ctx))
+	n, err = c.Get(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), n)
+}
+
+func TestCounter_Spread(t *testing.T) {
+	ctx := context.Background()
+	name := redistest.New(t).Prefix + "likes"
+	c := &Counter{name: name, Shards: 3, Spread: true, Expiration: time.Minute}
+	assert.Equal(t, "counter://"+name+"/2", c.Key(2))
+	for i := 0; i < 30; i++ {
+		assert.Nil(t, c.IncrementBy(ctx, 2))
+	}
+	n, err := c.Get(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(60), n)
+	for _, key := range c.Keys() {
+		if redistest.Server().Exists(key) {
+			assert.True(t, redistest.Server().TTL(key) > 0)
+		}
+	}
+	assert.Nil(t, c.Reset(ctx))
+	assert.Equal(t, int64(0), stored(t, c))
+}
+
+func TestCounter_Expiration(t *testing.T) {
+	ctx := context.Background()
+	c := &Counter{name: redistest.New(t).Prefix + "expiring", Shards: 4, Expiration: time.Minute}
+	for i := 0; i < 40; i++ {
+		assert.Nil(t, c.Increment(ctx))
+	}
+	// 書き込まれなかったシャードの有効期限も延長する
+	redistest.Server().FastForward(50 * time.Second)
+	assert.Nil(t, c.Increment(ctx))
+	for _, key := range c.Keys() {
+		if redistest.Server().Exists(key) {
+			assert.Equal(t, time.Minute, redistest.Server().TTL(key), key)
+		}
+	}
+	n, err := c.Get(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(41), n)
+}
+
+func TestCounter_ResetWhileFlushing(t *testing.T) {
+	ctx := context.Background()
+	c := &Counter{name: redistest.New(t).Prefix + "resetting", Shards: 2, Interval: time.Hour}
+	defer c.Stop(ctx)
+	assert.Nil(t, c.IncrementBy(ctx, 5))
+
+	redistest.Server().SetError("ERR unavailable")
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				_ = c.Flush(ctx) // 失敗した増分は持ち越す
+			}
+		}
+	}()
+	time.Sleep(time.Millisecond)
+	assert.NotNil(t, c.Reset(ctx))
+	close(stop)
+	<-done
+	redistest.Server().SetError("")
+	// Resetの前に失敗したFlushの増分はResetの後に戻らない
+	n, err := c.Get(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), n)
+}
+
+func TestCounter_Batching(t *testing.T) {
+	ctx := context.Background()
+	r := redistest.New(t)
+	c := &Counter{name: r.Prefix + "batched", Shards: 2, Interval: time.Hour}
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, c.Increment(ctx))
+	}
+	assert.Equal(t, int64(0), stored(t, c))
+	n, err := c.Get(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(10), n)
+
+	assert.Nil(t, c.Flush(ctx))
+	assert.Equal(t, int64(10), stored(t, c))
+
+	assert.Nil(t, c.IncrementBy(ctx, 5))
+	assert.Nil(t, c.Stop(ctx))
+	assert.Equal(t, int64(15), stored(t, c))
+	// 停止後は集計を再開しない
+	assert.ErrorIs(t, c.IncrementBy(ctx, 5), ErrStopped)
+	assert.Nil(t, c.Stop(ctx))
+	assert.Equal(t, int64(15), stored(t, c))
+
+	c = &Counter{name: "batched/interval", Shards: 2, Interval: 10 * time.Millisecond}
+	defer c.Reset(ctx)
+	assert.Nil(t, c.IncrementBy(ctx, 5))
+	assert.Eventually(t, func() bool {
+		return stored(t, c) == 5
+	}, time.Second, 10*time.Millisecond)
+	assert.Nil(t, c.Stop(ctx))
+}
+
+func TestCounter_StopConcurrently(t *testing.T) {
+	ctx := context.Background()
+	c := &Counter{name: "batched/concurrent", Shards: 2, Interval: time.Hour}
+	defer c.Reset(ctx)
+	var wg sync.WaitGroup
+	var accepted int64
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				if err := c.Increment(ctx); err != nil {
+					assert.ErrorIs(t, err, ErrStopped)
+					return
+				}
+				atomic.AddInt64(&accepted, 1)
+			}
+		}()
+	}
+	time.Sleep(time.Millisecond)
+	assert.Nil(t, c.Stop(ctx))
+	wg.Wait()
+	// 受け付けた増分はすべて書き込まれている
+	assert.Equal(t, atomic.LoadInt64(&accepted), stored(t, c))
+}
@@ -15,6 +15,9 @@ const (
 	LockCountPrefix = "lock-count"
 )
 
+// Policy Redisに接続できない場合の振る舞い。FailClosedの場合はロック中として扱う
+var Policy = redis.FailClosed
+
 type Trigger interface {
 	Fire(ctx context.Context) error          // 発火
 	Fired(ctx context.Context) (bool, error) // 発火済み
@@ -64,7 +67,7 @@ func (c *LockCounter) Increment(ctx context.Context) (bool, error) {
 	}
 	locked, err := c.Trigger.Fired(ctx)
 	if err != nil {
-		return false, err
+		return c.failed(ctx, err)
 	}
 	if locked {
 		c.locked = true
@@ -73,13 +76,25 @@ func (c *LockCounter) Increment(ctx context.Context) (bool, error) {
 	c.locked = false
 	c.expirations, err = c.increment(ctx)
 	if err != nil {
-		return false, err
+		return c.failed(ctx, err)
 	}
 	return false, nil
 }
 
+func (c *LockCounter) failed(ctx context.Context, err error) (bool, error) {
+	if Policy.Open(err) {
+		log.Warn(ctx).Err(err).Str("key", c.Key()).Msg("guards: fail open")
+		return false, nil
+	}
+	if redis.IsUnavailable(err) {
+		c.locked = true
+		return true, err
+	}
+	return false, err
+}
+
 func (c *LockCounter) increment(ctx context.Context) ([]int64, error) {
-	now := time.Now()
+	now := redis.Now()
 	cmd := redis.Primary().Get(ctx, c.Key())
 	if cmd.Err() != nil && !redis.IsNil(cmd.Err()) {
 		return nil, cmd.Err()
@@ -116,7 +131,7 @@ func (c *LockCounter) Fire(ctx context.Context, f func()) (bool, error) {
 	if c.locked {
 		return true, nil
 	}
-	keys := c.gc(time.Now(), c.expirations)
+	keys := c.gc(redis.Now(), c.expirations)
 	if len(keys) >= c.TryMax { // 規定回数以上に達した場合
 		if err := c.Trigger.Fire(ctx); err != nil { // 対象キーをロック
 			log.Error(ctx).Err(err).Send()
@@ -134,10 +149,41 @@ func (c *LockCounter) Fire(ctx context.Context, f func()) (bool, error) {
 			return false, err
 		}
 		if cmd := redis.Primary().SetEx(ctx, c.Key(), v, c.Expiration); cmd.Err() != nil {
-			return false, cmd.Err()
+			return c.failed(ctx, cmd.Err())
 		}
 	}
 	c.locked = false
 	c.expirations = keys
 	return false, nil
 }
+
+// Attempts キーに記録されている試行を、判定対象から外れる日時で返す
+func Attempts(ctx context.Context, key string) ([]time.Time, error) {
+	c := &LockCounter{key: key}
+	b, err := redis.Primary().Get(ctx, c.Key()).Bytes()
+	if err != nil {
+		if redis.IsNil(err) {
+			return []time.Time{}, nil
+		}
+		return nil, err
+	}
+	var expirations []int64
+	if err = json.Unmarshal(b, &expirations); err != nil {
+		return nil, err
+	}
+	attempts := make([]time.Time, 0, len(expirations))
+	for _, v := range expirations {
+		attempts = append(attempts, time.Unix(v, 0))
+	}
+	return attempts, nil
+}
+
+// Reset キーに記録されている試行を削除する。記録がなかった場合はfalseを返す
+func Reset(ctx context.Context, key string) (bool, error) {
+	c := &LockCounter{key: key}
+	n, err := redis.Primary().Del(ctx, c.Key()).Result()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
@@ -2,35 +2,29 @@ package guards
 
 import (
 	"context"
-	"os"
 	"testing"
 	"time"
 
 	"github.com/goccha/redis-verse/locks"
 	"github.com/goccha/redis-verse/redis"
+	"github.com/goccha/redis-verse/redistest"
 	"github.com/stretchr/testify/assert"
 )
 
 func TestMain(m *testing.M) {
-	ctx := context.Background()
-	if err := redis.Setup(ctx, &redis.EnvBuilder{}); err != nil {
-		panic(err)
-	}
-	if err := redis.WaitForActivation(ctx); err != nil {
-		os.Exit(1)
-	}
-	code := m.Run()
-	os.Exit(code)
+	redistest.Main(m)
 }
 
 func Test_UserLock(t *testing.T) {
+	t.Parallel()
 	ctx := context.Background()
-	ip := "127.0.0.1"
+	r := redistest.New(t)
+	ip := r.Key("127.0.0.1")
 	ipLock := &locks.RedisLock{
 		Key:      ip,
 		Duration: 5 * time.Minute,
 	}
-	userId := "test_user_000"
+	userId := r.Key("test_user_000")
 	userLock := &locks.RedisLock{
 		Key:      userId,
 		Duration: 3 * time.Minute,
@@ -60,7 +54,6 @@ func Test_UserLock(t *testing.T) {
 			is, err := cmd.Bool()
 			assert.Nil(t, err)
 			assert.True(t, is)
-			_ = redis.Primary().Del(ctx, userLock.String())
 		} else {
 			assert.False(t, locked)
 			assert.False(t, fired)
@@ -76,14 +69,15 @@ func Test_UserLock(t *testing.T) {
 			cmd := redis.Reader().Get(ctx, ipLock.String())
 			assert.NotNil(t, cmd.Err())
 			assert.True(t, redis.IsNil(cmd.Err()))
-			_ = redis.Primary().Del(ctx, ipCounter.Key())
 		}
 	}
 }
 
 func Test_IpLock(t *testing.T) {
+	t.Parallel()
 	ctx := context.Background()
-	ip := "127.0.0.1"
+	r := redistest.New(t)
+	ip := r.Key("127.0.0.1")
 	ipLock := &locks.RedisLock{
 		Key:      ip,
 		Duration: 5 * time.Minute,
@@ -104,7 +98,7 @@ func Test_IpLock(t *testing.T) {
 	}
 
 	for i := 1; i <= 12; i++ {
-		userId := users[i-1]
+		userId := r.Key(users[i-1])
 		userLock := &locks.RedisLock{
 			Key:      userId,
 			Duration: 1 * time.Minute,
@@ -151,5 +145,42 @@ func Test_IpLock(t *testing.T) {
 			assert.False(t, fired)
 		}
 	}
-	_ = redis.Primary().Del(ctx, ipLock.String())
+}
+
+func Test_LockExpiration(t *testing.T) {
+	ctx := context.Background()
+	r := redistest.New(t)
+	clock := redistest.NewClock(t)
+	userId := r.Key("test_user_100")
+	userLock := &locks.RedisLock{
+		Key:      userId,
+		Duration: 3 * time.Minute,
+	}
+	for i := 1; i <= 3; i++ {
+		counter := TimedCounter(userId, 3, 5*time.Minute, &LockTrigger{Lock: userLock})
+		locked, err := counter.Increment(ctx)
+		assert.Nil(t, err)
+		assert.False(t, locked)
+		locked, err = counter.Fire(ctx, nil)
+		assert.Nil(t, err)
+		assert.Equal(t, i == 3, locked)
+	}
+	locked, err := userLock.Locked(ctx)
+	assert.Nil(t, err)
+	assert.True(t, locked)
+
+	clock.Advance(3*time.Minute + time.Second)
+	locked, err = userLock.Locked(ctx)
+	assert.Nil(t, err)
+	assert.False(t, locked)
+
+	for i := 1; i <= 3; i++ {
+		counter := TimedCounter(userId, 3, 5*time.Minute, &LockTrigger{Lock: userLock})
+		_, err = counter.Increment(ctx)
+		assert.Nil(t, err)
+		locked, err = counter.Fire(ctx, nil)
+		assert.Nil(t, err)
+		assert.False(t, locked)
+		clock.Advance(3 * time.Minute)
+	}
 }
@@ -0,0 +1,318 @@
+package idempotency
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/goccha/logging/log"
+	"github.com/goccha/redis-verse/redis"
+	"github.com/goccha/redis-verse/sessions"
+	goredis "github.com/redis/go-redis/v9"
+)
+
+const (
+	IdempotencyPrefix = "idempotency"
+	HeaderName        = "Idempotency-Key"
+	// claimPrefix 処理中のリクエストが保存する値の接頭辞。後ろにリクエストごとの所有者トークンを付ける
+	claimPrefix = "claim:"
+)
+
+var DefaultExpiration = 24 * time.Hour
+
+func Key(scope, key string) string {
+	return fmt.Sprintf("%s://%s/%s", IdempotencyPrefix, scope, key)
+}
+
+// Response 初回リクエストのレスポンス
+type Response struct {
+	Method string      `json:"method"`
+	Path   string      `json:"path"`
+	Status int         `json:"status"`
+	Header http.Header `json:"header,omitempty"`
+	Body   []byte      `json:"body,omitempty"`
+}
+
+func (res *Response) write(ctx context.Context, w http.ResponseWriter) {
+	for k, v := range res.Header {
+		w.Header()[k] = v
+	}
+	w.Header().Set("Idempotent-Replayed", "true")
+	w.WriteHeader(res.Status)
+	if _, err := w.Write(res.Body); err != nil {
+		log.Debug(ctx).Err(err).Send()
+	}
+}
+
+// Handler Idempotency-Keyによる重複リクエストの制御
+type Handler struct {
+	Expiration time.Duration                // レスポンスの保存期間
+	LockTime   time.Duration                // 処理中とみなす期間。処理が続いている間は1/3ごとに延長する
+	Scope      func(r *http.Request) string // キーのスコープ(ユーザーID等)。空の場合はリクエストを拒否する
+	Methods    []string                     // 対象のHTTPメソッド
+}
+
+func New(expiration time.Duration) *Handler {
+	return &Handler{
+		Expiration: expiration,
+	}
+}
+
+func (h *Handler) expiration() time.Duration {
+	if h.Expiration <= 0 {
+		return DefaultExpiration
+	}
+	return h.Expiration
+}
+
+func (h *Handler) lockTime() time.Duration {
+	if h.LockTime <= 0 {
+		return redis.LockTime
+	}
+	return h.LockTime
+}
+
+func (h *Handler) scope(r *http.Request) string {
+	if h.Scope != nil {
+		return h.Scope(r)
+	}
+	if s := sessions.FromContext(r.Context()); s != nil {
+		return s.UserID
+	}
+	return ""
+}
+
+func (h *Handler) target(r *http.Request) bool {
+	if len(h.Methods) == 0 {
+		return r.Method == http.MethodPost || r.Method == http.MethodPatch
+	}
+	for _, m := range h.Methods {
+		if m == r.Method {
+			return true
+		}
+	}
+	return false
+}
+
+// Middleware 初回のレスポンスを保存し、重複リクエストにはそれを返す
+func (h *Handler) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(HeaderName)
+		if id == "" || !h.target(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		ctx := r.Context()
+		scope := h.scope(r)
+		if scope == "" { // 匿名のリクエスト同士でレスポンスを共有しないようにする
+			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			return
+		}
+		key := Key(scope, id)
+		owner, err := newOwner()
+		if err != nil {
+			log.Error(ctx).Err(err).Send()
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		ok, err := h.claim(ctx, key, owner)
+		if err != nil {
+			log.Error(ctx).Err(err).Send()
+			http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+			return
+		}
+		if !ok {
+			h.replay(ctx, w, r, key)
+			return
+		}
+		rec := &recorder{ResponseWriter: w, status: http.StatusOK}
+		completed := false
+		defer func() {
+			if !completed {
+				h.release(ctx, key, owner)
+			}
+		}()
+		stop := h.renew(ctx, key, owner)
+		defer stop()
+		next.ServeHTTP(rec, r)
+		stop()
+		if rec.status >= http.StatusInternalServerError {
+			return
+		}
+		res := &Response{
+			Method: r.Method,
+			Path:   r.URL.Path,
+			Status: rec.status,
+			Header: w.Header().Clone(),
+			Body:   rec.body.Bytes(),
+		}
+		if err = h.save(ctx, key, owner, res); err != nil {
+			log.Error(ctx).Err(err).Send()
+			return
+		}
+		completed = true
+	})
+}
+
+func newOwner() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return claimPrefix + hex.EncodeToString(b), nil
+}
+
+// claim 処理中であることを所有者トークンとともに保存する
+func (h *Handler) claim(ctx context.Context, key, owner string) (ok bool, err error) {
+	err = redis.Universal(ctx, func(ctx context.Context, c redis.Cmdable) error {
+		ok, err = c.SetNX(ctx, key, owner, h.lockTime()).Result()
+		return err
+	})
+	return
+}
+
+// extend 所有者が変わっていない場合だけ有効期間を延長する
+var extend = goredis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('PEXPIRE', KEYS[1], ARGV[2])
+end
+return 0`)
+
+// store 所有者が変わっていない場合だけレスポンスで置き換える
+var store = goredis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	redis.call('SET', KEYS[1], ARGV[2], 'PX', ARGV[3])
+	return 1
+end
+return 0`)
+
+// unclaim 所有者が変わっていない場合だけ削除する
+var unclaim = goredis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('DEL', KEYS[1])
+end
+return 0`)
+
+// renew 処理が終わるまでLockTimeの1/3ごとに有効期間を延長する。戻り値の関数で延長を止める(複数回呼び出してもよい)
+func (h *Handler) renew(ctx context.Context, key, owner string) (stop func()) {
+	lockTime := h.lockTime()
+	var once sync.Once
+	done := make(chan struct{})
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		ticker := time.NewTicker(lockTime / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				err := redis.Universal(ctx, func(ctx context.Context, c redis.Cmdable) error {
+					return extend.Run(ctx, c, []string{key}, owner, lockTime.Milliseconds()).Err()
+				})
+				if err != nil {
+					log.Warn(ctx).Err(err).Str("key", key).Msg("idempotency: failed to extend the claim")
+				}
+			}
+		}
+	}()
+	return func() {
+		once.Do(func() {
+			close(done)
+			<-finished
+		})
+	}
+}
+
+func (h *Handler) replay(ctx context.Context, w http.ResponseWriter, r *http.Request, key string) {
+	var v string
+	err := redis.Universal(ctx, func(ctx context.Context, c redis.Cmdable) (err error) {
+		v, err = c.Get(ctx, key).Result()
+		return
+	})
+	if err != nil {
+		if redis.IsNil(err) { // 直前に解放された
+			http.Error(w, http.StatusText(http.StatusConflict), http.StatusConflict)
+			return
+		}
+		log.Error(ctx).Err(err).Send()
+		http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+		return
+	}
+	if strings.HasPrefix(v, claimPrefix) { // 処理中
+		http.Error(w, http.StatusText(http.StatusConflict), http.StatusConflict)
+		return
+	}
+	res := &Response{}
+	if err := json.Unmarshal([]byte(v), res); err != nil {
+		log.Error(ctx).Err(err).Send()
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	if res.Method != r.Method || res.Path != r.URL.Path {
+		http.Error(w, http.StatusText(http.StatusUnprocessableEntity), http.StatusUnprocessableEntity)
+		return
+	}
+	res.write(ctx, w)
+}
+
+// save 処理中の値をレスポンスで置き換える。有効期間が切れて他のリクエストが処理している場合は保存しない
+func (h *Handler) save(ctx context.Context, key, owner string, res *Response) error {
+	v, err := json.Marshal(res)
+	if err != nil {
+		return err
+	}
+	return redis.Universal(ctx, func(ctx context.Context, c redis.Cmdable) error {
+		saved, err := store.Run(ctx, c, []string{key}, owner, v, h.expiration().Milliseconds()).Int()
+		if err != nil {
+			return err
+		}
+		if saved == 0 {
+			log.Warn(ctx).Str("key", key).Msg("idempotency: the claim was lost before saving the response")
+		}
+		return nil
+	})
+}
+
+// release 処理中の値を削除する。他のリクエストの値は削除しない
+func (h *Handler) release(ctx context.Context, key, owner string) {
+	err := redis.Universal(ctx, func(ctx context.Context, c redis.Cmdable) error {
+		return unclaim.Run(ctx, c, []string{key}, owner).Err()
+	})
+	if err != nil {
+		log.Error(ctx).Err(err).Send()
+	}
+}
+
+type recorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+func (r *recorder) WriteHeader(status int) {
+	if !r.wroteHeader {
+		r.status = status
+		r.wroteHeader = true
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *recorder) Write(b []byte) (int, error) {
+	if !r.wroteHeader {
+		r.WriteHeader(http.StatusOK)
+	}
+	r.body.Write(b)
+	return r.ResponseWriter.Write(b)
+}
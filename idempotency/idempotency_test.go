@@ -0,0 +1,202 @@
+package idempotency
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/goccha/redis-verse/redis"
+	"github.com/goccha/redis-verse/redistest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMain(m *testing.M) {
+	redistest.Main(m)
+}
+
+func newRequest(user, key string) *http.Request {
+	req := httptest.NewRequest(http.MethodPost, "/orders", nil)
+	req.Header.Set(HeaderName, key)
+	req.Header.Set("X-User", user)
+	return req
+}
+
+func TestHandler_Replay(t *testing.T) {
+	var cnt int32
+	h := &Handler{
+		Expiration: time.Minute,
+		Scope: func(r *http.Request) string {
+			return r.Header.Get("X-User")
+		},
+	}
+	handler := h.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&cnt, 1)
+		w.Header().Set("X-Order", "1")
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write([]byte("created"))
+	}))
+	r := redistest.New(t)
+
+	for i := 0; i < 3; i++ {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, newRequest(r.Prefix+"user_001", "key_001"))
+		assert.Equal(t, http.StatusCreated, rec.Code)
+		assert.Equal(t, "1", rec.Header().Get("X-Order"))
+		assert.Equal(t, "created", rec.Body.String())
+		if i > 0 {
+			assert.Equal(t, "true", rec.Header().Get("Idempotent-Replayed"))
+		}
+	}
+	assert.Equal(t, int32(1), atomic.LoadInt32(&cnt))
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, newRequest(r.Prefix+"user_002", "key_001"))
+	assert.Equal(t, http.StatusCreated, rec.Code)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&cnt))
+}
+
+func TestHandler_InFlight(t *testing.T) {
+	h := &Handler{
+		Scope: func(r *http.Request) string {
+			return r.Header.Get("X-User")
+		},
+	}
+	started := make(chan struct{})
+	done := make(chan struct{})
+	handler := h.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-done
+		w.WriteHeader(http.StatusOK)
+	}))
+	user := redistest.New(t).Prefix + "user_003"
+
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		handler.ServeHTTP(httptest.NewRecorder(), newRequest(user, "key_002"))
+	}()
+	<-started
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, newRequest(user, "key_002"))
+	assert.Equal(t, http.StatusConflict, rec.Code)
+	close(done)
+	<-finished
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, newRequest(user, "key_002"))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "true", rec.Header().Get("Idempotent-Replayed"))
+}
+
+func TestHandler_ServerError(t *testing.T) {
+	var cnt int32
+	h := &Handler{
+		Scope: func(r *http.Request) string {
+			return r.Header.Get("X-User")
+		},
+	}
+	handler := h.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&cnt, 1) == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	user := redistest.New(t).Prefix + "user_004"
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, newRequest(user, "key_003"))
+	assert.Equal(t, http.StatusInternalServerError, rec.Code)
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, newRequest(user, "key_003"))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&cnt))
+}
+
+func TestHandler_Anonymous(t *testing.T) {
+	var cnt int32
+	h := &Handler{}
+	handler := h.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&cnt, 1)
+		w.WriteHeader(http.StatusOK)
+	}))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, newRequest("", "key_004"))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Equal(t, int32(0), atomic.LoadInt32(&cnt))
+	assert.Equal(t, int64(0), redis.Primary().Exists(context.Background(), Key("", "key_004")).Val())
+
+	// Idempotency-Keyがない場合はそのまま処理する
+	req := newRequest("", "")
+	req.Header.Del(HeaderName)
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+}
+
+func TestHandler_Renew(t *testing.T) {
+	var cnt int32
+	h := &Handler{
+		LockTime: 300 * time.Millisecond,
+		Scope: func(r *http.Request) string {
+			return r.Header.Get("X-User")
+		},
+	}
+	started := make(chan struct{})
+	done := make(chan struct{})
+	handler := h.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&cnt, 1) == 1 {
+			close(started)
+			<-done
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	user := redistest.New(t).Prefix + "user_005"
+
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		handler.ServeHTTP(httptest.NewRecorder(), newRequest(user, "key_005"))
+	}()
+	<-started
+	// LockTimeを超えて処理していても、延長しているため重複したリクエストは処理しない
+	for i := 0; i < 12; i++ {
+		redistest.Server().FastForward(50 * time.Millisecond)
+		time.Sleep(50 * time.Millisecond)
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, newRequest(user, "key_005"))
+	assert.Equal(t, http.StatusConflict, rec.Code)
+	close(done)
+	<-finished
+	assert.Equal(t, int32(1), atomic.LoadInt32(&cnt))
+}
+
+func TestHandler_Owner(t *testing.T) {
+	ctx := context.Background()
+	user := redistest.New(t).Prefix + "user_006"
+	other := claimPrefix + "other"
+	h := &Handler{
+		Scope: func(r *http.Request) string {
+			return r.Header.Get("X-User")
+		},
+	}
+	handler := h.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// 有効期間が切れて他のリクエストが処理を始めた
+		assert.Nil(t, redis.Primary().Set(ctx, Key(user, r.Header.Get(HeaderName)), other, time.Minute).Err())
+		if r.Header.Get(HeaderName) == "failed" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	// 他のリクエストの値は削除も上書きもしない
+	for _, id := range []string{"failed", "succeeded"} {
+		handler.ServeHTTP(httptest.NewRecorder(), newRequest(user, id))
+		v, err := redis.Primary().Get(ctx, Key(user, id)).Result()
+		assert.Nil(t, err)
+		assert.Equal(t, other, v, id)
+	}
+}
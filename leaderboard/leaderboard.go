@@ -0,0 +1,200 @@
+package leaderboard
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/goccha/redis-verse/redis"
+)
+
+const (
+	Prefix = "leaderboard"
+)
+
+// Order 順位の並び
+type Order int
+
+const (
+	Descending Order = iota // スコアが高いほど上位
+	Ascending               // スコアが低いほど上位
+)
+
+// Policy 同じメンバーが複数回登録した場合のスコアの扱い
+type Policy int
+
+const (
+	Best   Policy = iota // 最も良いスコア
+	Latest               // 最後に登録したスコア
+	Sum                  // スコアの合計
+)
+
+func (p Policy) String() string {
+	switch p {
+	case Latest:
+		return "latest"
+	case Sum:
+		return "sum"
+	}
+	return "best"
+}
+
+// Period 順位表を区切る期間
+type Period int
+
+const (
+	AllTime Period = iota
+	Daily
+	Weekly // 月曜日始まり
+	Monthly
+	Season // SeasonStartからSeasonLengthごと
+)
+
+// Board 順位表の設定。同じスコアの場合は先に登録したメンバーが上位になる
+type Board struct {
+	name             string
+	Order            Order
+	Policy           Policy
+	Period           Period
+	SeasonStart      time.Time      // Seasonの最初のシーズンの開始時刻
+	SeasonLength     time.Duration  // Seasonの1シーズンの長さ
+	Location         *time.Location // 期間の計算に使うタイムゾーン。既定値はUTC
+	Retention        time.Duration  // 期間の終了後に順位表を保持する期間
+	Archive          bool           // 期間の終了後、次の期間の最初の登録時に順位表を保存する。Retentionの間に登録がない場合は保存しない
+	ArchiveRetention time.Duration  // 保存した順位表を保持する期間。0の場合は期限なし
+}
+
+// validate Archiveは期間の終了後も前の期間の順位表が残っている必要があるため、Retentionを指定する
+func (b *Board) validate() error {
+	if b.Archive && b.Period != AllTime && b.Retention <= 0 {
+		return fmt.Errorf("leaderboard: %s requires Retention to archive", b.name)
+	}
+	return nil
+}
+
+func New(name string) *Board {
+	return &Board{name: name}
+}
+
+func (b *Board) Name() string {
+	return b.name
+}
+
+func (b *Board) location() *time.Location {
+	if b.Location == nil {
+		return time.UTC
+	}
+	return b.Location
+}
+
+// bounds tを含む期間の開始と終了。AllTimeの場合は終了なし
+func (b *Board) bounds(t time.Time) (start, end time.Time, err error) {
+	t = t.In(b.location())
+	switch b.Period {
+	case Daily:
+		start = time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+		end = start.AddDate(0, 0, 1)
+	case Weekly:
+		start = time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+		start = start.AddDate(0, 0, -((int(start.Weekday()) + 6) % 7))
+		end = start.AddDate(0, 0, 7)
+	case Monthly:
+		start = time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, t.Location())
+		end = start.AddDate(0, 1, 0)
+	case Season:
+		if b.SeasonLength <= 0 || t.Before(b.SeasonStart) {
+			return start, end, fmt.Errorf("leaderboard: %s is not in any season of %s", t, b.name)
+		}
+		n := t.Sub(b.SeasonStart) / b.SeasonLength
+		start = b.SeasonStart.Add(n * b.SeasonLength)
+		end = start.Add(b.SeasonLength)
+	}
+	return
+}
+
+func (b *Board) label(start time.Time) string {
+	switch b.Period {
+	case Daily:
+		return start.Format("2006-01-02")
+	case Weekly:
+		year, week := start.ISOWeek()
+		return fmt.Sprintf("%04d-W%02d", year, week)
+	case Monthly:
+		return start.Format("2006-01")
+	case Season:
+		return fmt.Sprintf("season-%d", int64(start.Sub(b.SeasonStart)/b.SeasonLength)+1)
+	}
+	return "all"
+}
+
+// key 順位表のキー。同じ順位表のキーは同じスロットに配置する
+func (b *Board) key(kind, label string) string {
+	return fmt.Sprintf("%s://{%s}/%s%s", Prefix, b.name, kind, label)
+}
+
+// At tを含む期間の順位表
+func (b *Board) At(t time.Time) (*View, error) {
+	start, end, err := b.bounds(t)
+	if err != nil {
+		return nil, err
+	}
+	label := b.label(start)
+	v := &View{board: b, Label: label, Start: start, End: end, key: b.key("", label)}
+	if b.Period != AllTime {
+		if prev, _, err := b.bounds(start.Add(-time.Nanosecond)); err == nil {
+			v.previous = b.label(prev)
+		}
+	}
+	return v, nil
+}
+
+// Current 現在の期間の順位表
+func (b *Board) Current() (*View, error) {
+	return b.At(redis.Now())
+}
+
+// Archived tを含む期間の保存した順位表
+func (b *Board) Archived(t time.Time) (*View, error) {
+	start, end, err := b.bounds(t)
+	if err != nil {
+		return nil, err
+	}
+	label := b.label(start)
+	return &View{board: b, Label: label, Start: start, End: end, key: b.key("archive/", label), archived: true}, nil
+}
+
+// Submit 現在の期間にスコアを登録する
+func (b *Board) Submit(ctx context.Context, id string, score float64) (float64, bool, error) {
+	v, err := b.Current()
+	if err != nil {
+		return 0, false, err
+	}
+	return v.Submit(ctx, id, score)
+}
+
+// Rank 現在の期間の順位
+func (b *Board) Rank(ctx context.Context, id string) (*Entry, error) {
+	v, err := b.Current()
+	if err != nil {
+		return nil, err
+	}
+	return v.Rank(ctx, id)
+}
+
+// Top 現在の期間の上位n件
+func (b *Board) Top(ctx context.Context, n int64) ([]Entry, error) {
+	v, err := b.Current()
+	if err != nil {
+		return nil, err
+	}
+	return v.Top(ctx, n)
+}
+
+// Around 現在の期間のidの前後n件
+func (b *Board) Around(ctx context.Context, id string, n int64) ([]Entry, error) {
+	v, err := b.Current()
+	if err != nil {
+		return nil, err
+	}
+	return v.Around(ctx, id, n)
+}
@@ -0,0 +1,245 @@
+package leaderboard
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/goccha/redis-verse/redistest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMain(m *testing.M) {
+	redistest.Main(m)
+}
+
+func ids(entries []Entry) []string {
+	var v []string
+	for _, e := range entries {
+		v = append(v, e.ID)
+	}
+	return v
+}
+
+func TestPolicy(t *testing.T) {
+	ctx := context.Background()
+	r := redistest.New(t)
+	best := New(r.Prefix + "best")
+	score, updated, err := best.Submit(ctx, "alice", 100)
+	assert.Nil(t, err)
+	assert.True(t, updated)
+	assert.Equal(t, float64(100), score)
+	score, updated, err = best.Submit(ctx, "alice", 80)
+	assert.Nil(t, err)
+	assert.False(t, updated)
+	assert.Equal(t, float64(100), score)
+	score, updated, err = best.Submit(ctx, "alice", 120)
+	assert.Nil(t, err)
+	assert.True(t, updated)
+	assert.Equal(t, float64(120), score)
+
+	// 昇順では小さいスコアが良いスコア
+	fastest := New(r.Prefix + "fastest")
+	fastest.Order = Ascending
+	_, _, err = fastest.Submit(ctx, "alice", 30.5)
+	assert.Nil(t, err)
+	score, updated, err = fastest.Submit(ctx, "alice", 31)
+	assert.Nil(t, err)
+	assert.False(t, updated)
+	assert.Equal(t, 30.5, score)
+
+	latest := New(r.Prefix + "latest")
+	latest.Policy = Latest
+	_, _, _ = latest.Submit(ctx, "alice", 100)
+	score, updated, err = latest.Submit(ctx, "alice", 80)
+	assert.Nil(t, err)
+	assert.True(t, updated)
+	assert.Equal(t, float64(80), score)
+
+	sum := New(r.Prefix + "sum")
+	sum.Policy = Sum
+	_, _, _ = sum.Submit(ctx, "alice", 100)
+	score, _, err = sum.Submit(ctx, "alice", 2.5)
+	assert.Nil(t, err)
+	assert.Equal(t, 102.5, score)
+	e, err := sum.Rank(ctx, "alice")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), e.Rank)
+	assert.Equal(t, 102.5, e.Score)
+
+	// 更新前のメンバーは残らない
+	v, _ := sum.Current()
+	members, err := redistest.Server().ZMembers(v.Key())
+	assert.Nil(t, err)
+	assert.Len(t, members, 1)
+}
+
+func TestRanking(t *testing.T) {
+	ctx := context.Background()
+	r := redistest.New(t)
+	clock := redistest.NewClock(t)
+
+	b := New(r.Prefix + "ranking")
+	b.Policy = Latest
+	for _, s := range []struct {
+		id    string
+		score float64
+	}{{"a", 50}, {"b", 70}, {"c", 70}, {"d", 30}, {"e", 70}, {"f", 10}} {
+		_, _, err := b.Submit(ctx, s.id, s.score)
+		assert.Nil(t, err)
+		clock.Advance(time.Second)
+	}
+	// 同じスコアは先に登録したメンバーが上位
+	top, err := b.Top(ctx, 4)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"b", "c", "e", "a"}, ids(top))
+	assert.Equal(t, int64(1), top[0].Rank)
+	assert.Equal(t, clock.Now().Add(-5*time.Second).UnixMilli(), top[0].SubmittedAt.UnixMilli())
+
+	// 再登録すると登録時刻が更新される
+	_, _, err = b.Submit(ctx, "b", 70)
+	assert.Nil(t, err)
+	e, err := b.Rank(ctx, "b")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(3), e.Rank)
+	assert.Equal(t, float64(70), e.Score)
+
+	around, err := b.Around(ctx, "a", 1)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"b", "a", "d"}, ids(around))
+	assert.Equal(t, int64(3), around[0].Rank)
+	around, err = b.Around(ctx, "c", 2)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"c", "e", "b"}, ids(around))
+
+	_, err = b.Rank(ctx, "unknown")
+	assert.True(t, IsNotFound(err))
+	_, err = b.Around(ctx, "unknown", 1)
+	assert.True(t, IsNotFound(err))
+
+	v, _ := b.Current()
+	removed, err := v.Remove(ctx, "a")
+	assert.Nil(t, err)
+	assert.True(t, removed)
+	_, err = b.Rank(ctx, "a")
+	assert.True(t, IsNotFound(err))
+	e, err = b.Rank(ctx, "d")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(4), e.Rank)
+
+	asc := New(r.Prefix + "ranking-asc")
+	asc.Order = Ascending
+	for _, id := range []string{"x", "y", "z"} {
+		_, _, _ = asc.Submit(ctx, id, 1)
+		clock.Advance(time.Millisecond)
+	}
+	top, err = asc.Top(ctx, 10)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"x", "y", "z"}, ids(top))
+}
+
+func TestPeriod(t *testing.T) {
+	jst := time.FixedZone("JST", 9*60*60)
+	b := New("period")
+	b.Location = jst
+	at := time.Date(2024, 3, 3, 16, 0, 0, 0, time.UTC) // JSTでは3月4日(月)
+
+	b.Period = Daily
+	v, err := b.At(at)
+	assert.Nil(t, err)
+	assert.Equal(t, "leaderboard://{period}/2024-03-04", v.Key())
+	assert.Equal(t, "2024-03-03", v.previous)
+	assert.Equal(t, 24*time.Hour, v.End.Sub(v.Start))
+
+	b.Period = Weekly
+	v, _ = b.At(at)
+	assert.Equal(t, "2024-W10", v.Label)
+	assert.Equal(t, time.Date(2024, 3, 4, 0, 0, 0, 0, jst), v.Start)
+	v, _ = b.At(at.Add(-2 * time.Hour))
+	assert.Equal(t, "2024-W09", v.Label)
+
+	b.Period = Monthly
+	v, _ = b.At(at)
+	assert.Equal(t, "2024-03", v.Label)
+	assert.Equal(t, "2024-02", v.previous)
+
+	b.Period = Season
+	_, err = b.At(at)
+	assert.NotNil(t, err)
+	b.SeasonStart = time.Date(2024, 1, 1, 0, 0, 0, 0, jst)
+	b.SeasonLength = 30 * 24 * time.Hour
+	v, err = b.At(at)
+	assert.Nil(t, err)
+	assert.Equal(t, "season-3", v.Label)
+	assert.Equal(t, "season-2", v.previous)
+	v, _ = b.At(b.SeasonStart)
+	assert.Equal(t, "season-1", v.Label)
+	assert.Equal(t, "", v.previous)
+	_, err = b.At(b.SeasonStart.Add(-time.Second))
+	assert.NotNil(t, err)
+
+	b.Period = AllTime
+	v, _ = b.At(at)
+	assert.Equal(t, "leaderboard://{period}/all", v.Key())
+	assert.True(t, v.End.IsZero())
+}
+
+func TestArchive(t *testing.T) {
+	ctx := context.Background()
+	r := redistest.New(t)
+	clock := redistest.NewClock(t, time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC))
+
+	b := New(r.Prefix + "daily")
+	b.Period = Daily
+	b.Archive = true
+	b.ArchiveRetention = 7 * 24 * time.Hour
+	// 期間の終了で削除される順位表は保存できない
+	_, _, err := b.Submit(ctx, "alice", 10)
+	assert.NotNil(t, err)
+	b.Retention = time.Hour
+	_, _, err = b.Submit(ctx, "alice", 10)
+	assert.Nil(t, err)
+	_, _, err = b.Submit(ctx, "bob", 20)
+	assert.Nil(t, err)
+	today, _ := b.Current()
+	assert.Equal(t, 13*time.Hour, redistest.Server().TTL(today.Key()))
+	assert.Equal(t, 13*time.Hour, redistest.Server().TTL(today.index()))
+
+	// 次の期間の最初の登録で、Retentionで残っている前の期間を保存する
+	clock.Advance(today.End.Sub(clock.Now()) + 30*time.Minute)
+	assert.True(t, redistest.Server().Exists(today.Key()))
+	_, _, err = b.Submit(ctx, "carol", 5)
+	assert.Nil(t, err)
+	archived, err := b.Archived(today.Start)
+	assert.Nil(t, err)
+	assert.Equal(t, 7*24*time.Hour, redistest.Server().TTL(archived.Key()))
+	top, err := archived.Top(ctx, 10)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"bob", "alice"}, ids(top))
+	e, err := archived.Rank(ctx, "alice")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(2), e.Rank)
+	_, _, err = archived.Submit(ctx, "dave", 1)
+	assert.NotNil(t, err)
+
+	// 保存は一度だけ
+	_, _, err = today.Submit(ctx, "dave", 100)
+	assert.Nil(t, err)
+	clock.Advance(time.Second)
+	_, _, err = b.Submit(ctx, "erin", 5)
+	assert.Nil(t, err)
+	top, _ = archived.Top(ctx, 10)
+	assert.Equal(t, []string{"bob", "alice"}, ids(top))
+
+	top, err = b.Top(ctx, 10)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"carol", "erin"}, ids(top))
+
+	// Retentionの間に登録がなかった期間は保存されない
+	current, _ := b.Current()
+	clock.Advance(current.End.Sub(clock.Now()) + 2*time.Hour)
+	_, _, err = b.Submit(ctx, "frank", 1)
+	assert.Nil(t, err)
+	archived, _ = b.Archived(current.Start)
+	assert.False(t, redistest.Server().Exists(archived.Key()))
+}
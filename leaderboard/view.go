@@ -0,0 +1,282 @@
+package leaderboard
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/goccha/redis-verse/redis"
+	goredis "github.com/redis/go-redis/v9"
+)
+
+// NotFoundError 順位表に登録されていない
+type NotFoundError struct {
+	ID string
+}
+
+func (err *NotFoundError) Error() string {
+	return fmt.Sprintf("leaderboard: %s is not ranked", err.ID)
+}
+
+func IsNotFound(err error) bool {
+	var e *NotFoundError
+	return errors.As(err, &e)
+}
+
+// Entry 順位表の1件
+type Entry struct {
+	Rank        int64 // 1から始まる順位
+	ID          string
+	Score       float64
+	SubmittedAt time.Time // 現在のスコアを登録した時刻
+}
+
+// View ある期間の順位表
+type View struct {
+	board    *Board
+	Label    string
+	Start    time.Time
+	End      time.Time // AllTimeの場合はゼロ値
+	key      string
+	previous string
+	archived bool
+}
+
+func (v *View) Key() string {
+	return v.key
+}
+
+// index メンバーIDから順位表のメンバーを引く
+func (v *View) index() string {
+	return v.key + "/index"
+}
+
+// maxMillis 降順の場合に先に登録したメンバーが辞書順で大きくなるように時刻を反転する
+const maxMillis = 9999999999999
+
+// member 同じスコアの場合は辞書順で並ぶため、登録時刻を先頭に付ける
+func (v *View) member(id string, at time.Time) string {
+	ms := at.UnixMilli()
+	if v.board.Order == Descending {
+		ms = maxMillis - ms
+	}
+	return fmt.Sprintf("%013d:%s", ms, id)
+}
+
+func (v *View) entry(rank int64, z goredis.Z) (Entry, error) {
+	member, _ := z.Member.(string)
+	i := strings.IndexByte(member, ':')
+	if i < 0 {
+		return Entry{}, fmt.Errorf("leaderboard: invalid member %q in %s", member, v.key)
+	}
+	ms, err := strconv.ParseInt(member[:i], 10, 64)
+	if err != nil {
+		return Entry{}, fmt.Errorf("leaderboard: invalid member %q in %s", member, v.key)
+	}
+	if v.board.Order == Descending {
+		ms = maxMillis - ms
+	}
+	return Entry{Rank: rank + 1, ID: member[i+1:], Score: z.Score, SubmittedAt: time.UnixMilli(ms)}, nil
+}
+
+// submit 以前の期間の保存、スコアの更新、有効期限の設定を原子的に行う。
+// KEYS: 順位表, 索引[, 前の期間の順位表, 前の期間の索引, 保存先の順位表, 保存先の索引]
+// ARGV: ID, スコア, 登録時刻, Policy, Order, 有効期限(ms), 保存期間(ms)
+var submit = goredis.NewScript(`
+if #KEYS == 6 and redis.call('EXISTS', KEYS[5]) == 0 and redis.call('EXISTS', KEYS[3]) == 1 then
+	redis.call('ZUNIONSTORE', KEYS[5], 1, KEYS[3])
+	local index = redis.call('HGETALL', KEYS[4])
+	for i = 1, #index, 2 do
+		redis.call('HSET', KEYS[6], index[i], index[i + 1])
+	end
+	if tonumber(ARGV[7]) > 0 then
+		redis.call('PEXPIRE', KEYS[5], ARGV[7])
+		redis.call('PEXPIRE', KEYS[6], ARGV[7])
+	end
+end
+local score = ARGV[2]
+local old = redis.call('HGET', KEYS[2], ARGV[1])
+if old then
+	local current = redis.call('ZSCORE', KEYS[1], old)
+	if current then
+		local c, s = tonumber(current), tonumber(score)
+		if ARGV[4] == 'sum' then
+			score = tostring(c + s)
+		elseif ARGV[4] == 'best' and ((ARGV[5] == 'desc' and s <= c) or (ARGV[5] == 'asc' and s >= c)) then
+			return {0, current}
+		end
+	end
+	redis.call('ZREM', KEYS[1], old)
+end
+local member = ARGV[3] .. ':' .. ARGV[1]
+redis.call('ZADD', KEYS[1], score, member)
+redis.call('HSET', KEYS[2], ARGV[1], member)
+if tonumber(ARGV[6]) > 0 then
+	redis.call('PEXPIREAT', KEYS[1], ARGV[6])
+	redis.call('PEXPIREAT', KEYS[2], ARGV[6])
+end
+return {1, score}`)
+
+var remove = goredis.NewScript(`
+local member = redis.call('HGET', KEYS[2], ARGV[1])
+if not member then
+	return 0
+end
+redis.call('HDEL', KEYS[2], ARGV[1])
+return redis.call('ZREM', KEYS[1], member)`)
+
+// Submit スコアを登録し、Policyを適用した後のスコアとスコアが更新されたかを返す
+func (v *View) Submit(ctx context.Context, id string, score float64) (current float64, updated bool, err error) {
+	if v.archived {
+		return 0, false, fmt.Errorf("leaderboard: %s is archived", v.key)
+	}
+	b := v.board
+	if err = b.validate(); err != nil {
+		return 0, false, err
+	}
+	now := redis.Now()
+	keys := []string{v.key, v.index()}
+	if b.Archive && v.previous != "" {
+		prev := b.key("", v.previous)
+		archive := b.key("archive/", v.previous)
+		keys = append(keys, prev, prev+"/index", archive, archive+"/index")
+	}
+	order := "desc"
+	if b.Order == Ascending {
+		order = "asc"
+	}
+	var expireAt int64
+	if !v.End.IsZero() {
+		expireAt = v.End.Add(b.Retention).UnixMilli()
+	}
+	member := v.member(id, now)
+	prefix := member[:strings.IndexByte(member, ':')]
+	err = redis.Universal(ctx, func(ctx context.Context, c redis.Cmdable) error {
+		result, err := submit.Run(ctx, c, keys, id, strconv.FormatFloat(score, 'f', -1, 64), prefix,
+			b.Policy.String(), order, expireAt, b.ArchiveRetention.Milliseconds()).Slice()
+		if err != nil {
+			return err
+		}
+		if len(result) != 2 {
+			return fmt.Errorf("leaderboard: unexpected result %v", result)
+		}
+		updated = result[0] == int64(1)
+		s, _ := result[1].(string)
+		current, err = strconv.ParseFloat(s, 64)
+		return err
+	})
+	return
+}
+
+// Remove 順位表から削除する
+func (v *View) Remove(ctx context.Context, id string) (removed bool, err error) {
+	err = redis.Universal(ctx, func(ctx context.Context, c redis.Cmdable) error {
+		n, err := remove.Run(ctx, c, []string{v.key, v.index()}, id).Int64()
+		removed = n > 0
+		return err
+	})
+	return
+}
+
+// Rank idの順位とスコア。登録されていない場合はNotFoundError
+func (v *View) Rank(ctx context.Context, id string) (entry *Entry, err error) {
+	err = redis.ReadOnly(ctx, func(ctx context.Context, c redis.Cmdable) error {
+		// 索引を読んだ後に更新された場合は読み直す
+		for i := 0; i < 3; i++ {
+			e, err := v.rank(ctx, c, id)
+			if redis.IsNil(err) {
+				continue
+			}
+			entry = e
+			return err
+		}
+		return &NotFoundError{ID: id}
+	})
+	return
+}
+
+func (v *View) rank(ctx context.Context, c redis.Cmdable, id string) (*Entry, error) {
+	member, err := c.HGet(ctx, v.index(), id).Result()
+	if redis.IsNil(err) {
+		return nil, &NotFoundError{ID: id}
+	} else if err != nil {
+		return nil, err
+	}
+	var rank *goredis.IntCmd
+	var score *goredis.FloatCmd
+	if _, err = c.Pipelined(ctx, func(pipe goredis.Pipeliner) error {
+		if v.board.Order == Ascending {
+			rank = pipe.ZRank(ctx, v.key, member)
+		} else {
+			rank = pipe.ZRevRank(ctx, v.key, member)
+		}
+		score = pipe.ZScore(ctx, v.key, member)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	e, err := v.entry(rank.Val(), goredis.Z{Member: member, Score: score.Val()})
+	if err != nil {
+		return nil, err
+	}
+	return &e, nil
+}
+
+// Top 上位n件
+func (v *View) Top(ctx context.Context, n int64) (entries []Entry, err error) {
+	if n <= 0 {
+		return nil, nil
+	}
+	err = redis.ReadOnly(ctx, func(ctx context.Context, c redis.Cmdable) error {
+		entries, err = v.rangeByRank(ctx, c, 0, n-1)
+		return err
+	})
+	return
+}
+
+// Around idの前後n件。idが登録されていない場合はNotFoundError
+func (v *View) Around(ctx context.Context, id string, n int64) (entries []Entry, err error) {
+	err = redis.ReadOnly(ctx, func(ctx context.Context, c redis.Cmdable) error {
+		var e *Entry
+		for i := 0; i < 3; i++ {
+			if e, err = v.rank(ctx, c, id); !redis.IsNil(err) {
+				break
+			}
+		}
+		if err != nil {
+			return err
+		}
+		start := e.Rank - 1 - n
+		if start < 0 {
+			start = 0
+		}
+		entries, err = v.rangeByRank(ctx, c, start, e.Rank-1+n)
+		return err
+	})
+	return
+}
+
+func (v *View) rangeByRank(ctx context.Context, c redis.Cmdable, start, stop int64) ([]Entry, error) {
+	var cmd *goredis.ZSliceCmd
+	if v.board.Order == Ascending {
+		cmd = c.ZRangeWithScores(ctx, v.key, start, stop)
+	} else {
+		cmd = c.ZRevRangeWithScores(ctx, v.key, start, stop)
+	}
+	values, err := cmd.Result()
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]Entry, 0, len(values))
+	for i, z := range values {
+		e, err := v.entry(start+int64(i), z)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
@@ -4,6 +4,9 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/goccha/logging/log"
@@ -22,6 +25,9 @@ func (err *Failure) Error() string {
 
 var ErrLock *Failure
 
+// Policy Redisに接続できない場合の振る舞い
+var Policy = redis.FailClosed
+
 func Failed(err error) bool {
 	return errors.As(err, &ErrLock)
 }
@@ -51,7 +57,12 @@ func (l *RedisLock) key() string {
 	return LockKey(l.Key)
 }
 func (l *RedisLock) Lock(ctx context.Context) (bool, error) {
-	return redis.Lock(ctx, l.key(), l.Duration)
+	ok, err := redis.Lock(ctx, l.key(), l.Duration)
+	if err != nil && Policy.Open(err) {
+		log.Warn(ctx).Err(err).Str("key", l.key()).Msg("locks: fail open")
+		return true, nil
+	}
+	return ok, err
 }
 func (l *RedisLock) UnLock(ctx context.Context) {
 	if cmd := redis.Primary().Del(ctx, l.key()); cmd.Err() != nil {
@@ -63,6 +74,9 @@ func (l *RedisLock) Locked(ctx context.Context) (bool, error) {
 	if cmd.Err() != nil {
 		if redis.IsNil(cmd.Err()) {
 			return false, nil
+		} else if Policy.Open(cmd.Err()) {
+			log.Warn(ctx).Err(cmd.Err()).Str("key", l.key()).Msg("locks: fail open")
+			return false, nil
 		}
 		return false, cmd.Err()
 	}
@@ -72,3 +86,53 @@ func (l *RedisLock) Locked(ctx context.Context) (bool, error) {
 	}
 	return false, nil
 }
+
+// Entry ロック中のキー
+type Entry struct {
+	Key     string        `json:"key"`     // ロックのキー(blocks://を除く)
+	TTL     time.Duration `json:"ttl"`     // 残り時間。期限がない場合は-1
+	Expires *time.Time    `json:"expires"` // 解除される日時
+}
+
+// List パターンに一致するロックを一覧する。patternを省略した場合はすべてのロック
+func List(ctx context.Context, pattern ...string) ([]Entry, error) {
+	p := "*"
+	if len(pattern) > 0 && pattern[0] != "" {
+		p = pattern[0]
+	}
+	var mu sync.Mutex
+	entries := make([]Entry, 0)
+	now := redis.Now()
+	err := redis.ScanKeys(ctx, LockKey(p), func(ctx context.Context, key string) error {
+		ttl, err := redis.Primary().TTL(ctx, key).Result()
+		if err != nil {
+			return err
+		}
+		if ttl == -2 { // 走査中に期限切れ
+			return nil
+		}
+		e := Entry{Key: strings.TrimPrefix(key, LockKey("")), TTL: ttl}
+		if ttl > 0 {
+			expires := now.Add(ttl)
+			e.Expires = &expires
+		}
+		mu.Lock()
+		entries = append(entries, e)
+		mu.Unlock()
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Key < entries[j].Key })
+	return entries, nil
+}
+
+// Unlock ロックを解除する。ロックされていなかった場合はfalseを返す
+func Unlock(ctx context.Context, key string) (bool, error) {
+	n, err := redis.Primary().Del(ctx, LockKey(key)).Result()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
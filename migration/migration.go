@@ -0,0 +1,524 @@
+package migration
+
+import (
+	"context"
+	"crypto/sha1" //nolint:gosec
+	"encoding/hex"
+	"fmt"
+	"math/rand"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/goccha/logging/log"
+	"github.com/goccha/redis-verse/redis"
+	goredis "github.com/redis/go-redis/v9"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+const instrumentationName = "github.com/goccha/redis-verse/migration"
+
+type Phase int32
+
+const (
+	ReadOld Phase = iota // 旧環境から読み込み、両方に書き込む
+	ReadNew              // 新環境から読み込み、両方に書き込む
+)
+
+func (p Phase) String() string {
+	if p == ReadNew {
+		return "read-new"
+	}
+	return "read-old"
+}
+
+// Mismatch シャドーリードで検出した不一致
+type Mismatch struct {
+	Command string
+	Key     string
+	Old     string
+	New     string
+}
+
+// Builder 2つの環境間で移行するためのBuilder。
+// 書き込みは両方の環境に行い、読み込みは現在のPhaseの環境から行う。
+// ReadOldの間はShadowRateの割合で新環境からも読み込み、結果を比較する。
+// シャドーリードは呼び出し元を待たせないよう非同期に実行し、同時に実行中の数がMaxShadowsを超える場合は省略する。
+// EVALSHAはEVAL/SCRIPT LOADで記録したスクリプトのEVALとしてミラーし、記録していない場合はNOSCRIPTを返す
+type Builder struct {
+	Old        redis.Builder
+	New        redis.Builder
+	ShadowRate float64                               // シャドーリードの割合(0〜1)
+	MaxShadows int                                   // 同時に実行するシャドーリードの上限。0の場合はdefaultMaxShadows
+	OnMismatch func(ctx context.Context, m Mismatch) // 不一致を検出した場合に呼び出す
+	phase      int32
+	mu         sync.RWMutex
+	old        *clients
+	new        *clients
+	once       sync.Once
+	mismatches metric.Int64Counter
+	mirrorErrs metric.Int64Counter
+	scripts    sync.Map // SHA1 → EVAL/SCRIPT LOADで実行されたスクリプト
+	shadows    chan struct{}
+	inflight   sync.WaitGroup
+}
+
+const defaultMaxShadows = 64
+
+type clients struct {
+	primary client
+	reader  client
+}
+
+type client interface {
+	goredis.Cmdable
+	Process(ctx context.Context, cmd goredis.Cmder) error
+}
+
+// readerClient Processを実装していないReaderはPrimaryで代用する
+func readerClient(p *redis.PrimaryClient, r *redis.ReaderClient) client {
+	if c, ok := r.Client.(client); ok {
+		return c
+	}
+	return p.Client
+}
+
+func New(old, new redis.Builder) *Builder {
+	return &Builder{Old: old, New: new}
+}
+
+func (b *Builder) Phase() Phase {
+	return Phase(atomic.LoadInt32(&b.phase))
+}
+
+// CutOver 読み込み先を新環境に切り替える。書き込みは引き続き両方に行う
+func (b *Builder) CutOver(ctx context.Context) {
+	b.setPhase(ctx, ReadNew)
+}
+
+// Rollback 読み込み先を旧環境に戻す
+func (b *Builder) Rollback(ctx context.Context) {
+	b.setPhase(ctx, ReadOld)
+}
+
+func (b *Builder) setPhase(ctx context.Context, p Phase) {
+	if prev := Phase(atomic.SwapInt32(&b.phase, int32(p))); prev != p {
+		log.Warn(ctx).Str("from", prev.String()).Str("to", p.String()).Msg("migration: phase changed")
+	}
+}
+
+func (b *Builder) init() {
+	n := b.MaxShadows
+	if n <= 0 {
+		n = defaultMaxShadows
+	}
+	b.shadows = make(chan struct{}, n)
+	meter := otel.Meter(instrumentationName)
+	var err error
+	if b.mismatches, err = meter.Int64Counter("redis.migration.mismatch",
+		metric.WithDescription("The number of shadow reads whose results differ between the old and new deployments")); err != nil {
+		log.Error(context.Background()).Err(err).Send()
+	}
+	if b.mirrorErrs, err = meter.Int64Counter("redis.migration.mirror_error",
+		metric.WithDescription("The number of writes that failed on the secondary deployment")); err != nil {
+		log.Error(context.Background()).Err(err).Send()
+	}
+}
+
+// Build 旧環境のクライアントにフックを追加して返す。フックが現在のPhaseに応じて実行先を切り替える
+func (b *Builder) Build(ctx context.Context, db ...int) (primary *redis.PrimaryClient, reader *redis.ReaderClient, err error) {
+	b.once.Do(b.init)
+	if primary, reader, err = b.Old.Build(ctx, db...); err != nil {
+		return
+	}
+	newPrimary, newReader, err := b.New.Build(ctx, db...)
+	if err != nil {
+		return nil, nil, err
+	}
+	b.mu.Lock()
+	b.old = &clients{primary: primary.Client, reader: readerClient(primary, reader)}
+	b.new = &clients{primary: newPrimary.Client, reader: readerClient(newPrimary, newReader)}
+	b.mu.Unlock()
+	primary.Client.AddHook(&hook{builder: b, role: redis.RolePrimary})
+	if c, ok := reader.Client.(interface{ AddHook(goredis.Hook) }); ok && reader.Client != goredis.Cmdable(primary.Client) {
+		c.AddHook(&hook{builder: b, role: redis.RoleReader})
+	}
+	return
+}
+
+// targets roleに対応する旧環境と新環境のクライアント
+func (b *Builder) targets(role string) (old, new client) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if role == redis.RoleReader {
+		return b.old.reader, b.new.reader
+	}
+	return b.old.primary, b.new.primary
+}
+
+func (b *Builder) shadow() bool {
+	return b.ShadowRate > 0 && (b.ShadowRate >= 1 || rand.Float64() < b.ShadowRate) //nolint:gosec
+}
+
+func (b *Builder) mirrorFailed(ctx context.Context, cmds []goredis.Cmder, err error) {
+	log.Warn(ctx).Err(err).Str("command", cmds[0].Name()).Int("commands", len(cmds)).Msg("migration: mirror write failed")
+	if b.mirrorErrs != nil {
+		b.mirrorErrs.Add(ctx, 1)
+	}
+}
+
+func (b *Builder) mismatch(ctx context.Context, m Mismatch) {
+	log.Warn(ctx).Str("command", m.Command).Str("key", redis.RedactHash(m.Key)).Msg("migration: shadow read mismatch")
+	if b.mismatches != nil {
+		b.mismatches.Add(ctx, 1, metric.WithAttributes(attribute.String("command", m.Command)))
+	}
+	if b.OnMismatch != nil {
+		b.OnMismatch(ctx, m)
+	}
+}
+
+// noScriptError スクリプトを記録していないEVALSHA。
+// go-redisのScript.RunがEVALで再実行するように、Redisのエラーとして返す
+type noScriptError string
+
+func (err noScriptError) Error() string {
+	return string(err)
+}
+
+func (noScriptError) RedisError() {}
+
+const errNoScript = noScriptError("NOSCRIPT No matching script to mirror. Please use EVAL.")
+
+// remember EVALとSCRIPT LOADのスクリプトを記録する
+func (b *Builder) remember(cmd goredis.Cmder) {
+	args := cmd.Args()
+	var body string
+	switch name := cmd.Name(); {
+	case (name == "eval" || name == "eval_ro") && len(args) > 1:
+		body, _ = args[1].(string)
+	case name == "script" && len(args) > 2 && strings.EqualFold(fmt.Sprint(args[1]), "load"):
+		body, _ = args[2].(string)
+	}
+	if body != "" {
+		sum := sha1.Sum([]byte(body)) //nolint:gosec
+		b.scripts.Store(hex.EncodeToString(sum[:]), body)
+	}
+}
+
+func isEvalSha(cmd goredis.Cmder) bool {
+	name := cmd.Name()
+	return (name == "evalsha" || name == "evalsha_ro") && len(cmd.Args()) > 1
+}
+
+func (b *Builder) script(cmd goredis.Cmder) (string, bool) {
+	if !isEvalSha(cmd) {
+		return "", false
+	}
+	v, ok := b.scripts.Load(strings.ToLower(fmt.Sprint(cmd.Args()[1])))
+	if !ok {
+		return "", false
+	}
+	return v.(string), true
+}
+
+// unknownScript 書き込み先の一方にしかないスクリプトのEVALSHAは実行せずにNOSCRIPTを返す。
+// 呼び出し元がEVALで再実行すると、スクリプトが記録されて両方の環境で実行できる
+func (b *Builder) unknownScript(cmds ...goredis.Cmder) bool {
+	for _, cmd := range cmds {
+		if _, ok := b.script(cmd); isEvalSha(cmd) && !ok {
+			return true
+		}
+	}
+	return false
+}
+
+// mirror セカンダリに送るコマンド。EVALSHAはセカンダリにスクリプトがない場合があるため、記録したスクリプトのEVALにする
+func (b *Builder) mirror(ctx context.Context, cmd goredis.Cmder) *goredis.Cmd {
+	body, ok := b.script(cmd)
+	if !ok {
+		return copyCmd(ctx, cmd)
+	}
+	name := "eval"
+	if cmd.Name() == "evalsha_ro" {
+		name = "eval_ro"
+	}
+	args := cmd.Args()
+	return goredis.NewCmd(ctx, append([]interface{}{name, body}, args[2:]...)...)
+}
+
+type hook struct {
+	builder *Builder
+	role    string
+}
+
+// bypassKey 旧環境へのミラー書き込みがフックを再度通らないようにする
+type bypassKey struct{}
+
+func bypass(ctx context.Context) context.Context {
+	return context.WithValue(ctx, bypassKey{}, true)
+}
+
+func bypassed(ctx context.Context) bool {
+	return ctx.Value(bypassKey{}) != nil
+}
+
+func (h *hook) DialHook(next goredis.DialHook) goredis.DialHook {
+	return next
+}
+
+func (h *hook) ProcessHook(next goredis.ProcessHook) goredis.ProcessHook {
+	return func(ctx context.Context, cmd goredis.Cmder) error {
+		if bypassed(ctx) {
+			return next(ctx, cmd)
+		}
+		b := h.builder
+		old, new := b.targets(h.role)
+		read := isReadOnly(cmd)
+		if !read && b.unknownScript(cmd) {
+			cmd.SetErr(errNoScript)
+			return errNoScript
+		}
+		b.remember(cmd)
+		primary, secondary := old, new
+		if b.Phase() == ReadNew {
+			primary, secondary = new, old
+		}
+		var err error
+		if primary == old {
+			// go-redisはフックの外側でエラーを設定するため、比較の前に設定する
+			if err = next(ctx, cmd); err != nil {
+				cmd.SetErr(err)
+			}
+		} else {
+			err = primary.Process(ctx, cmd)
+		}
+		switch {
+		case !read:
+			if err == nil || redis.IsNil(err) {
+				copied := b.mirror(ctx, cmd)
+				if e := secondary.Process(bypass(ctx), copied); e != nil && !redis.IsNil(e) {
+					b.mirrorFailed(ctx, []goredis.Cmder{copied}, e)
+				}
+			}
+		case primary == old && b.shadow():
+			h.compare(ctx, cmd, new)
+		}
+		return err
+	}
+}
+
+// compare 新環境で同じコマンドを非同期に実行して結果を比較する。実行中のシャドーリードが上限に達している場合は比較しない
+func (h *hook) compare(ctx context.Context, cmd goredis.Cmder, new client) {
+	b := h.builder
+	select {
+	case b.shadows <- struct{}{}:
+	default:
+		return
+	}
+	name, o := cmd.Name(), result(cmd)
+	key := ""
+	if keys := redis.CommandKeys(cmd); len(keys) > 0 {
+		key = keys[0]
+	}
+	ctx = detach(ctx)
+	shadow := copyCmd(ctx, cmd)
+	b.inflight.Add(1)
+	go func() {
+		defer func() {
+			<-b.shadows
+			b.inflight.Done()
+		}()
+		err := new.Process(ctx, shadow)
+		if err != nil && !redis.IsNil(err) {
+			log.Debug(ctx).Err(err).Str("command", name).Msg("migration: shadow read failed")
+			return
+		}
+		if n := result(shadow); o != n {
+			b.mismatch(ctx, Mismatch{Command: name, Key: key, Old: o, New: n})
+		}
+	}()
+}
+
+// detached 呼び出し元の処理が終わった後も値だけを引き継ぐctx
+type detached struct {
+	context.Context
+}
+
+func detach(ctx context.Context) context.Context {
+	return detached{ctx}
+}
+
+func (detached) Deadline() (time.Time, bool) {
+	return time.Time{}, false
+}
+
+func (detached) Done() <-chan struct{} {
+	return nil
+}
+
+func (detached) Err() error {
+	return nil
+}
+
+func (h *hook) ProcessPipelineHook(next goredis.ProcessPipelineHook) goredis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []goredis.Cmder) error {
+		if bypassed(ctx) {
+			return next(ctx, cmds)
+		}
+		b := h.builder
+		old, new := b.targets(h.role)
+		if b.unknownScript(cmds...) {
+			for _, cmd := range cmds {
+				cmd.SetErr(errNoScript)
+			}
+			return errNoScript
+		}
+		for _, cmd := range cmds {
+			b.remember(cmd)
+		}
+		primary, secondary := old, new
+		if b.Phase() == ReadNew {
+			primary, secondary = new, old
+		}
+		tx := isTx(cmds)
+		var err error
+		if primary == old {
+			err = next(ctx, cmds)
+		} else {
+			err = execute(ctx, primary, tx, body(cmds))
+		}
+		// 接続のエラーはどのコマンドが実行されたか分からないためミラーしない
+		if redis.IsUnavailable(err) || redis.IsClosed(err) {
+			return err
+		}
+		// GETの結果がないなど、一部のコマンドが失敗しても成功した書き込みはミラーする
+		written := writes(cmds)
+		if len(written) == 0 {
+			return err
+		}
+		copied := make([]goredis.Cmder, 0, len(written))
+		for _, cmd := range written {
+			copied = append(copied, b.mirror(ctx, cmd))
+		}
+		if e := execute(bypass(ctx), secondary, tx, copied); e != nil && !redis.IsNil(e) {
+			b.mirrorFailed(ctx, copied, e)
+		}
+		return err
+	}
+}
+
+// execute cmdsをクライアントで実行する。txの場合はMULTI/EXECで囲んでトランザクションとして実行する
+func execute(ctx context.Context, c goredis.Cmdable, tx bool, cmds []goredis.Cmder) error {
+	var pipe goredis.Pipeliner
+	if tx {
+		pipe = c.TxPipeline()
+	} else {
+		pipe = c.Pipeline()
+	}
+	for _, cmd := range cmds {
+		_ = pipe.Process(ctx, cmd)
+	}
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+func isTx(cmds []goredis.Cmder) bool {
+	return len(cmds) >= 2 && cmds[0].Name() == "multi" && cmds[len(cmds)-1].Name() == "exec"
+}
+
+// body MULTI/EXECを除いたコマンド
+func body(cmds []goredis.Cmder) []goredis.Cmder {
+	if isTx(cmds) {
+		return cmds[1 : len(cmds)-1]
+	}
+	return cmds
+}
+
+// writes 成功した書き込みのコマンド
+func writes(cmds []goredis.Cmder) []goredis.Cmder {
+	var result []goredis.Cmder
+	for _, cmd := range body(cmds) {
+		if err := cmd.Err(); !isReadOnly(cmd) && (err == nil || redis.IsNil(err)) {
+			result = append(result, cmd)
+		}
+	}
+	return result
+}
+
+func copyCmd(ctx context.Context, cmd goredis.Cmder) *goredis.Cmd {
+	return goredis.NewCmd(ctx, cmd.Args()...)
+}
+
+// readOnlyCommands 旧環境にだけ送ればよいコマンド
+var readOnlyCommands = map[string]bool{
+	"get": true, "mget": true, "getrange": true, "strlen": true, "exists": true, "type": true,
+	"ttl": true, "pttl": true, "expiretime": true, "pexpiretime": true,
+	"hget": true, "hmget": true, "hgetall": true, "hexists": true, "hlen": true, "hkeys": true, "hvals": true, "hstrlen": true,
+	"lrange": true, "llen": true, "lindex": true, "lpos": true,
+	"smembers": true, "sismember": true, "smismember": true, "scard": true, "srandmember": true,
+	"sinter": true, "sunion": true, "sdiff": true,
+	"zrange": true, "zrangebyscore": true, "zrangebylex": true, "zrevrange": true, "zrevrangebyscore": true,
+	"zscore": true, "zmscore": true, "zcard": true, "zcount": true, "zlexcount": true, "zrank": true, "zrevrank": true,
+	"getbit": true, "bitcount": true, "bitpos": true, "pfcount": true,
+	"xrange": true, "xrevrange": true, "xlen": true, "xread": true,
+	"scan": true, "hscan": true, "sscan": true, "zscan": true, "keys": true, "randomkey": true, "dbsize": true,
+	"ping": true, "echo": true, "time": true, "info": true,
+}
+
+func isReadOnly(cmd goredis.Cmder) bool {
+	return readOnlyCommands[cmd.Name()]
+}
+
+// result 型の異なるCmdの結果を比較できる文字列にする
+func result(cmd goredis.Cmder) string {
+	if err := cmd.Err(); err != nil {
+		if redis.IsNil(err) {
+			return "(nil)"
+		}
+		return "error: " + err.Error()
+	}
+	m := reflect.ValueOf(cmd).MethodByName("Val")
+	if !m.IsValid() {
+		return cmd.String()
+	}
+	return normalize(m.Call(nil)[0])
+}
+
+func normalize(v reflect.Value) string {
+	for v.Kind() == reflect.Interface || v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return "(nil)"
+		}
+		v = v.Elem()
+	}
+	switch v.Kind() {
+	case reflect.Bool:
+		if v.Bool() {
+			return "1"
+		}
+		return "0"
+	case reflect.Slice, reflect.Array:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return string(v.Bytes())
+		}
+		values := make([]string, 0, v.Len())
+		for i := 0; i < v.Len(); i++ {
+			values = append(values, normalize(v.Index(i)))
+		}
+		return "[" + strings.Join(values, " ") + "]"
+	case reflect.Map:
+		values := make([]string, 0, v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			values = append(values, normalize(iter.Key())+"="+normalize(iter.Value()))
+		}
+		sort.Strings(values)
+		return "{" + strings.Join(values, " ") + "}"
+	}
+	return fmt.Sprint(v.Interface())
+}
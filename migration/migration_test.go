@@ -0,0 +1,200 @@
+package migration
+
+import (
+	"context"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/goccha/redis-verse/redis"
+	"github.com/goccha/redis-verse/redistest"
+	goredis "github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	builder    *Builder
+	oldBuilder *redistest.MemoryBuilder
+	newBuilder *redistest.MemoryBuilder
+	mu         sync.Mutex
+	mismatches []Mismatch
+)
+
+func TestMain(m *testing.M) {
+	ctx := context.Background()
+	oldBuilder, newBuilder = &redistest.MemoryBuilder{}, &redistest.MemoryBuilder{}
+	builder = New(oldBuilder, newBuilder)
+	builder.ShadowRate = 1
+	builder.OnMismatch = func(ctx context.Context, m Mismatch) {
+		mu.Lock()
+		defer mu.Unlock()
+		mismatches = append(mismatches, m)
+	}
+	if err := redis.Setup(ctx, builder); err != nil {
+		panic(err)
+	}
+	code := m.Run()
+	oldBuilder.Close()
+	newBuilder.Close()
+	os.Exit(code)
+}
+
+// takeMismatches 実行中のシャドーリードを待ってから不一致を返す
+func takeMismatches() []Mismatch {
+	builder.inflight.Wait()
+	mu.Lock()
+	defer mu.Unlock()
+	m := mismatches
+	mismatches = nil
+	return m
+}
+
+func TestBuilder_DualWrite(t *testing.T) {
+	ctx := context.Background()
+	err := redis.Universal(ctx, func(ctx context.Context, c redis.Cmdable) error {
+		return c.Set(ctx, "migration://write", "1", time.Minute).Err()
+	})
+	assert.Nil(t, err)
+	v, _ := oldBuilder.Server().Get("migration://write")
+	assert.Equal(t, "1", v)
+	v, _ = newBuilder.Server().Get("migration://write")
+	assert.Equal(t, "1", v)
+
+	_, err = redis.Primary().TxPipelined(ctx, func(pipe goredis.Pipeliner) error {
+		pipe.HSet(ctx, "migration://tx", "f", "v")
+		pipe.Incr(ctx, "migration://pipe")
+		return nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, "v", newBuilder.Server().HGet("migration://tx", "f"))
+	v, _ = newBuilder.Server().Get("migration://pipe")
+	assert.Equal(t, "1", v)
+
+	// GETの結果がなくても、同じパイプラインの成功した書き込みはミラーする
+	cmds, err := redis.Primary().Pipelined(ctx, func(pipe goredis.Pipeliner) error {
+		pipe.Get(ctx, "migration://pipe/missing")
+		pipe.Set(ctx, "migration://pipe/set", "1", 0)
+		pipe.HIncrBy(ctx, "migration://write", "f", 1) // WRONGTYPE
+		pipe.Incr(ctx, "migration://pipe")
+		return nil
+	})
+	assert.True(t, redis.IsNil(err))
+	assert.NotNil(t, cmds[2].Err())
+	v, _ = newBuilder.Server().Get("migration://pipe/set")
+	assert.Equal(t, "1", v)
+	v, _ = newBuilder.Server().Get("migration://pipe")
+	assert.Equal(t, "2", v)
+
+	var value string
+	err = redis.ReadOnly(ctx, func(ctx context.Context, c redis.Cmdable) (err error) {
+		value, err = c.Get(ctx, "migration://write").Result()
+		return
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, "1", value)
+	assert.Empty(t, takeMismatches())
+}
+
+func TestBuilder_Shadow(t *testing.T) {
+	ctx := context.Background()
+	assert.Nil(t, oldBuilder.Server().Set("migration://shadow", "old"))
+	var value string
+	err := redis.ReadOnly(ctx, func(ctx context.Context, c redis.Cmdable) (err error) {
+		value, err = c.Get(ctx, "migration://shadow").Result()
+		return
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, "old", value)
+	m := takeMismatches()
+	if assert.Len(t, m, 1) {
+		assert.Equal(t, Mismatch{Command: "get", Key: "migration://shadow", Old: "old", New: "(nil)"}, m[0])
+	}
+
+	assert.Nil(t, newBuilder.Server().Set("migration://shadow/missing", ""))
+	assert.True(t, redis.IsNil(redis.Primary().Get(ctx, "migration://shadow/missing").Err()))
+	m = takeMismatches()
+	if assert.Len(t, m, 1) {
+		assert.Equal(t, "(nil)", m[0].Old)
+	}
+
+	oldBuilder.Server().HSet("migration://shadow/hash", "a", "1", "b", "2")
+	newBuilder.Server().HSet("migration://shadow/hash", "b", "2", "a", "1")
+	assert.Nil(t, redis.Primary().HGetAll(ctx, "migration://shadow/hash").Err())
+	assert.Empty(t, takeMismatches())
+}
+
+func TestBuilder_CutOver(t *testing.T) {
+	ctx := context.Background()
+	defer builder.Rollback(ctx)
+	assert.Nil(t, oldBuilder.Server().Set("migration://cutover", "old"))
+	assert.Nil(t, newBuilder.Server().Set("migration://cutover", "new"))
+
+	builder.CutOver(ctx)
+	assert.Equal(t, ReadNew, builder.Phase())
+	value, err := redis.Reader().Get(ctx, "migration://cutover").Result()
+	assert.Nil(t, err)
+	assert.Equal(t, "new", value)
+	assert.Empty(t, takeMismatches())
+
+	assert.Nil(t, redis.Primary().Set(ctx, "migration://cutover/write", "1", 0).Err())
+	v, _ := oldBuilder.Server().Get("migration://cutover/write")
+	assert.Equal(t, "1", v)
+	v, _ = newBuilder.Server().Get("migration://cutover/write")
+	assert.Equal(t, "1", v)
+
+	cmds, err := redis.Primary().Pipelined(ctx, func(pipe goredis.Pipeliner) error {
+		pipe.Get(ctx, "migration://cutover")
+		pipe.Incr(ctx, "migration://cutover/count")
+		return nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, "new", cmds[0].(*goredis.StringCmd).Val())
+	v, _ = oldBuilder.Server().Get("migration://cutover/count")
+	assert.Equal(t, "1", v)
+
+	builder.Rollback(ctx)
+	value, err = redis.Reader().Get(ctx, "migration://cutover").Result()
+	assert.Nil(t, err)
+	assert.Equal(t, "old", value)
+	assert.Len(t, takeMismatches(), 1)
+}
+
+func TestBuilder_Script(t *testing.T) {
+	ctx := context.Background()
+	defer builder.Rollback(ctx)
+	script := goredis.NewScript(`return redis.call('INCRBY', KEYS[1], ARGV[1])`)
+	// 移行前に旧環境にだけ読み込まれたスクリプト
+	c := goredis.NewClient(&goredis.Options{Addr: oldBuilder.Server().Addr()})
+	defer c.Close()
+	assert.Nil(t, script.Load(ctx, c).Err())
+
+	n, err := script.Run(ctx, redis.Primary(), []string{"migration://script"}, 2).Int64()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(2), n)
+	v, _ := newBuilder.Server().Get("migration://script")
+	assert.Equal(t, "2", v)
+
+	builder.CutOver(ctx)
+	n, err = script.Run(ctx, redis.Primary(), []string{"migration://script"}, 3).Int64()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(5), n)
+	v, _ = oldBuilder.Server().Get("migration://script")
+	assert.Equal(t, "5", v)
+
+	_, err = redis.Primary().Pipelined(ctx, func(pipe goredis.Pipeliner) error {
+		script.EvalSha(ctx, pipe, []string{"migration://script"}, 1)
+		return nil
+	})
+	assert.Nil(t, err)
+	v, _ = oldBuilder.Server().Get("migration://script")
+	assert.Equal(t, "6", v)
+	v, _ = newBuilder.Server().Get("migration://script")
+	assert.Equal(t, "6", v)
+
+	_, err = redis.Primary().Pipelined(ctx, func(pipe goredis.Pipeliner) error {
+		pipe.EvalSha(ctx, "0000000000000000000000000000000000000000", []string{"migration://script"})
+		return nil
+	})
+	assert.True(t, redis.IsNoScript(err))
+}
@@ -0,0 +1,248 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/goccha/logging/log"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	RolePrimary = "primary"
+	RoleReader  = "reader"
+)
+
+type BreakerState int
+
+const (
+	Closed BreakerState = iota
+	Open
+	HalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case Open:
+		return "open"
+	case HalfOpen:
+		return "half-open"
+	default:
+		return "closed"
+	}
+}
+
+type CircuitOpenError struct {
+	Role string
+}
+
+func (err *CircuitOpenError) Error() string {
+	return "redis: circuit breaker is open (" + err.Role + ")"
+}
+
+func IsCircuitOpen(err error) bool {
+	open := &CircuitOpenError{}
+	return errors.As(err, &open)
+}
+
+// FailurePolicy Redisに接続できない場合の振る舞い
+type FailurePolicy int
+
+const (
+	FailClosed FailurePolicy = iota // エラーとして扱う
+	FailOpen                        // Redisを使わずに処理を続行する
+)
+
+// Open Redisを使わずに処理を続行するか判定する
+func (p FailurePolicy) Open(err error) bool {
+	return p == FailOpen && IsUnavailable(err)
+}
+
+// CircuitBreaker クライアント単位のサーキットブレーカー
+type CircuitBreaker struct {
+	FailureThreshold int           // オープンするまでの連続失敗回数
+	OpenTimeout      time.Duration // ハーフオープンに移行するまでの時間
+	HalfOpenRequests int           // ハーフオープン時に許可する同時リクエスト数
+	OnStateChange    func(role string, from, to BreakerState)
+	mu               sync.Mutex
+	circuits         map[string]*circuit
+}
+
+var breaker *CircuitBreaker
+
+// SetCircuitBreaker Setup/Refreshで作成するクライアントにサーキットブレーカーを設定する
+func SetCircuitBreaker(b *CircuitBreaker) {
+	breaker = b
+}
+
+// CircuitState 現在のサーキットの状態を返す
+func CircuitState(role string) BreakerState {
+	if breaker == nil {
+		return Closed
+	}
+	breaker.mu.Lock()
+	c := breaker.circuits[role]
+	breaker.mu.Unlock()
+	if c == nil {
+		return Closed
+	}
+	return c.current()
+}
+
+// WithFallback fを実行し、Redisに接続できない場合はfallbackを実行する
+func WithFallback(ctx context.Context, f func(ctx context.Context) error, fallback func(ctx context.Context, err error) error) error {
+	if err := f(ctx); err != nil {
+		if fallback != nil && IsUnavailable(err) {
+			return fallback(ctx, err)
+		}
+		return err
+	}
+	return nil
+}
+
+func (b *CircuitBreaker) install(primary *PrimaryClient, reader *ReaderClient) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.circuits = make(map[string]*circuit)
+	c := &circuit{breaker: b, role: RolePrimary}
+	b.circuits[RolePrimary] = c
+	primary.Client.AddHook(c)
+	if reader.Client == Cmdable(primary.Client) {
+		b.circuits[RoleReader] = c
+		return
+	}
+	if h, ok := reader.Client.(interface{ AddHook(redis.Hook) }); ok {
+		c = &circuit{breaker: b, role: RoleReader}
+		b.circuits[RoleReader] = c
+		h.AddHook(c)
+	}
+}
+
+func (b *CircuitBreaker) threshold() int {
+	if b.FailureThreshold <= 0 {
+		return 5
+	}
+	return b.FailureThreshold
+}
+
+func (b *CircuitBreaker) openTimeout() time.Duration {
+	if b.OpenTimeout <= 0 {
+		return 10 * time.Second
+	}
+	return b.OpenTimeout
+}
+
+func (b *CircuitBreaker) halfOpenRequests() int {
+	if b.HalfOpenRequests <= 0 {
+		return 1
+	}
+	return b.HalfOpenRequests
+}
+
+type circuit struct {
+	breaker  *CircuitBreaker
+	role     string
+	mu       sync.Mutex
+	state    BreakerState
+	failures int
+	openedAt time.Time
+	trials   int
+}
+
+func (c *circuit) current() BreakerState {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.state == Open && Now().Sub(c.openedAt) >= c.breaker.openTimeout() {
+		return HalfOpen
+	}
+	return c.state
+}
+
+func (c *circuit) allow() error {
+	c.mu.Lock()
+	notify := func() {}
+	switch c.state {
+	case Open:
+		if Now().Sub(c.openedAt) < c.breaker.openTimeout() {
+			c.mu.Unlock()
+			return &CircuitOpenError{Role: c.role}
+		}
+		notify = c.transit(HalfOpen)
+		c.trials = 1
+	case HalfOpen:
+		if c.trials >= c.breaker.halfOpenRequests() {
+			c.mu.Unlock()
+			return &CircuitOpenError{Role: c.role}
+		}
+		c.trials++
+	}
+	c.mu.Unlock()
+	notify()
+	return nil
+}
+
+func (c *circuit) done(err error) {
+	c.mu.Lock()
+	notify := func() {}
+	if IsUnavailable(err) && !IsCircuitOpen(err) {
+		c.failures++
+		if c.state == HalfOpen || c.failures >= c.breaker.threshold() {
+			c.openedAt = Now()
+			notify = c.transit(Open)
+		}
+	} else {
+		c.failures = 0
+		notify = c.transit(Closed)
+	}
+	c.mu.Unlock()
+	notify()
+}
+
+// transit 状態を変更し、変更を通知する関数を返す。通知はロックの外で呼び出す
+func (c *circuit) transit(to BreakerState) func() {
+	from := c.state
+	if from == to {
+		return func() {}
+	}
+	c.state = to
+	c.trials = 0
+	return func() {
+		log.Warn(context.Background()).Str("role", c.role).
+			Str("from", from.String()).Str("to", to.String()).Msg("redis: circuit breaker")
+		if f := c.breaker.OnStateChange; f != nil {
+			f(c.role, from, to)
+		}
+	}
+}
+
+func (c *circuit) DialHook(next redis.DialHook) redis.DialHook {
+	return next
+}
+
+func (c *circuit) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		if err := c.allow(); err != nil {
+			cmd.SetErr(err)
+			return err
+		}
+		err := next(ctx, cmd)
+		c.done(err)
+		return err
+	}
+}
+
+func (c *circuit) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		if err := c.allow(); err != nil {
+			for _, cmd := range cmds {
+				cmd.SetErr(err)
+			}
+			return err
+		}
+		err := next(ctx, cmds)
+		c.done(err)
+		return err
+	}
+}
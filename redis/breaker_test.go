@@ -0,0 +1,81 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *testClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *testClock) Sleep(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	ctx := context.Background()
+	clock := &testClock{now: time.Now()}
+	SetClock(clock)
+	defer SetClock(nil)
+	var changes []BreakerState
+	SetCircuitBreaker(&CircuitBreaker{
+		FailureThreshold: 2,
+		OpenTimeout:      time.Minute,
+		OnStateChange: func(role string, from, to BreakerState) {
+			assert.Equal(t, RolePrimary, role)
+			changes = append(changes, to)
+		},
+	})
+	defer SetCircuitBreaker(nil)
+	b := &MemoryBuilder{}
+	defer b.Close()
+	assert.Nil(t, Setup(ctx, b))
+	assert.Nil(t, Primary().Ping(ctx).Err())
+	assert.Equal(t, Closed, CircuitState(RolePrimary))
+
+	b.Server().Close()
+	for i := 0; i < 2; i++ {
+		err := Primary().Ping(ctx).Err()
+		assert.True(t, IsUnavailable(err))
+		assert.False(t, IsCircuitOpen(err))
+	}
+	assert.Equal(t, Open, CircuitState(RolePrimary))
+	err := Primary().Get(ctx, "breaker://key").Err()
+	assert.True(t, IsCircuitOpen(err))
+	err = ReadOnly(ctx, func(ctx context.Context, c Cmdable) error {
+		return c.Get(ctx, "breaker://key").Err()
+	})
+	assert.True(t, IsCircuitOpen(err))
+
+	err = WithFallback(ctx, func(ctx context.Context) error {
+		return Primary().Get(ctx, "breaker://key").Err()
+	}, func(ctx context.Context, err error) error {
+		return nil
+	})
+	assert.Nil(t, err)
+	assert.True(t, FailOpen.Open(&CircuitOpenError{}))
+	assert.False(t, FailClosed.Open(&CircuitOpenError{}))
+	assert.False(t, FailOpen.Open(errors.New("ERR syntax error")))
+
+	assert.Nil(t, b.Server().Restart())
+	clock.Sleep(time.Minute)
+	assert.Equal(t, HalfOpen, CircuitState(RolePrimary))
+	assert.Nil(t, Primary().Ping(ctx).Err())
+	assert.Equal(t, Closed, CircuitState(RolePrimary))
+	assert.Equal(t, []BreakerState{Open, HalfOpen, Closed}, changes)
+}
@@ -0,0 +1,324 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// ChunkError 分割した値の一部が見つからない
+type ChunkError struct {
+	Key   string
+	Chunk int
+}
+
+func (err *ChunkError) Error() string {
+	return fmt.Sprintf("redis: chunk %d of %s is missing", err.Chunk, err.Key)
+}
+
+func IsChunkError(err error) bool {
+	var e *ChunkError
+	return errors.As(err, &e)
+}
+
+// ErrChunkTTL 分割する値に有効期限が指定されていない
+var ErrChunkTTL = errors.New("redis: values larger than ChunkSize require a TTL")
+
+// chunkCount 目録の分割数を返す。目録でない場合は0。大きな値を読み込まないように先頭だけを読む
+const chunkCount = `
+local function chunkCount(key, header)
+	local t = redis.call('TYPE', key)
+	if type(t) == 'table' then
+		t = t.ok
+	end
+	if t ~= 'string' then
+		return 0
+	end
+	local head = redis.call('GETRANGE', key, 0, #header + 40)
+	if string.sub(head, 1, #header) ~= header then
+		return 0
+	end
+	return tonumber(string.match(string.sub(head, #header + 1), '^(%d+):')) or 0
+end
+`
+
+// writeChunks 目録と分割した値を同じ有効期限で保存し、以前の値の余分な分割キーを削除する。
+// 分割しない値は分割キーを指定せずに保存し、以前の値の分割キーを全て削除する。
+// KEYS: 元のキー, 分割キー... ARGV: 目録または値, 分割した値..., 分割キーの接頭辞, 目録のヘッダー, SETのオプション...
+const writeChunks = chunkCount + `
+local n = #KEYS - 1
+local prefix, header = ARGV[n + 2], ARGV[n + 3]
+local opts = {}
+for i = n + 4, #ARGV do
+	opts[#opts + 1] = ARGV[i]
+end
+if n > 0 then
+	local expires = false
+	for _, opt in ipairs(opts) do
+		local o = string.upper(opt)
+		if o == 'EX' or o == 'PX' or o == 'EXAT' or o == 'PXAT' then
+			expires = true
+		elseif o == 'KEEPTTL' then
+			expires = redis.call('PTTL', KEYS[1]) > 0
+		end
+	end
+	if not expires then
+		return redis.error_reply('ERR chunked values require a TTL')
+	end
+end
+local m = chunkCount(KEYS[1], header)
+local res = redis.call('SET', KEYS[1], ARGV[1], unpack(opts))
+if not res then
+	return false
+end
+local ttl = redis.call('PTTL', KEYS[1])
+for i = 1, n do
+	redis.call('SET', KEYS[i + 1], ARGV[i + 1], 'PX', ttl)
+end
+for i = n + 1, m do
+	redis.call('DEL', prefix .. i)
+end
+return res`
+
+// deleteChunks 削除するキーが目録であれば分割キーも削除する。
+// KEYS: 削除するキー... ARGV: 目録のヘッダー, DELまたはUNLINK, 分割キーの接頭辞(対象外のキーは空)...
+const deleteChunks = chunkCount + `
+for i, key in ipairs(KEYS) do
+	local prefix = ARGV[i + 2]
+	if prefix ~= '' then
+		for j = 1, chunkCount(key, ARGV[1]) do
+			redis.call(ARGV[2], prefix .. j)
+		end
+	end
+end
+return redis.call(ARGV[2], unpack(KEYS))`
+
+// chunkPrefix 分割キーの接頭辞。クラスターでも元のキーと同じスロットになるようにハッシュタグを付ける
+func chunkPrefix(key string) string {
+	if hasHashTag(key) {
+		return key + "/chunk/"
+	}
+	return "{" + key + "}/chunk/"
+}
+
+func hasHashTag(key string) bool {
+	if s := strings.IndexByte(key, '{'); s >= 0 {
+		if e := strings.IndexByte(key[s+1:], '}'); e > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// isChunkKey chunkPrefixで作成した分割キーか判定する
+func isChunkKey(key string) bool {
+	i := strings.LastIndex(key, "/chunk/")
+	if i < 0 {
+		return false
+	}
+	prefix := key[:i+len("/chunk/")]
+	if _, err := strconv.Atoi(key[len(prefix):]); err != nil {
+		return false
+	}
+	parent := key[:i]
+	if strings.HasPrefix(parent, "{") && strings.HasSuffix(parent, "}") && chunkPrefix(parent[1:len(parent)-1]) == prefix {
+		return true
+	}
+	return chunkPrefix(parent) == prefix
+}
+
+func chunkKeys(key string, n int) []string {
+	prefix := chunkPrefix(key)
+	keys := make([]string, 0, n)
+	for i := 1; i <= n; i++ {
+		keys = append(keys, prefix+strconv.Itoa(i))
+	}
+	return keys
+}
+
+// manifestHeader 分割した値の代わりに元のキーに保存する目録のヘッダー
+var manifestHeader = string(appendHeader(nil, FormatChunk))
+
+// manifest 分割数と元の大きさ
+func manifest(n, size int) string {
+	return manifestHeader + strconv.Itoa(n) + ":" + strconv.Itoa(size)
+}
+
+// parseManifest 目録でない場合はfalse、対応していない版の目録の場合はFormatError
+func parseManifest(s string) (n, size int, ok bool, err error) {
+	body, ok, err := parseHeader([]byte(s), FormatChunk)
+	if !ok || err != nil {
+		return 0, 0, false, err
+	}
+	v := strings.SplitN(string(body), ":", 2)
+	if len(v) != 2 {
+		return 0, 0, false, nil
+	}
+	if n, err = strconv.Atoi(v[0]); err != nil || n <= 0 {
+		return 0, 0, false, nil
+	}
+	if size, err = strconv.Atoi(v[1]); err != nil {
+		return 0, 0, false, nil
+	}
+	return n, size, true, nil
+}
+
+// expires SETのオプションに有効期限が含まれるか。KEEPTTLの場合はスクリプトで現在の有効期限を確認する
+func expires(opts []interface{}) bool {
+	for _, opt := range opts {
+		if s, ok := opt.(string); ok {
+			switch strings.ToLower(s) {
+			case "ex", "px", "exat", "pxat", "keepttl":
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// chunkCmd SET/SETEX/PSETEXを、値を分割して保存するスクリプトに置き換える。
+// 分割しない値もスクリプトで保存して以前の値の分割キーを削除する。ChunkSizeが0の場合やGETオプションを指定した場合はnil。
+// 分割キーが残らないように、有効期限のない値は分割せずにErrChunkTTLを返す
+func (v *ValueCodec) chunkCmd(ctx context.Context, cmd redis.Cmder, value []byte) (*redis.Cmd, error) {
+	if v.ChunkSize <= 0 {
+		return nil, nil
+	}
+	args := cmd.Args()
+	var opts []interface{}
+	switch cmd.Name() {
+	case "set":
+		if hasGetOption(args) {
+			return nil, nil
+		}
+		opts = args[3:]
+	case "setex":
+		opts = []interface{}{"ex", args[2]}
+	case "psetex":
+		opts = []interface{}{"px", args[2]}
+	default:
+		return nil, nil
+	}
+	key := toString(args[1])
+	n := 0
+	if len(value) > v.ChunkSize {
+		if !expires(opts) {
+			return nil, ErrChunkTTL
+		}
+		n = (len(value) + v.ChunkSize - 1) / v.ChunkSize
+	}
+	keys := append([]string{key}, chunkKeys(key, n)...)
+	evalArgs := make([]interface{}, 0, 3+len(keys)+n+3+len(opts))
+	evalArgs = append(evalArgs, "eval", writeChunks, len(keys))
+	for _, k := range keys {
+		evalArgs = append(evalArgs, k)
+	}
+	if n == 0 {
+		evalArgs = append(evalArgs, value)
+	} else {
+		evalArgs = append(evalArgs, manifest(n, len(value)))
+	}
+	for i := 0; i < n; i++ {
+		end := (i + 1) * v.ChunkSize
+		if end > len(value) {
+			end = len(value)
+		}
+		evalArgs = append(evalArgs, value[i*v.ChunkSize:end])
+	}
+	evalArgs = append(evalArgs, chunkPrefix(key), manifestHeader)
+	evalArgs = append(evalArgs, opts...)
+	return redis.NewCmd(ctx, evalArgs...), nil
+}
+
+// deleteCmd DEL/UNLINKを、分割キーも削除するスクリプトに置き換える。ChunkSizeが0の場合や対象のキーがない場合はnil
+func (v *ValueCodec) deleteCmd(ctx context.Context, cmd redis.Cmder) *redis.Cmd {
+	name := cmd.Name()
+	if v.ChunkSize <= 0 || (name != "del" && name != "unlink") {
+		return nil
+	}
+	keys := cmd.Args()[1:]
+	prefixes := make([]interface{}, 0, len(keys))
+	matched := false
+	for _, k := range keys {
+		key := toString(k)
+		if !v.match(key) {
+			prefixes = append(prefixes, "")
+			continue
+		}
+		prefixes = append(prefixes, chunkPrefix(key))
+		matched = true
+	}
+	if !matched {
+		return nil
+	}
+	evalArgs := make([]interface{}, 0, 5+2*len(keys))
+	evalArgs = append(evalArgs, "eval", deleteChunks, len(keys))
+	evalArgs = append(evalArgs, keys...)
+	evalArgs = append(evalArgs, manifestHeader, name)
+	evalArgs = append(evalArgs, prefixes...)
+	return redis.NewCmd(ctx, evalArgs...)
+}
+
+// setResult 置き換えたスクリプトの結果を元のコマンドに設定する
+func setResult(cmd redis.Cmder, script *redis.Cmd) {
+	err := script.Err()
+	switch c := cmd.(type) {
+	case *redis.BoolCmd:
+		if IsNil(err) {
+			c.SetVal(false)
+			return
+		}
+		c.SetVal(err == nil)
+	case *redis.StatusCmd:
+		c.SetVal("OK")
+	case *redis.IntCmd:
+		n, _ := script.Val().(int64)
+		c.SetVal(n)
+	case *redis.Cmd:
+		c.SetVal(script.Val())
+	}
+	if err != nil {
+		cmd.SetErr(err)
+	}
+}
+
+// readChunks 目録が指す分割キーを元のキーと一緒にMGETで読み込んで結合する。
+// 読み込む間に値が置き換えられた場合は読み直す
+func readChunks(ctx context.Context, c Cmdable, key, m string) (string, error) {
+	ctx = WithoutCodec(ctx)
+	for i := 0; i < 3; i++ {
+		n, size, ok, err := parseManifest(m)
+		if err != nil {
+			return "", err
+		} else if !ok {
+			return m, nil
+		}
+		keys := append([]string{key}, chunkKeys(key, n)...)
+		values, err := c.MGet(ctx, keys...).Result()
+		if err != nil {
+			return "", err
+		}
+		current, ok := values[0].(string)
+		if !ok {
+			return "", Nil
+		}
+		if current != m {
+			m = current
+			continue
+		}
+		var b strings.Builder
+		b.Grow(size)
+		for j, v := range values[1:] {
+			s, ok := v.(string)
+			if !ok {
+				return "", &ChunkError{Key: key, Chunk: j + 1}
+			}
+			b.WriteString(s)
+		}
+		return b.String(), nil
+	}
+	return "", fmt.Errorf("redis: %s changed while reading chunks", key)
+}
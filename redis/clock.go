@@ -0,0 +1,53 @@
+package redis
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Clock 時刻に依存する処理で使用する時計。テストで差し替える
+type Clock interface {
+	Now() time.Time
+	Sleep(d time.Duration)
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (systemClock) Sleep(d time.Duration) {
+	time.Sleep(d)
+}
+
+// clockHolder atomic.Valueには同じ型の値しか保存できないため、Clockを包んで保存する
+type clockHolder struct {
+	Clock
+}
+
+var clock atomic.Value
+
+func init() {
+	clock.Store(clockHolder{systemClock{}})
+}
+
+// SetClock 時計を差し替える。プロセス全体で共有するため、差し替えたテストは並列に実行できない
+func SetClock(c Clock) {
+	if c == nil {
+		c = systemClock{}
+	}
+	clock.Store(clockHolder{c})
+}
+
+func currentClock() Clock {
+	return clock.Load().(clockHolder).Clock
+}
+
+func Now() time.Time {
+	return currentClock().Now()
+}
+
+func Sleep(d time.Duration) {
+	currentClock().Sleep(d)
+}
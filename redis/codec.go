@@ -0,0 +1,458 @@
+package redis
+
+import (
+	"context"
+	"encoding"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// CodecMagic Codecで変換した値の先頭に付ける印。続く1バイトで形式、1バイトで形式の版を表す。
+// MessagePackなどのバイナリの値と区別できるように、UTF-8に現れない0xFFから始まる複数バイトにする
+const CodecMagic = "\xffRV\x00"
+
+// 値の形式
+const (
+	FormatEncryption  byte = 'E'
+	FormatCompression byte = 'Z'
+	FormatChunk       byte = 'C'
+)
+
+// formatVersion 現在の形式の版
+const formatVersion byte = 1
+
+// FormatError 対応していない版の形式
+type FormatError struct {
+	Format  byte
+	Version byte
+}
+
+func (err *FormatError) Error() string {
+	return fmt.Sprintf("redis: unsupported version %d of value format %q", err.Version, err.Format)
+}
+
+func IsFormatError(err error) bool {
+	var e *FormatError
+	return errors.As(err, &e)
+}
+
+// appendHeader 形式のヘッダーを付ける
+func appendHeader(b []byte, format byte) []byte {
+	b = append(b, CodecMagic...)
+	return append(b, format, formatVersion)
+}
+
+// parseHeader 形式のヘッダーを取り除く。ヘッダーがない場合はfalse、対応していない版の場合はFormatError
+func parseHeader(value []byte, format byte) ([]byte, bool, error) {
+	n := len(CodecMagic)
+	if len(value) < n+2 || string(value[:n]) != CodecMagic || value[n] != format {
+		return nil, false, nil
+	}
+	if value[n+1] != formatVersion {
+		return nil, true, &FormatError{Format: format, Version: value[n+1]}
+	}
+	return value[n+2:], true, nil
+}
+
+// Codec 値を保存する形式に変換する。Encodeした値はappendHeaderのヘッダーで形式を識別できる必要がある
+type Codec interface {
+	Encode(value []byte) ([]byte, error)
+	// Decode Encodeした値を元に戻す。このCodecで変換していない値はそのまま返す
+	Decode(value []byte) ([]byte, error)
+}
+
+// ValueCodec 文字列とハッシュの値をCodecで変換して保存し、読み込み時に元に戻す。
+// ChunkSizeを超える文字列の値は複数のキーに分割して保存する。
+// INCRやSETNXのロックなどRedisが値を解釈するキーを変換すると壊れるため、対象のキーはKeysで明示する。
+// 分割キーはDEL/UNLINKやSET/SETEX/PSETEXでの上書き時に削除する。
+// それ以外のコマンド(GETSET、MSET、RENAMEなど)で置き換えた場合も有効期限で削除されるように、有効期限のない値は分割しない
+type ValueCodec struct {
+	Codec     Codec
+	Keys      []string // 対象キーのパターン(Redisのglob形式)。空の場合はどのキーも変換しない。全てのキーを対象にする場合は"*"
+	ChunkSize int      // SETで保存する値を分割する大きさ(バイト)。0の場合は分割しない
+}
+
+var valueCodec *ValueCodec
+
+// SetValueCodec Setup/Refreshで作成するクライアントに値の変換を設定する
+func SetValueCodec(c *ValueCodec) {
+	valueCodec = c
+}
+
+type rawKey struct{}
+
+// WithoutCodec ValueCodecを適用せずに保存されている値をそのまま読み書きする
+func WithoutCodec(ctx context.Context) context.Context {
+	return context.WithValue(ctx, rawKey{}, true)
+}
+
+func isRaw(ctx context.Context) bool {
+	return ctx.Value(rawKey{}) != nil
+}
+
+func (v *ValueCodec) install(primary *PrimaryClient, reader *ReaderClient) {
+	primary.Client.AddHook(&codecHook{codec: v, client: primary.Client})
+	if reader.Client == Cmdable(primary.Client) {
+		return
+	}
+	if c, ok := reader.Client.(interface{ AddHook(redis.Hook) }); ok {
+		c.AddHook(&codecHook{codec: v, client: reader.Client})
+	}
+}
+
+func (v *ValueCodec) codec() Codec {
+	if v.Codec == nil {
+		return Codecs{}
+	}
+	return v.Codec
+}
+
+func (v *ValueCodec) match(key string) bool {
+	for _, p := range v.Keys {
+		if MatchKey(p, key) {
+			return true
+		}
+	}
+	return false
+}
+
+// encode 書き込むコマンドの値を変換する。値を分割する場合は代わりに実行するコマンドを返す。
+// 引数はコマンドの実行中だけ置き換えるため、戻り値のrestoreで元に戻す。
+// フェイルオーバーで同じコマンドを再実行した場合に、変換済みの値を再度変換しないようにする
+func (v *ValueCodec) encode(ctx context.Context, cmd redis.Cmder) (script *redis.Cmd, restore func(), err error) {
+	if c := v.deleteCmd(ctx, cmd); c != nil {
+		return c, func() {}, nil
+	}
+	args := cmd.Args()
+	var original map[int]interface{}
+	restore = func() {
+		for i, arg := range original {
+			args[i] = arg
+		}
+	}
+	for _, i := range valueIndexes(cmd.Name(), args) {
+		if !v.match(keyOf(cmd.Name(), args, i)) {
+			continue
+		}
+		b, err := argBytes(args[i])
+		if err != nil {
+			restore()
+			return nil, nil, err
+		}
+		if b, err = v.codec().Encode(b); err != nil {
+			restore()
+			return nil, nil, err
+		}
+		c, err := v.chunkCmd(ctx, cmd, b)
+		if err != nil || c != nil {
+			restore()
+			return c, func() {}, err
+		}
+		if original == nil {
+			original = make(map[int]interface{})
+		}
+		original[i] = args[i]
+		args[i] = b
+	}
+	return nil, restore, nil
+}
+
+// keyOf i番目の値を保存するキー
+func keyOf(name string, args []interface{}, i int) string {
+	switch name {
+	case "mset", "msetnx":
+		return toString(args[i-1])
+	}
+	return toString(args[1])
+}
+
+// valueIndexes コマンドの引数のうち値の位置
+func valueIndexes(name string, args []interface{}) []int {
+	switch name {
+	case "set", "setnx", "getset":
+		if len(args) > 2 {
+			return []int{2}
+		}
+	case "setex", "psetex", "hsetnx":
+		if len(args) > 3 {
+			return []int{3}
+		}
+	case "mset", "msetnx":
+		return pairs(args, 2)
+	case "hset", "hmset":
+		return pairs(args, 3)
+	}
+	return nil
+}
+
+func pairs(args []interface{}, start int) []int {
+	var result []int
+	for i := start; i < len(args); i += 2 {
+		result = append(result, i)
+	}
+	return result
+}
+
+// decode 読み込んだ値を元に戻す。分割した値はcから読み込む
+func (v *ValueCodec) decode(ctx context.Context, c Cmdable, cmd redis.Cmder) error {
+	if cmd.Err() != nil {
+		return nil
+	}
+	name, args := cmd.Name(), cmd.Args()
+	switch name {
+	case "get", "getdel", "getex", "getset", "set", "hget":
+		if !v.match(toString(args[1])) {
+			return nil
+		}
+		return v.decodeValue(ctx, c, toString(args[1]), cmd)
+	case "mget":
+		mget, ok := cmd.(*redis.SliceCmd)
+		if !ok {
+			return nil
+		}
+		values := mget.Val()
+		for i, value := range values {
+			key := toString(args[i+1])
+			if s, ok := value.(string); ok && v.match(key) {
+				s, err := v.decodeString(ctx, c, key, s)
+				if IsNil(err) {
+					values[i] = nil
+					continue
+				} else if err != nil {
+					return err
+				}
+				values[i] = s
+			}
+		}
+	case "hmget", "hgetall", "hvals":
+		if !v.match(toString(args[1])) {
+			return nil
+		}
+		return v.decodeValues(cmd)
+	}
+	return nil
+}
+
+// decodeString 分割した値であれば結合してからCodecで元に戻す
+func (v *ValueCodec) decodeString(ctx context.Context, c Cmdable, key, s string) (string, error) {
+	if strings.HasPrefix(s, manifestHeader) {
+		var err error
+		if s, err = readChunks(ctx, c, key, s); err != nil {
+			return "", err
+		}
+	}
+	return v.decodeField(s)
+}
+
+func (v *ValueCodec) decodeField(s string) (string, error) {
+	b, err := v.codec().Decode([]byte(s))
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+func (v *ValueCodec) decodeValue(ctx context.Context, client Cmdable, key string, cmd redis.Cmder) error {
+	switch c := cmd.(type) {
+	case *redis.StringCmd:
+		s, err := v.decodeString(ctx, client, key, c.Val())
+		if err != nil {
+			return err
+		}
+		c.SetVal(s)
+	case *redis.StatusCmd:
+		if hasGetOption(c.Args()) {
+			s, err := v.decodeString(ctx, client, key, c.Val())
+			if err != nil {
+				return err
+			}
+			c.SetVal(s)
+		}
+	case *redis.Cmd:
+		if s, ok := c.Val().(string); ok {
+			s, err := v.decodeString(ctx, client, key, s)
+			if err != nil {
+				return err
+			}
+			c.SetVal(s)
+		}
+	}
+	return nil
+}
+
+// hasGetOption SET key value ... GET
+func hasGetOption(args []interface{}) bool {
+	if len(args) < 4 {
+		return false
+	}
+	for _, arg := range args[3:] {
+		if s, ok := arg.(string); ok && strings.EqualFold(s, "get") {
+			return true
+		}
+	}
+	return false
+}
+
+func (v *ValueCodec) decodeValues(cmd redis.Cmder) error {
+	var err error
+	switch c := cmd.(type) {
+	case *redis.SliceCmd:
+		for i, value := range c.Val() {
+			if s, ok := value.(string); ok {
+				if c.Val()[i], err = v.decodeField(s); err != nil {
+					return err
+				}
+			}
+		}
+	case *redis.StringSliceCmd:
+		for i, s := range c.Val() {
+			if c.Val()[i], err = v.decodeField(s); err != nil {
+				return err
+			}
+		}
+	case *redis.MapStringStringCmd:
+		for k, s := range c.Val() {
+			if c.Val()[k], err = v.decodeField(s); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// argBytes go-redisと同じ規則で引数をバイト列にする
+func argBytes(v interface{}) ([]byte, error) {
+	switch s := v.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		return []byte(s), nil
+	case []byte:
+		return s, nil
+	case int:
+		return strconv.AppendInt(nil, int64(s), 10), nil
+	case int8:
+		return strconv.AppendInt(nil, int64(s), 10), nil
+	case int16:
+		return strconv.AppendInt(nil, int64(s), 10), nil
+	case int32:
+		return strconv.AppendInt(nil, int64(s), 10), nil
+	case int64:
+		return strconv.AppendInt(nil, s, 10), nil
+	case uint:
+		return strconv.AppendUint(nil, uint64(s), 10), nil
+	case uint8:
+		return strconv.AppendUint(nil, uint64(s), 10), nil
+	case uint16:
+		return strconv.AppendUint(nil, uint64(s), 10), nil
+	case uint32:
+		return strconv.AppendUint(nil, uint64(s), 10), nil
+	case uint64:
+		return strconv.AppendUint(nil, s, 10), nil
+	case float32:
+		return strconv.AppendFloat(nil, float64(s), 'f', -1, 64), nil
+	case float64:
+		return strconv.AppendFloat(nil, s, 'f', -1, 64), nil
+	case bool:
+		if s {
+			return []byte("1"), nil
+		}
+		return []byte("0"), nil
+	case time.Time:
+		return s.AppendFormat(nil, time.RFC3339Nano), nil
+	case time.Duration:
+		return strconv.AppendInt(nil, s.Nanoseconds(), 10), nil
+	case encoding.BinaryMarshaler:
+		return s.MarshalBinary()
+	}
+	return nil, fmt.Errorf("redis: can't marshal %T (implement encoding.BinaryMarshaler)", v)
+}
+
+type codecHook struct {
+	codec  *ValueCodec
+	client Cmdable
+}
+
+func (h *codecHook) DialHook(next redis.DialHook) redis.DialHook {
+	return next
+}
+
+func (h *codecHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		if isRaw(ctx) {
+			return next(ctx, cmd)
+		}
+		script, restore, err := h.codec.encode(ctx, cmd)
+		if err != nil {
+			cmd.SetErr(err)
+			return err
+		}
+		defer restore()
+		if script != nil {
+			// go-redisはフックの外側でエラーを設定するため、ここで設定する
+			if err = next(ctx, script); err != nil {
+				script.SetErr(err)
+			}
+			setResult(cmd, script)
+			return cmd.Err()
+		}
+		if err = next(ctx, cmd); err != nil {
+			return err
+		}
+		if err = h.codec.decode(ctx, h.client, cmd); err != nil {
+			cmd.SetErr(err)
+			return err
+		}
+		return nil
+	}
+}
+
+func (h *codecHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		if isRaw(ctx) {
+			return next(ctx, cmds)
+		}
+		var scripts map[int]*redis.Cmd
+		for i, cmd := range cmds {
+			script, restore, err := h.codec.encode(ctx, cmd)
+			if err != nil {
+				cmd.SetErr(err)
+				return err
+			}
+			defer restore()
+			if script != nil {
+				if scripts == nil {
+					scripts = make(map[int]*redis.Cmd)
+				}
+				scripts[i] = script
+			}
+		}
+		exec := cmds
+		if scripts != nil {
+			exec = make([]redis.Cmder, len(cmds))
+			copy(exec, cmds)
+			for i, script := range scripts {
+				exec[i] = script
+			}
+		}
+		err := next(ctx, exec)
+		for i, cmd := range cmds {
+			if script, ok := scripts[i]; ok {
+				setResult(cmd, script)
+				continue
+			}
+			if e := h.codec.decode(ctx, h.client, cmd); e != nil {
+				cmd.SetErr(e)
+				if err == nil {
+					err = e
+				}
+			}
+		}
+		return err
+	}
+}
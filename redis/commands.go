@@ -0,0 +1,234 @@
+package redis
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/redis/go-redis/v9"
+)
+
+type Cmder = redis.Cmder
+
+// CommandKeys コマンドの対象キーを返す。キーの位置はCOMMAND INFOのキー仕様に合わせる
+func CommandKeys(cmd redis.Cmder) []string {
+	args := cmd.Args()
+	if len(args) < 2 {
+		return nil
+	}
+	name := strings.ToLower(cmd.Name())
+	if _, ok := keylessCommands[name]; ok {
+		return nil
+	}
+	if pos, ok := numKeysCommands[name]; ok {
+		if len(args) <= pos {
+			return nil
+		}
+		n, err := strconv.Atoi(toString(args[pos]))
+		if err != nil || n < 0 || len(args) < pos+1+n {
+			return nil
+		}
+		keys := toStrings(args[pos+1 : pos+1+n])
+		switch name {
+		case "zunionstore", "zinterstore", "zdiffstore":
+			return append([]string{toString(args[1])}, keys...)
+		}
+		return keys
+	}
+	switch name {
+	case "xread", "xreadgroup":
+		for i := 1; i < len(args); i++ {
+			if strings.EqualFold(toString(args[i]), "streams") {
+				streams := args[i+1:]
+				return toStrings(streams[:len(streams)/2])
+			}
+		}
+		return nil
+	case "object":
+		if len(args) < 3 {
+			return nil
+		}
+		return []string{toString(args[2])}
+	case "memory":
+		if len(args) < 3 || !strings.EqualFold(toString(args[1]), "usage") {
+			return nil
+		}
+		return []string{toString(args[2])}
+	}
+	if spec, ok := keySpecs[name]; ok {
+		return spec.keys(args)
+	}
+	return []string{toString(args[1])}
+}
+
+// keySpec キーの位置。COMMAND INFOのfirst key, last key, stepと同じで、lastが負の場合は末尾から数える
+type keySpec struct {
+	first, last, step int
+}
+
+func (s keySpec) keys(args []interface{}) []string {
+	last := s.last
+	if last < 0 {
+		last += len(args)
+	}
+	if last >= len(args) {
+		last = len(args) - 1
+	}
+	var keys []string
+	for i := s.first; i <= last; i += s.step {
+		keys = append(keys, toString(args[i]))
+	}
+	return keys
+}
+
+// keySpecs 最初の引数だけがキーではないコマンド
+var keySpecs = map[string]keySpec{
+	"del": {1, -1, 1}, "unlink": {1, -1, 1}, "exists": {1, -1, 1}, "touch": {1, -1, 1}, "watch": {1, -1, 1},
+	"mget": {1, -1, 1}, "mset": {1, -1, 2}, "msetnx": {1, -1, 2},
+	"sunion": {1, -1, 1}, "sinter": {1, -1, 1}, "sdiff": {1, -1, 1},
+	"sunionstore": {1, -1, 1}, "sinterstore": {1, -1, 1}, "sdiffstore": {1, -1, 1},
+	"pfcount": {1, -1, 1}, "pfmerge": {1, -1, 1},
+	"rename": {1, 2, 1}, "renamenx": {1, 2, 1}, "copy": {1, 2, 1}, "smove": {1, 2, 1},
+	"rpoplpush": {1, 2, 1}, "lmove": {1, 2, 1}, "brpoplpush": {1, 2, 1}, "blmove": {1, 2, 1},
+	"zrangestore": {1, 2, 1}, "geosearchstore": {1, 2, 1},
+	"blpop": {1, -2, 1}, "brpop": {1, -2, 1}, "bzpopmin": {1, -2, 1}, "bzpopmax": {1, -2, 1},
+	"bitop": {2, -1, 1},
+}
+
+// numKeysCommands キーの数を引数で指定するコマンドと、その引数の位置
+var numKeysCommands = map[string]int{
+	"eval": 2, "evalsha": 2, "eval_ro": 2, "evalsha_ro": 2, "fcall": 2, "fcall_ro": 2,
+	"zunionstore": 2, "zinterstore": 2, "zdiffstore": 2,
+	"zunion": 1, "zinter": 1, "zdiff": 1, "zintercard": 1, "sintercard": 1, "lmpop": 1, "zmpop": 1,
+	"blmpop": 2, "bzmpop": 2,
+}
+
+// keylessCommands キーを指定しないコマンド
+var keylessCommands = map[string]struct{}{
+	"select": {}, "swapdb": {}, "auth": {}, "hello": {}, "ping": {}, "echo": {}, "quit": {}, "reset": {},
+	"info": {}, "time": {}, "dbsize": {}, "randomkey": {}, "keys": {}, "scan": {}, "flushdb": {}, "flushall": {},
+	"multi": {}, "exec": {}, "discard": {}, "unwatch": {}, "wait": {}, "readonly": {}, "readwrite": {},
+	"client": {}, "config": {}, "cluster": {}, "command": {}, "script": {}, "function": {}, "acl": {},
+	"publish": {}, "spublish": {}, "subscribe": {}, "ssubscribe": {}, "psubscribe": {}, "unsubscribe": {},
+	"sunsubscribe": {}, "punsubscribe": {}, "pubsub": {},
+	"save": {}, "bgsave": {}, "bgrewriteaof": {}, "lastsave": {}, "slowlog": {}, "latency": {}, "debug": {},
+	"monitor": {}, "role": {}, "replicaof": {}, "slaveof": {}, "shutdown": {}, "failover": {}, "module": {}, "lolwut": {},
+}
+
+func toStrings(args []interface{}) []string {
+	result := make([]string, 0, len(args))
+	for _, v := range args {
+		result = append(result, toString(v))
+	}
+	return result
+}
+
+func toString(v interface{}) string {
+	switch s := v.(type) {
+	case string:
+		return s
+	case []byte:
+		return string(s)
+	case int:
+		return strconv.Itoa(s)
+	case int64:
+		return strconv.FormatInt(s, 10)
+	}
+	return ""
+}
+
+// MatchKey KEYSやSCANと同じglob形式でキーを照合する。path.Matchと異なり*は/にも一致する
+func MatchKey(pattern, key string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for len(pattern) > 1 && pattern[1] == '*' {
+				pattern = pattern[1:]
+			}
+			if len(pattern) == 1 {
+				return true
+			}
+			for i := 0; i <= len(key); i++ {
+				if MatchKey(pattern[1:], key[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if len(key) == 0 {
+				return false
+			}
+			key = key[1:]
+			pattern = pattern[1:]
+		case '[':
+			if len(key) == 0 {
+				return false
+			}
+			rest, ok := matchClass(pattern[1:], key[0])
+			if !ok {
+				return false
+			}
+			key = key[1:]
+			pattern = rest
+		default:
+			if pattern[0] == '\\' && len(pattern) > 1 {
+				pattern = pattern[1:]
+			}
+			if len(key) == 0 || key[0] != pattern[0] {
+				return false
+			}
+			key = key[1:]
+			pattern = pattern[1:]
+		}
+	}
+	return len(key) == 0
+}
+
+// matchClass [...]にcが含まれるかを調べ、]の後のパターンを返す
+func matchClass(pattern string, c byte) (string, bool) {
+	not := len(pattern) > 0 && pattern[0] == '^'
+	if not {
+		pattern = pattern[1:]
+	}
+	match := false
+	for len(pattern) > 0 && pattern[0] != ']' {
+		switch {
+		case pattern[0] == '\\' && len(pattern) > 1:
+			match = match || pattern[1] == c
+			pattern = pattern[2:]
+		case len(pattern) > 2 && pattern[1] == '-' && pattern[2] != ']':
+			lo, hi := pattern[0], pattern[2]
+			if lo > hi {
+				lo, hi = hi, lo
+			}
+			match = match || (lo <= c && c <= hi)
+			pattern = pattern[3:]
+		default:
+			match = match || pattern[0] == c
+			pattern = pattern[1:]
+		}
+	}
+	if len(pattern) > 0 { // ]
+		pattern = pattern[1:]
+	}
+	return pattern, match != not
+}
+
+// readCommands サーバーの状態を変更しないコマンド。失敗しても再実行できる
+var readCommands = map[string]struct{}{
+	"get": {}, "mget": {}, "strlen": {}, "getrange": {}, "getbit": {}, "bitcount": {}, "bitpos": {},
+	"exists": {}, "type": {}, "ttl": {}, "pttl": {}, "expiretime": {}, "pexpiretime": {},
+	"hget": {}, "hmget": {}, "hgetall": {}, "hkeys": {}, "hvals": {}, "hlen": {}, "hexists": {}, "hstrlen": {}, "hrandfield": {},
+	"llen": {}, "lindex": {}, "lrange": {}, "lpos": {},
+	"scard": {}, "sismember": {}, "smismember": {}, "smembers": {}, "srandmember": {}, "sunion": {}, "sinter": {}, "sdiff": {},
+	"zcard": {}, "zcount": {}, "zscore": {}, "zmscore": {}, "zrank": {}, "zrevrank": {}, "zrange": {}, "zrevrange": {},
+	"zrangebyscore": {}, "zrevrangebyscore": {}, "zrangebylex": {}, "zrevrangebylex": {}, "zlexcount": {},
+	"pfcount": {}, "xlen": {}, "xrange": {}, "xrevrange": {}, "geopos": {}, "geodist": {}, "geosearch": {},
+	"scan": {}, "hscan": {}, "sscan": {}, "zscan": {}, "keys": {}, "dbsize": {}, "randomkey": {},
+	"ping": {}, "echo": {}, "time": {}, "info": {},
+	"eval_ro": {}, "evalsha_ro": {}, "fcall_ro": {},
+}
+
+func isReadCommand(name string) bool {
+	_, ok := readCommands[strings.ToLower(name)]
+	return ok
+}
@@ -0,0 +1,60 @@
+package redis
+
+import (
+	"context"
+	"testing"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCommandKeys(t *testing.T) {
+	ctx := context.Background()
+	for _, c := range []struct {
+		args []interface{}
+		keys []string
+	}{
+		{[]interface{}{"get", "a"}, []string{"a"}},
+		{[]interface{}{"select", 1}, nil},
+		{[]interface{}{"ping"}, nil},
+		{[]interface{}{"publish", "channel", "message"}, nil},
+		{[]interface{}{"mset", "a", "1", "b", "2"}, []string{"a", "b"}},
+		{[]interface{}{"del", "a", "b"}, []string{"a", "b"}},
+		{[]interface{}{"blpop", "a", "b", 0}, []string{"a", "b"}},
+		{[]interface{}{"bitop", "and", "dest", "a", "b"}, []string{"dest", "a", "b"}},
+		{[]interface{}{"rename", "a", "b"}, []string{"a", "b"}},
+		{[]interface{}{"eval", "return 1", 2, "a", "b", "arg"}, []string{"a", "b"}},
+		{[]interface{}{"evalsha", "sha", 0, "arg"}, []string{}},
+		{[]interface{}{"zunionstore", "dest", 2, "a", "b", "weights", 1, 2}, []string{"dest", "a", "b"}},
+		{[]interface{}{"zinterstore", "dest", 1, "a", "aggregate", "max"}, []string{"dest", "a"}},
+		{[]interface{}{"zunion", 2, "a", "b", "withscores"}, []string{"a", "b"}},
+		{[]interface{}{"xread", "count", 1, "streams", "a", "b", "0", "0"}, []string{"a", "b"}},
+		{[]interface{}{"object", "encoding", "a"}, []string{"a"}},
+	} {
+		cmd := redis.NewCmd(ctx, c.args...)
+		assert.Equal(t, c.keys, CommandKeys(cmd), "%v", c.args)
+	}
+}
+
+func TestMatchKey(t *testing.T) {
+	for _, c := range []struct {
+		pattern string
+		key     string
+		match   bool
+	}{
+		{"idempotency://*", "idempotency://user_001/key_001", true},
+		{"counter://{x}/*", "counter://{x}/3", true},
+		{"counter://{x}/?", "counter://{x}/12", false},
+		{"*", "", true},
+		{"h?llo", "hello", true},
+		{"h[ae]llo", "hallo", true},
+		{"h[^e]llo", "hello", false},
+		{"h[a-b]llo", "hbllo", true},
+		{"h\\*llo", "h*llo", true},
+		{"h\\*llo", "hello", false},
+		{"session://*/data", "session://a/b/data", true},
+		{"session://*/data", "session://a/b/meta", false},
+	} {
+		assert.Equal(t, c.match, MatchKey(c.pattern, c.key), "%s %s", c.pattern, c.key)
+	}
+}
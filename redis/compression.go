@@ -0,0 +1,199 @@
+package redis
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/goccha/logging/log"
+	"github.com/klauspost/compress/s2"
+	"github.com/klauspost/compress/zstd"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// Algorithm 圧縮形式。値は圧縮した値のヘッダーの後の1バイトに使う
+type Algorithm byte
+
+const (
+	Zstd   Algorithm = 1
+	Gzip   Algorithm = 2
+	Snappy Algorithm = 3
+)
+
+// uncompressed 圧縮しなかった値
+const uncompressed Algorithm = 0
+
+func (a Algorithm) String() string {
+	switch a {
+	case uncompressed:
+		return "none"
+	case Zstd:
+		return "zstd"
+	case Gzip:
+		return "gzip"
+	case Snappy:
+		return "snappy"
+	}
+	return fmt.Sprintf("unknown(%#x)", byte(a))
+}
+
+var (
+	zstdEncoder, _ = zstd.NewWriter(nil)
+	zstdDecoder, _ = zstd.NewReader(nil)
+)
+
+// Compression Threshold以上の値を圧縮するCodec。
+// 形式は CodecMagic | FormatCompression | 版(1) | Algorithm(1) | 値。
+// 圧縮しない値や圧縮しても小さくならない値も同じ形式で保存し、元の値の先頭がヘッダーと同じでも区別できるようにする。
+// 読み込み時はAlgorithmに関係なくヘッダーで形式を判別して展開する
+type Compression struct {
+	Algorithm Algorithm // 既定値はZstd
+	Threshold int       // 圧縮する最小の大きさ(バイト)。既定値は1024
+	once      sync.Once
+	ratio     metric.Float64Histogram
+	original  metric.Int64Counter
+	stored    metric.Int64Counter
+}
+
+func (c *Compression) algorithm() Algorithm {
+	if c.Algorithm == 0 {
+		return Zstd
+	}
+	return c.Algorithm
+}
+
+func (c *Compression) threshold() int {
+	if c.Threshold <= 0 {
+		return 1024
+	}
+	return c.Threshold
+}
+
+func (c *Compression) init() {
+	meter := otel.Meter(instrumentationName)
+	var err error
+	if c.ratio, err = meter.Float64Histogram("redis.codec.compression_ratio",
+		metric.WithDescription("The ratio of compressed size to original size of values written through the compression codec")); err != nil {
+		log.Error(context.Background()).Err(err).Send()
+	}
+	if c.original, err = meter.Int64Counter("redis.codec.original_bytes", metric.WithUnit("By"),
+		metric.WithDescription("The number of bytes passed to the compression codec")); err != nil {
+		log.Error(context.Background()).Err(err).Send()
+	}
+	if c.stored, err = meter.Int64Counter("redis.codec.compressed_bytes", metric.WithUnit("By"),
+		metric.WithDescription("The number of bytes produced by the compression codec")); err != nil {
+		log.Error(context.Background()).Err(err).Send()
+	}
+}
+
+func (c *Compression) Encode(value []byte) ([]byte, error) {
+	if len(value) < c.threshold() {
+		return frame(uncompressed, value), nil
+	}
+	a := c.algorithm()
+	var buf bytes.Buffer
+	buf.Grow(len(value)/2 + len(CodecMagic) + 3)
+	buf.Write(appendHeader(make([]byte, 0, len(CodecMagic)+3), FormatCompression))
+	buf.WriteByte(byte(a))
+	switch a {
+	case Zstd:
+		buf.Write(zstdEncoder.EncodeAll(value, nil))
+	case Gzip:
+		w := gzip.NewWriter(&buf)
+		if _, err := w.Write(value); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+	case Snappy:
+		buf.Write(s2.EncodeSnappy(nil, value))
+	default:
+		return nil, fmt.Errorf("redis: unsupported compression %s", a)
+	}
+	c.record(a, len(value), buf.Len())
+	if buf.Len() >= len(value)+len(CodecMagic)+3 {
+		return frame(uncompressed, value), nil
+	}
+	return buf.Bytes(), nil
+}
+
+func frame(a Algorithm, value []byte) []byte {
+	out := make([]byte, 0, len(CodecMagic)+3+len(value))
+	out = appendHeader(out, FormatCompression)
+	out = append(out, byte(a))
+	return append(out, value...)
+}
+
+func (c *Compression) record(a Algorithm, before, after int) {
+	c.once.Do(c.init)
+	ctx := context.Background()
+	attrs := metric.WithAttributes(attribute.String("algorithm", a.String()))
+	if c.ratio != nil {
+		c.ratio.Record(ctx, float64(after)/float64(before), attrs)
+	}
+	if c.original != nil {
+		c.original.Add(ctx, int64(before), attrs)
+	}
+	if c.stored != nil {
+		c.stored.Add(ctx, int64(after), attrs)
+	}
+}
+
+func (c *Compression) Decode(value []byte) ([]byte, error) {
+	body, ok, err := parseHeader(value, FormatCompression)
+	if err != nil {
+		return nil, err
+	} else if !ok {
+		return value, nil
+	} else if len(body) == 0 {
+		return nil, errors.New("redis: compressed value too short")
+	}
+	a := Algorithm(body[0])
+	body = body[1:]
+	switch a {
+	case uncompressed:
+		return body, nil
+	case Zstd:
+		return zstdDecoder.DecodeAll(body, nil)
+	case Gzip:
+		r, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		return io.ReadAll(r)
+	case Snappy:
+		return s2.Decode(nil, body)
+	}
+	return nil, fmt.Errorf("redis: unsupported compression %s", a)
+}
+
+// Codecs 順番にEncodeし、逆順にDecodeする。圧縮してから暗号化する場合は Codecs{compression, encryption}
+type Codecs []Codec
+
+func (c Codecs) Encode(value []byte) ([]byte, error) {
+	var err error
+	for _, codec := range c {
+		if value, err = codec.Encode(value); err != nil {
+			return nil, err
+		}
+	}
+	return value, nil
+}
+
+func (c Codecs) Decode(value []byte) ([]byte, error) {
+	var err error
+	for i := len(c) - 1; i >= 0; i-- {
+		if value, err = c[i].Decode(value); err != nil {
+			return nil, err
+		}
+	}
+	return value, nil
+}
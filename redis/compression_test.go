@@ -0,0 +1,169 @@
+package redis
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompression(t *testing.T) {
+	value := []byte(strings.Repeat("redis-verse ", 1000))
+	for _, a := range []Algorithm{Zstd, Gzip, Snappy} {
+		c := &Compression{Algorithm: a}
+		encoded, err := c.Encode(value)
+		assert.Nil(t, err)
+		assert.True(t, strings.HasPrefix(string(encoded), CodecMagic+"Z\x01"+string([]byte{byte(a)})), a.String())
+		assert.Less(t, len(encoded), len(value)/4, a.String())
+		// 読み込み側の設定に関係なく展開できる
+		decoded, err := (&Compression{}).Decode(encoded)
+		assert.Nil(t, err)
+		assert.Equal(t, value, decoded, a.String())
+	}
+
+	c := &Compression{Threshold: 100}
+	small := []byte("small")
+	encoded, err := c.Encode(small)
+	assert.Nil(t, err)
+	assert.True(t, strings.HasPrefix(string(encoded), CodecMagic+"Z"))
+	decoded, err := c.Decode(encoded)
+	assert.Nil(t, err)
+	assert.Equal(t, small, decoded)
+	decoded, err = c.Decode(small)
+	assert.Nil(t, err)
+	assert.Equal(t, small, decoded)
+	// 圧縮しない値も形式を付けて保存するため、ヘッダーと同じ先頭の値やバイナリの値もそのまま戻る
+	for _, raw := range [][]byte{[]byte(CodecMagic + "Z\x01\x01"), {0x82, 0xa1, 'a', 0x01, 0xa1, 'b', 0x02}} {
+		encoded, err = c.Encode(raw)
+		assert.Nil(t, err)
+		decoded, err = c.Decode(encoded)
+		assert.Nil(t, err)
+		assert.Equal(t, raw, decoded)
+	}
+	decoded, err = c.Decode([]byte{0x82, 0xa1, 'a', 0x01, 0xa1, 'b', 0x02})
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{0x82, 0xa1, 'a', 0x01, 0xa1, 'b', 0x02}, decoded)
+	_, err = c.Decode([]byte(CodecMagic + "Z\x02\x01"))
+	assert.True(t, IsFormatError(err))
+
+	k, _ := NewKeyring("v1", bytes.Repeat([]byte{1}, 32))
+	codecs := Codecs{c, NewEncryption(k)}
+	encoded, err = codecs.Encode(value)
+	assert.Nil(t, err)
+	assert.True(t, strings.HasPrefix(string(encoded), CodecMagic+"E"))
+	assert.Less(t, len(encoded), len(value)/4)
+	decoded, err = codecs.Decode(encoded)
+	assert.Nil(t, err)
+	assert.Equal(t, value, decoded)
+}
+
+func TestValueCodec_Chunk(t *testing.T) {
+	ctx := context.Background()
+	SetValueCodec(&ValueCodec{ChunkSize: 10, Keys: []string{"large://*"}})
+	defer SetValueCodec(nil)
+	b := &MemoryBuilder{}
+	defer b.Close()
+	assert.Nil(t, Setup(ctx, b))
+
+	value := "0123456789abcdefghijklmnopqrstuvwxyz"
+	assert.Nil(t, Primary().Set(ctx, "large://a", value, time.Minute).Err())
+	raw, _ := b.Server().Get("large://a")
+	assert.True(t, strings.HasPrefix(raw, manifestHeader))
+	chunk, _ := b.Server().Get("{large://a}/chunk/4")
+	assert.Equal(t, "uvwxyz", chunk)
+	assert.True(t, b.Server().TTL("{large://a}/chunk/1") > 0)
+
+	v, err := Reader().Get(ctx, "large://a").Result()
+	assert.Nil(t, err)
+	assert.Equal(t, value, v)
+
+	ok, err := Primary().SetNX(ctx, "large://a", value+value, time.Minute).Result()
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	// 分割数が減った場合は余分な分割キーを削除する
+	assert.Nil(t, Primary().Set(ctx, "large://a", value[:15], time.Hour).Err())
+	assert.False(t, b.Server().Exists("{large://a}/chunk/3"))
+	assert.Equal(t, time.Hour, b.Server().TTL("{large://a}/chunk/1"))
+
+	cmds, err := Primary().TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(ctx, "large://{tag}b", value, time.Minute)
+		pipe.Set(ctx, "large://small", "1", 0)
+		pipe.Get(ctx, "large://a")
+		return nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, "OK", cmds[0].(*redis.StatusCmd).Val())
+	assert.Equal(t, value[:15], cmds[2].(*redis.StringCmd).Val())
+	assert.True(t, b.Server().Exists("large://{tag}b/chunk/1"))
+
+	values, err := Primary().MGet(ctx, "large://a", "large://{tag}b", "large://small", "large://missing").Result()
+	assert.Nil(t, err)
+	assert.Equal(t, []interface{}{value[:15], value, "1", nil}, values)
+
+	b.Server().Del("{large://a}/chunk/2")
+	err = Primary().Get(ctx, "large://a").Err()
+	assert.True(t, IsChunkError(err))
+
+	// 有効期限のない値は分割キーが残らないように分割しない
+	err = Primary().Set(ctx, "large://persistent", value, 0).Err()
+	assert.ErrorIs(t, err, ErrChunkTTL)
+	assert.False(t, b.Server().Exists("large://persistent"))
+	assert.Nil(t, Primary().Set(ctx, "large://persistent", "small", 0).Err())
+	err = Primary().SetArgs(ctx, "large://persistent", value, redis.SetArgs{KeepTTL: true}).Err()
+	assert.NotNil(t, err)
+	v, _ = Primary().Get(ctx, "large://persistent").Result()
+	assert.Equal(t, "small", v)
+
+	// 分割しない値で上書きした場合やDEL/UNLINKの場合は分割キーも削除する
+	assert.Nil(t, Primary().SetEx(ctx, "large://c", value, time.Minute).Err())
+	assert.True(t, b.Server().Exists("{large://c}/chunk/4"))
+	assert.Nil(t, Primary().Set(ctx, "large://c", "small", 0).Err())
+	assert.False(t, b.Server().Exists("{large://c}/chunk/1"))
+	v, _ = Primary().Get(ctx, "large://c").Result()
+	assert.Equal(t, "small", v)
+
+	assert.Nil(t, Primary().Set(ctx, "large://a", value, time.Minute).Err())
+	n, err := Primary().Del(ctx, "large://a", "large://c", "large://missing").Result()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(2), n)
+	assert.False(t, b.Server().Exists("{large://a}/chunk/1"))
+	n, err = Primary().Unlink(ctx, "large://{tag}b").Result()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), n)
+	assert.False(t, b.Server().Exists("large://{tag}b/chunk/1"))
+}
+
+func TestValueCodec_CompressAndChunk(t *testing.T) {
+	ctx := context.Background()
+	SetValueCodec(&ValueCodec{Codec: &Compression{Algorithm: Snappy, Threshold: 64}, ChunkSize: 128, Keys: []string{"payload://*"}})
+	defer SetValueCodec(nil)
+	b := &MemoryBuilder{}
+	defer b.Close()
+	assert.Nil(t, Setup(ctx, b))
+
+	compressible := strings.Repeat("a", 1000)
+	assert.Nil(t, Primary().Set(ctx, "payload://compressible", compressible, 0).Err())
+	raw, _ := b.Server().Get("payload://compressible")
+	assert.True(t, strings.HasPrefix(raw, CodecMagic+"Z\x01"+string([]byte{byte(Snappy)})))
+
+	var random bytes.Buffer
+	seed := uint32(1)
+	for i := 0; i < 1000; i++ {
+		seed = seed*1664525 + 1013904223
+		random.WriteByte(byte(seed >> 24))
+	}
+	assert.Nil(t, Primary().Set(ctx, "payload://random", random.Bytes(), time.Minute).Err())
+	raw, _ = b.Server().Get("payload://random")
+	assert.True(t, strings.HasPrefix(raw, manifestHeader))
+
+	for key, expected := range map[string]string{"payload://compressible": compressible, "payload://random": random.String()} {
+		v, err := Primary().Get(ctx, key).Result()
+		assert.Nil(t, err)
+		assert.Equal(t, expected, v, key)
+	}
+}
@@ -0,0 +1,484 @@
+package redis
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const DefaultConnection = "default"
+
+const (
+	ModeStandalone = "standalone"
+	ModeCluster    = "cluster"
+	ModeSentinel   = "sentinel"
+)
+
+// Config 設定ファイルの内容。文字列の項目の${VAR}と${VAR:-default}は解析後に環境変数で置き換える。
+// 既定値のない変数が未設定の接続はConnectionでエラーになる
+//
+//	default_profile: dev
+//	profiles:
+//	  dev:
+//	    default:
+//	      primary: localhost:6379
+//	  prod:
+//	    default:
+//	      mode: cluster
+//	      primary: ${REDIS_PRIMARY_ENDPOINT}
+//	      password: ${REDIS_PASSWORD}
+//	      tls:
+//	        enable: true
+type Config struct {
+	DefaultProfile string                            `yaml:"default_profile" json:"default_profile"`
+	Connections    map[string]*Connection            `yaml:"connections" json:"connections"` // プロファイル共通の接続
+	Profiles       map[string]map[string]*Connection `yaml:"profiles" json:"profiles"`
+	path           string
+}
+
+// Connection 名前付きの接続設定
+type Connection struct {
+	Mode     string      `yaml:"mode" json:"mode"`
+	Primary  string      `yaml:"primary" json:"primary"`
+	Reader   string      `yaml:"reader" json:"reader"`
+	DB       *int        `yaml:"db" json:"db"`
+	Username string      `yaml:"username" json:"username"`
+	Password string      `yaml:"password" json:"password"`
+	Sentinel *Sentinel   `yaml:"sentinel" json:"sentinel"`
+	TLS      *TLSOptions `yaml:"tls" json:"tls"`
+	Pool     *Pool       `yaml:"pool" json:"pool"`
+	missing  []string    // 未設定の環境変数。使用する場合にエラーとする
+}
+
+type Sentinel struct {
+	MasterName string   `yaml:"master_name" json:"master_name"`
+	Addrs      []string `yaml:"addrs" json:"addrs"`
+	Username   string   `yaml:"username" json:"username"`
+	Password   string   `yaml:"password" json:"password"`
+}
+
+type Pool struct {
+	Size            int      `yaml:"size" json:"size"`
+	MinIdleConns    int      `yaml:"min_idle_conns" json:"min_idle_conns"`
+	MaxIdleConns    int      `yaml:"max_idle_conns" json:"max_idle_conns"`
+	Timeout         Duration `yaml:"timeout" json:"timeout"`
+	ConnMaxIdleTime Duration `yaml:"conn_max_idle_time" json:"conn_max_idle_time"`
+	DialTimeout     Duration `yaml:"dial_timeout" json:"dial_timeout"`
+	ReadTimeout     Duration `yaml:"read_timeout" json:"read_timeout"`
+	WriteTimeout    Duration `yaml:"write_timeout" json:"write_timeout"`
+}
+
+// Duration "5s"形式の文字列で指定する期間
+type Duration time.Duration
+
+func (d *Duration) set(s string) error {
+	if s == "" {
+		*d = 0
+		return nil
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	return d.set(s)
+}
+
+func (d *Duration) UnmarshalYAML(value *yaml.Node) error {
+	return d.set(value.Value)
+}
+
+// ConfigError 設定ファイルの検証エラー
+type ConfigError struct {
+	Path     string
+	Problems []string
+}
+
+func (err *ConfigError) Error() string {
+	return fmt.Sprintf("redis: invalid config %s: %s", err.Path, strings.Join(err.Problems, "; "))
+}
+
+func (err *ConfigError) add(format string, args ...interface{}) {
+	err.Problems = append(err.Problems, fmt.Sprintf(format, args...))
+}
+
+var envPattern = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)(:-([^}]*))?\}`)
+
+// expandEnv ${VAR}と${VAR:-default}を環境変数で置き換える。未設定の変数に既定値がない場合はエラー
+func expandEnv(s string) (string, error) {
+	var missing []string
+	s = envPattern.ReplaceAllStringFunc(s, func(m string) string {
+		v := envPattern.FindStringSubmatch(m)
+		value, ok := os.LookupEnv(v[1])
+		if v[2] != "" { // 既定値あり
+			if !ok || value == "" {
+				return v[3]
+			}
+			return value
+		}
+		if !ok {
+			missing = append(missing, v[1])
+		}
+		return value
+	})
+	if len(missing) > 0 {
+		return "", fmt.Errorf("environment variable %s is not set", strings.Join(missing, ", "))
+	}
+	return s, nil
+}
+
+// expandFields 解析後の文字列の項目に環境変数を展開する。
+// 展開前の文字列を解析すると、値に含まれる#や": "で文書が壊れるため
+func expandFields(v reflect.Value, path string, errs *ConfigError) {
+	switch v.Kind() {
+	case reflect.Ptr:
+		if !v.IsNil() {
+			expandFields(v.Elem(), path, errs)
+		}
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			name := strings.Split(t.Field(i).Tag.Get("yaml"), ",")[0]
+			if name == "" || name == "-" {
+				continue
+			}
+			expandFields(v.Field(i), join(path, name), errs)
+		}
+	case reflect.Map:
+		keys := v.MapKeys()
+		sort.Slice(keys, func(i, j int) bool { return keys[i].String() < keys[j].String() })
+		for _, k := range keys {
+			e := v.MapIndex(k)
+			if e.Kind() == reflect.String {
+				continue
+			}
+			expandFields(e, join(path, k.String()), errs)
+		}
+	case reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			expandFields(v.Index(i), fmt.Sprintf("%s[%d]", path, i), errs)
+		}
+	case reflect.String:
+		if !v.CanSet() {
+			return
+		}
+		s, err := expandEnv(v.String())
+		if err != nil {
+			errs.add("%s: %v", path, err)
+			return
+		}
+		v.SetString(s)
+	}
+}
+
+func join(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+// LoadConfig YAMLまたはJSONの設定ファイルを読み込む
+func LoadConfig(path string) (*Config, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseConfig(path, b)
+}
+
+func ParseConfig(path string, b []byte) (*Config, error) {
+	cfg := &Config{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		dec := json.NewDecoder(bytes.NewReader(b))
+		dec.DisallowUnknownFields()
+		err := dec.Decode(cfg)
+		if err != nil {
+			return nil, &ConfigError{Path: path, Problems: []string{err.Error()}}
+		}
+	default:
+		dec := yaml.NewDecoder(bytes.NewReader(b))
+		dec.KnownFields(true)
+		if err := dec.Decode(cfg); err != nil {
+			return nil, &ConfigError{Path: path, Problems: []string{err.Error()}}
+		}
+	}
+	cfg.path = path
+	if err := cfg.expand(); err != nil {
+		return nil, err
+	}
+	if err := cfg.validate(path); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// expand 環境変数を展開する。未設定の変数は他のプロファイルの読み込みを妨げないように接続ごとに記録する
+func (cfg *Config) expand() error {
+	profile, err := expandEnv(cfg.DefaultProfile)
+	if err != nil {
+		return &ConfigError{Path: cfg.path, Problems: []string{"default_profile: " + err.Error()}}
+	}
+	cfg.DefaultProfile = profile
+	expand := func(path string, connections map[string]*Connection) {
+		for _, name := range sortedKeys(connections) {
+			if c := connections[name]; c != nil {
+				missing := &ConfigError{}
+				expandFields(reflect.ValueOf(c), path+"."+name, missing)
+				c.missing = missing.Problems
+			}
+		}
+	}
+	expand("connections", cfg.Connections)
+	for p, connections := range cfg.Profiles {
+		expand("profiles."+p, connections)
+	}
+	return nil
+}
+
+// Connection プロファイルと接続名から接続設定を取得する
+func (cfg *Config) Connection(profile, name string) (*Connection, error) {
+	if name == "" {
+		name = DefaultConnection
+	}
+	if profile == "" {
+		profile = cfg.DefaultProfile
+	}
+	if profile != "" {
+		connections, ok := cfg.Profiles[profile]
+		if !ok {
+			return nil, fmt.Errorf("redis: profile %q not found", profile)
+		}
+		if c, ok := connections[name]; ok {
+			return cfg.resolved(c)
+		}
+	}
+	if c, ok := cfg.Connections[name]; ok {
+		return cfg.resolved(c)
+	}
+	if profile != "" {
+		return nil, fmt.Errorf("redis: connection %q not found in profile %q", name, profile)
+	}
+	return nil, fmt.Errorf("redis: connection %q not found", name)
+}
+
+// resolved 接続設定で使用する環境変数がすべて設定されているか確認する
+func (cfg *Config) resolved(c *Connection) (*Connection, error) {
+	if c != nil && len(c.missing) > 0 {
+		return nil, &ConfigError{Path: cfg.path, Problems: c.missing}
+	}
+	return c, nil
+}
+
+func (cfg *Config) validate(path string) error {
+	errs := &ConfigError{Path: path}
+	if len(cfg.Connections) == 0 && len(cfg.Profiles) == 0 {
+		errs.add("no connections defined")
+	}
+	if cfg.DefaultProfile != "" {
+		if _, ok := cfg.Profiles[cfg.DefaultProfile]; !ok {
+			errs.add("default_profile: profile %q not found", cfg.DefaultProfile)
+		}
+	}
+	for _, name := range sortedKeys(cfg.Connections) {
+		cfg.Connections[name].validate("connections."+name, errs)
+	}
+	profiles := make([]string, 0, len(cfg.Profiles))
+	for p := range cfg.Profiles {
+		profiles = append(profiles, p)
+	}
+	sort.Strings(profiles)
+	for _, p := range profiles {
+		for _, name := range sortedKeys(cfg.Profiles[p]) {
+			cfg.Profiles[p][name].validate("profiles."+p+"."+name, errs)
+		}
+	}
+	if len(errs.Problems) > 0 {
+		return errs
+	}
+	return nil
+}
+
+func sortedKeys(m map[string]*Connection) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func validEndpoint(endpoint string) bool {
+	host, port, err := net.SplitHostPort(endpoint)
+	return err == nil && host != "" && port != ""
+}
+
+func (c *Connection) validate(path string, errs *ConfigError) {
+	if c == nil {
+		errs.add("%s: empty connection", path)
+		return
+	}
+	if len(c.missing) > 0 { // 値が揃っていないため、使用する際にエラーとする
+		return
+	}
+	switch c.Mode {
+	case "", ModeStandalone, ModeCluster:
+		if c.Primary == "" {
+			errs.add("%s.primary: required", path)
+		} else if !validEndpoint(c.Primary) {
+			errs.add("%s.primary: %q is not host:port", path, c.Primary)
+		}
+		if c.Reader != "" && !validEndpoint(c.Reader) {
+			errs.add("%s.reader: %q is not host:port", path, c.Reader)
+		}
+		if c.Sentinel != nil {
+			errs.add("%s.sentinel: only allowed in sentinel mode", path)
+		}
+	case ModeSentinel:
+		if c.Sentinel == nil {
+			errs.add("%s.sentinel: required in sentinel mode", path)
+		} else {
+			if c.Sentinel.MasterName == "" {
+				errs.add("%s.sentinel.master_name: required", path)
+			}
+			if len(c.Sentinel.Addrs) == 0 {
+				errs.add("%s.sentinel.addrs: required", path)
+			}
+			for _, addr := range c.Sentinel.Addrs {
+				if !validEndpoint(addr) {
+					errs.add("%s.sentinel.addrs: %q is not host:port", path, addr)
+				}
+			}
+		}
+	default:
+		errs.add("%s.mode: unknown mode %q", path, c.Mode)
+	}
+	if c.DB != nil {
+		if *c.DB < 0 {
+			errs.add("%s.db: must not be negative", path)
+		} else if *c.DB != 0 && c.Mode == ModeCluster {
+			errs.add("%s.db: cluster mode supports only db 0", path)
+		}
+	}
+	if t := c.TLS; t != nil {
+		if (t.CertFile == "") != (t.KeyFile == "") {
+			errs.add("%s.tls: cert_file and key_file must be set together", path)
+		}
+		if c.Mode == ModeSentinel && t.Enable && t.CAFile != "" && !t.InsecureSkipVerify && t.ServerName == "" {
+			errs.add("%s.tls.server_name: required in sentinel mode", path)
+		}
+		for _, f := range []struct{ name, file string }{{"ca_file", t.CAFile}, {"cert_file", t.CertFile}, {"key_file", t.KeyFile}} {
+			if f.file == "" {
+				continue
+			}
+			if _, err := os.Stat(f.file); err != nil {
+				errs.add("%s.tls.%s: %v", path, f.name, err)
+			}
+		}
+	}
+	if p := c.Pool; p != nil {
+		if p.Size < 0 || p.MinIdleConns < 0 || p.MaxIdleConns < 0 {
+			errs.add("%s.pool: sizes must not be negative", path)
+		}
+		if p.Size > 0 && p.MinIdleConns > p.Size {
+			errs.add("%s.pool.min_idle_conns: must not exceed size", path)
+		}
+	}
+}
+
+// Builder 接続設定からDefaultBuilderを作成する
+func (c *Connection) Builder() (*DefaultBuilder, error) {
+	tlsConfig, err := c.TLS.Config()
+	if err != nil {
+		return nil, err
+	}
+	b := &DefaultBuilder{
+		ClusterEnable: c.Mode == ModeCluster,
+		PrimaryHost:   c.Primary,
+		ReaderHost:    c.Reader,
+		TlsConfig:     tlsConfig,
+		Username:      c.Username,
+		Password:      c.Password,
+	}
+	if b.ReaderHost == "" {
+		b.ReaderHost = b.PrimaryHost
+	}
+	if s := c.Sentinel; s != nil && c.Mode == ModeSentinel {
+		b.Sentinel = &SentinelOptions{
+			MasterName: s.MasterName,
+			Addrs:      s.Addrs,
+			Username:   s.Username,
+			Password:   s.Password,
+		}
+	}
+	if p := c.Pool; p != nil {
+		b.Pool = PoolOptions{
+			PoolSize:        p.Size,
+			MinIdleConns:    p.MinIdleConns,
+			MaxIdleConns:    p.MaxIdleConns,
+			PoolTimeout:     time.Duration(p.Timeout),
+			ConnMaxIdleTime: time.Duration(p.ConnMaxIdleTime),
+			DialTimeout:     time.Duration(p.DialTimeout),
+			ReadTimeout:     time.Duration(p.ReadTimeout),
+			WriteTimeout:    time.Duration(p.WriteTimeout),
+		}
+	}
+	return b, nil
+}
+
+// ConfigBuilder 設定ファイルの接続設定でクライアントを作成する
+type ConfigBuilder struct {
+	Path    string // 設定ファイル。空の場合はREDIS_CONFIG_FILE
+	Profile string // プロファイル。空の場合はREDIS_PROFILE、設定ファイルのdefault_profileの順に使用する
+	Name    string // 接続名。空の場合はdefault
+}
+
+func (b *ConfigBuilder) Build(ctx context.Context, db ...int) (primary *PrimaryClient, reader *ReaderClient, err error) {
+	path := b.Path
+	if path == "" {
+		path = ConfigFile()
+	}
+	if path == "" {
+		return nil, nil, fmt.Errorf("redis: config file is not specified")
+	}
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	profile := b.Profile
+	if profile == "" {
+		profile = Profile()
+	}
+	conn, err := cfg.Connection(profile, b.Name)
+	if err != nil {
+		return nil, nil, err
+	}
+	builder, err := conn.Builder()
+	if err != nil {
+		return nil, nil, err
+	}
+	if conn.DB != nil {
+		db = []int{*conn.DB}
+	}
+	return builder.Build(ctx, db...)
+}
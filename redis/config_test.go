@@ -0,0 +1,192 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testConfig = `
+default_profile: dev
+connections:
+  cache:
+    primary: ${TEST_REDIS_CACHE:-cache:6379}
+profiles:
+  dev:
+    default:
+      primary: ${TEST_REDIS_PRIMARY}
+      db: 2
+      pool:
+        size: 20
+        timeout: 3s
+  prod:
+    default:
+      mode: cluster
+      primary: redis.example.com:6379
+      password: ${TEST_REDIS_PASSWORD}
+      tls:
+        enable: true
+        server_name: redis.example.com
+`
+
+func writeConfig(t *testing.T, name, text string) string {
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(text), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadConfig(t *testing.T) {
+	t.Setenv("TEST_REDIS_PRIMARY", "localhost:6379")
+	t.Setenv("TEST_REDIS_PASSWORD", "secret")
+	cfg, err := LoadConfig(writeConfig(t, "redis.yaml", testConfig))
+	if !assert.Nil(t, err) {
+		return
+	}
+	c, err := cfg.Connection("", "")
+	assert.Nil(t, err)
+	assert.Equal(t, "localhost:6379", c.Primary)
+	assert.Equal(t, 2, *c.DB)
+	assert.Equal(t, Duration(3*time.Second), c.Pool.Timeout)
+
+	c, err = cfg.Connection("prod", "")
+	assert.Nil(t, err)
+	b, err := c.Builder()
+	assert.Nil(t, err)
+	assert.True(t, b.ClusterEnable)
+	assert.Equal(t, "secret", b.Password)
+	assert.Equal(t, "redis.example.com", b.TlsConfig.ServerName)
+	assert.Equal(t, "redis.example.com:6379", b.ReaderHost)
+
+	c, err = cfg.Connection("prod", "cache")
+	assert.Nil(t, err)
+	assert.Equal(t, "cache:6379", c.Primary)
+
+	_, err = cfg.Connection("stage", "")
+	assert.EqualError(t, err, `redis: profile "stage" not found`)
+	_, err = cfg.Connection("dev", "sessions")
+	assert.EqualError(t, err, `redis: connection "sessions" not found in profile "dev"`)
+}
+
+func TestLoadConfig_Env(t *testing.T) {
+	// 展開後の値は文書として解析しない
+	t.Setenv("TEST_REDIS_PASSWORD", `p#ss: "word"`)
+	t.Setenv("TEST_REDIS_CACHE", "")
+	cfg, err := LoadConfig(writeConfig(t, "redis.yaml", testConfig))
+	if !assert.Nil(t, err) {
+		return
+	}
+	c, err := cfg.Connection("prod", "")
+	assert.Nil(t, err)
+	assert.Equal(t, `p#ss: "word"`, c.Password)
+	c, err = cfg.Connection("prod", "cache")
+	assert.Nil(t, err)
+	assert.Equal(t, "cache:6379", c.Primary)
+
+	// 既定値のない変数が未設定の接続は使用できない
+	_, err = cfg.Connection("dev", "")
+	var cfgErr *ConfigError
+	if assert.True(t, errors.As(err, &cfgErr), err) {
+		assert.Equal(t, []string{"profiles.dev.default.primary: environment variable TEST_REDIS_PRIMARY is not set"}, cfgErr.Problems)
+	}
+
+	cfg, err = ParseConfig("redis.json", []byte(`{"connections": {"default": {"primary": "localhost:6379", "password": "${TEST_REDIS_PASSWORD}"}}}`))
+	assert.Nil(t, err)
+	c, err = cfg.Connection("", "")
+	assert.Nil(t, err)
+	assert.Equal(t, `p#ss: "word"`, c.Password)
+}
+
+func TestLoadConfig_JSON(t *testing.T) {
+	cfg, err := LoadConfig(writeConfig(t, "redis.json", `{
+  "connections": {
+    "default": {
+      "mode": "sentinel",
+      "sentinel": {"master_name": "mymaster", "addrs": ["sentinel:26379"]},
+      "pool": {"dial_timeout": "1s"}
+    }
+  }
+}`))
+	if !assert.Nil(t, err) {
+		return
+	}
+	c, err := cfg.Connection("", "")
+	assert.Nil(t, err)
+	b, err := c.Builder()
+	assert.Nil(t, err)
+	assert.Equal(t, "mymaster", b.Sentinel.MasterName)
+	assert.Equal(t, time.Second, b.Pool.DialTimeout)
+}
+
+func TestLoadConfig_Invalid(t *testing.T) {
+	_, err := LoadConfig(writeConfig(t, "redis.yaml", `
+default_profile: stage
+profiles:
+  dev:
+    default:
+      primary: localhost
+      db: -1
+    cluster:
+      mode: cluster
+      primary: localhost:7000
+      db: 1
+    sentinel:
+      mode: sentinel
+      sentinel:
+        addrs: ["sentinel"]
+    tls:
+      mode: replica
+      tls:
+        cert_file: cert.pem
+`))
+	cfgErr, ok := err.(*ConfigError)
+	if !assert.True(t, ok, err) {
+		return
+	}
+	for _, want := range []string{
+		`default_profile: profile "stage" not found`,
+		`profiles.dev.cluster.db: cluster mode supports only db 0`,
+		`profiles.dev.default.primary: "localhost" is not host:port`,
+		`profiles.dev.default.db: must not be negative`,
+		`profiles.dev.sentinel.sentinel.master_name: required`,
+		`profiles.dev.sentinel.sentinel.addrs: "sentinel" is not host:port`,
+		`profiles.dev.tls.mode: unknown mode "replica"`,
+		`profiles.dev.tls.tls: cert_file and key_file must be set together`,
+	} {
+		assert.Contains(t, cfgErr.Problems, want)
+	}
+
+	_, err = LoadConfig(writeConfig(t, "redis.yaml", "connections:\n  default:\n    primary: localhost:6379\n    hosts: []\n"))
+	assert.True(t, err != nil && strings.Contains(err.Error(), "field hosts not found"), err)
+}
+
+func TestConfigBuilder(t *testing.T) {
+	ctx := context.Background()
+	m := &MemoryBuilder{}
+	defer m.Close()
+	if _, _, err := m.Build(ctx); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("TEST_REDIS_PRIMARY", m.Server().Addr())
+	b := &ConfigBuilder{Path: writeConfig(t, "redis.yaml", testConfig)}
+	w, r, err := b.Build(ctx)
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer w.Client.Close()
+	assert.Nil(t, w.Client.Set(ctx, "config://key", "1", time.Minute).Err())
+	assert.Equal(t, 2, r.Db)
+	v, err := m.Server().DB(2).Get("config://key")
+	assert.Nil(t, err)
+	assert.Equal(t, "1", v)
+
+	_, _, err = (&ConfigBuilder{Path: b.Path, Profile: "stage"}).Build(ctx)
+	assert.EqualError(t, err, `redis: profile "stage" not found`)
+}
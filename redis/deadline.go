@@ -0,0 +1,116 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	BudgetRead     = "read"
+	BudgetWrite    = "write"
+	BudgetLock     = "lock"
+	BudgetThrottle = "throttle"
+)
+
+// Deadlines ctxに期限がない場合に適用する操作ごとの期限。0の場合は適用しない
+type Deadlines struct {
+	Read     time.Duration // ReadOnly
+	Write    time.Duration // Universal
+	Lock     time.Duration // WithLockのロック取得
+	Throttle time.Duration // throttlesの待機
+}
+
+var deadlines atomic.Value
+
+func init() {
+	deadlines.Store(Deadlines{})
+}
+
+// SetDeadlines 既定の期限を設定する。実行中の処理には影響しない
+func SetDeadlines(d Deadlines) {
+	deadlines.Store(d)
+}
+
+func currentDeadlines() Deadlines {
+	return deadlines.Load().(Deadlines)
+}
+
+func (d Deadlines) budget(name string) time.Duration {
+	switch name {
+	case BudgetRead:
+		return d.Read
+	case BudgetWrite:
+		return d.Write
+	case BudgetLock:
+		return d.Lock
+	case BudgetThrottle:
+		return d.Throttle
+	}
+	return 0
+}
+
+// DeadlineError 既定の期限を超過した
+type DeadlineError struct {
+	Budget  string
+	Timeout time.Duration
+	Err     error
+}
+
+func (err *DeadlineError) Error() string {
+	return fmt.Sprintf("redis: %s deadline of %s exceeded: %v", err.Budget, err.Timeout, err.Err)
+}
+
+func (err *DeadlineError) Unwrap() error {
+	return err.Err
+}
+
+func IsDeadlineExceeded(err error) bool {
+	var e *DeadlineError
+	return errors.As(err, &e)
+}
+
+// WithBudget ctxに期限がなければbudgetの既定の期限を設定する。
+// 戻り値の関数は処理の終了時に呼び出し、期限を超過していた場合はDeadlineErrorに変換したエラーを返す
+func WithBudget(ctx context.Context, budget string) (context.Context, func(err error) error) {
+	timeout := currentDeadlines().budget(budget)
+	if _, ok := ctx.Deadline(); ok || timeout <= 0 {
+		return ctx, func(err error) error { return err }
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	ctx = context.WithValue(ctx, budgetKey{}, &DeadlineError{Budget: budget, Timeout: timeout})
+	deadline, _ := ctx.Deadline()
+	return ctx, func(err error) error {
+		defer cancel()
+		if exceeded(err, deadline, time.Now()) {
+			return &DeadlineError{Budget: budget, Timeout: timeout, Err: err}
+		}
+		return err
+	}
+}
+
+// exceeded 期限を過ぎた後のタイムアウトか判定する。
+// ContextTimeoutEnabledの場合はctxのタイマーより先にソケットの期限でi/o timeoutになるため、ctx.Err()では判定できない
+func exceeded(err error, deadline, now time.Time) bool {
+	if err == nil || IsDeadlineExceeded(err) || now.Before(deadline) {
+		return false
+	}
+	return IsTimeout(err)
+}
+
+type budgetKey struct{}
+
+// CheckDeadline ctxの残り時間がdより短い場合にエラーを返す。待機する前の確認に使う。
+// WithBudgetで設定した期限の場合はDeadlineError、それ以外はcontext.DeadlineExceeded
+func CheckDeadline(ctx context.Context, d time.Duration) error {
+	deadline, ok := ctx.Deadline()
+	if !ok || time.Until(deadline) >= d {
+		return nil
+	}
+	if b, ok := ctx.Value(budgetKey{}).(*DeadlineError); ok {
+		return &DeadlineError{Budget: b.Budget, Timeout: b.Timeout, Err: context.DeadlineExceeded}
+	}
+	return context.DeadlineExceeded
+}
@@ -0,0 +1,95 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeadlines(t *testing.T) {
+	ctx := context.Background()
+	b := &MemoryBuilder{}
+	defer b.Close()
+	assert.Nil(t, Setup(ctx, b))
+	SetDeadlines(Deadlines{Read: 50 * time.Millisecond, Write: 50 * time.Millisecond, Lock: 150 * time.Millisecond})
+	defer SetDeadlines(Deadlines{})
+
+	err := Universal(ctx, func(ctx context.Context, c Cmdable) error {
+		return c.BLPop(ctx, 0, "deadline://queue").Err()
+	})
+	var deadlineErr *DeadlineError
+	if assert.ErrorAs(t, err, &deadlineErr) {
+		assert.Equal(t, BudgetWrite, deadlineErr.Budget)
+		assert.Equal(t, 50*time.Millisecond, deadlineErr.Timeout)
+	}
+	assert.True(t, IsTimeout(err))
+
+	err = ReadOnly(ctx, func(ctx context.Context, c Cmdable) error {
+		return c.BLPop(ctx, 0, "deadline://queue").Err()
+	})
+	if assert.ErrorAs(t, err, &deadlineErr) {
+		assert.Equal(t, BudgetRead, deadlineErr.Budget)
+	}
+
+	// 呼び出し元の期限は変更しない
+	tctx, cancel := context.WithTimeout(ctx, 80*time.Millisecond)
+	defer cancel()
+	err = ReadOnly(tctx, func(ctx context.Context, c Cmdable) error {
+		dl, _ := ctx.Deadline()
+		expected, _ := tctx.Deadline()
+		assert.Equal(t, expected, dl)
+		return nil
+	})
+	assert.Nil(t, err)
+
+	ok, err := Lock(ctx, "deadline://lock", time.Minute)
+	assert.True(t, ok)
+	assert.Nil(t, err)
+	err = WithLock(ctx, "deadline://lock", func() error {
+		t.Fatal("must not be called")
+		return nil
+	})
+	if assert.ErrorAs(t, err, &deadlineErr) {
+		assert.Equal(t, BudgetLock, deadlineErr.Budget)
+	}
+	assert.False(t, IsLockFailure(err))
+
+	SetDeadlines(Deadlines{})
+	err = WithLock(ctx, "deadline://lock", func() error { return nil }, 2)
+	assert.True(t, IsLockFailure(err))
+}
+
+func TestDeadlines_Exceeded(t *testing.T) {
+	deadline := time.Now()
+	// ソケットの期限によるタイムアウトはctxのタイマーより先に返ることがある
+	timeout := &net.OpError{Op: "read", Net: "tcp", Err: os.ErrDeadlineExceeded}
+	assert.True(t, exceeded(timeout, deadline, deadline))
+	assert.True(t, exceeded(context.DeadlineExceeded, deadline, deadline.Add(time.Millisecond)))
+	assert.False(t, exceeded(timeout, deadline, deadline.Add(-time.Millisecond)))
+	assert.False(t, exceeded(errors.New("WRONGTYPE"), deadline, deadline))
+	assert.False(t, exceeded(nil, deadline, deadline))
+	assert.False(t, exceeded(&DeadlineError{Err: timeout}, deadline, deadline))
+}
+
+func TestSetDeadlines_Concurrently(t *testing.T) {
+	defer SetDeadlines(Deadlines{})
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				SetDeadlines(Deadlines{Read: time.Duration(i+1) * time.Second})
+				_, done := WithBudget(context.Background(), BudgetRead)
+				assert.Nil(t, done(nil))
+			}
+		}(i)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,193 @@
+package redis
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/goccha/logging/log"
+)
+
+// Discovery DefaultBuilderのPrimaryHost/ReaderHostをDNSで解決し、定期的に再解決する。
+// 解決結果が変わった場合はRefreshでクライアントを作り直す
+type Discovery struct {
+	SRV      bool          // PrimaryHost/ReaderHostをSRVレコード名として解決する。falseの場合はAレコード
+	Interval time.Duration // 再解決の間隔。既定値は30秒
+	Resolver Resolver      // 既定値はnet.DefaultResolver
+	mu       sync.Mutex
+	resolved string
+	stop     chan struct{}
+}
+
+func (d *Discovery) interval() time.Duration {
+	if d.Interval <= 0 {
+		return 30 * time.Second
+	}
+	return d.Interval
+}
+
+func (d *Discovery) resolver() Resolver {
+	if d.Resolver == nil {
+		return net.DefaultResolver
+	}
+	return d.Resolver
+}
+
+// lookup 接続先のエンドポイントと、変更検知に使う解決結果の一覧を返す
+func (d *Discovery) lookup(ctx context.Context, host string) (endpoint string, set []string, err error) {
+	if d.SRV {
+		var records []*net.SRV
+		if _, records, err = d.resolver().LookupSRV(ctx, "", "", host); err != nil {
+			return
+		}
+		for _, r := range records {
+			set = append(set, srvEndpoint(r))
+		}
+	} else {
+		name, port, e := net.SplitHostPort(host)
+		if e != nil {
+			name, port = host, "6379"
+		}
+		if net.ParseIP(name) != nil {
+			return host, []string{host}, nil
+		}
+		var addrs []string
+		if addrs, err = d.resolver().LookupHost(ctx, name); err != nil {
+			return
+		}
+		sort.Strings(addrs)
+		for _, addr := range addrs {
+			set = append(set, net.JoinHostPort(addr, port))
+		}
+	}
+	if len(set) == 0 {
+		return "", nil, &net.DNSError{Err: "no records", Name: host, IsNotFound: true}
+	}
+	endpoint = set[0]
+	sort.Strings(set)
+	return
+}
+
+// resolve 解決したエンドポイントに接続するDefaultBuilderを作成する
+func (d *Discovery) resolve(ctx context.Context, b *DefaultBuilder) (*DefaultBuilder, string, error) {
+	resolved := *b
+	resolved.Discovery = nil
+	resolved.serverNames = make(map[string]string)
+	primary, set, err := d.lookup(ctx, b.PrimaryHost)
+	if err != nil {
+		return nil, "", err
+	}
+	resolved.PrimaryHost = primary
+	resolved.serverNames[primary] = hostname(b.PrimaryHost)
+	snapshot := strings.Join(set, ",")
+	if b.ReaderHost == "" || b.ReaderHost == b.PrimaryHost {
+		resolved.ReaderHost = primary
+	} else {
+		reader, set, err := d.lookup(ctx, b.ReaderHost)
+		if err != nil {
+			return nil, "", err
+		}
+		resolved.ReaderHost = reader
+		resolved.serverNames[reader] = hostname(b.ReaderHost)
+		snapshot += "|" + strings.Join(set, ",")
+	}
+	return &resolved, snapshot, nil
+}
+
+func hostname(host string) string {
+	if name, _, err := net.SplitHostPort(host); err == nil {
+		return name
+	}
+	return host
+}
+
+func (d *Discovery) build(ctx context.Context, b *DefaultBuilder, db ...int) (primary *PrimaryClient, reader *ReaderClient, err error) {
+	resolved, snapshot, err := d.resolve(ctx, b)
+	if err != nil {
+		return nil, nil, err
+	}
+	if primary, reader, err = resolved.Build(ctx, db...); err != nil {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.resolved = snapshot
+	if d.stop == nil {
+		d.stop = make(chan struct{})
+		go d.watch(b, d.stop)
+		Register(ComponentFunc(d.Stop))
+	}
+	return
+}
+
+func (d *Discovery) watch(b *DefaultBuilder, stop chan struct{}) {
+	ticker := time.NewTicker(d.interval())
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			d.check(context.Background(), b)
+		}
+	}
+}
+
+// check 再解決して結果が変わっていればRefreshする
+func (d *Discovery) check(ctx context.Context, b *DefaultBuilder) {
+	_, snapshot, err := d.resolve(ctx, b)
+	if err != nil {
+		log.Warn(ctx).Err(err).Str("primary_host", b.PrimaryHost).Msg("redis: failed to resolve endpoints")
+		return
+	}
+	d.mu.Lock()
+	changed := snapshot != d.resolved
+	previous := d.resolved
+	d.mu.Unlock()
+	if !changed {
+		return
+	}
+	log.Info(ctx).Str("previous", previous).Str("resolved", snapshot).Msg("redis: endpoints changed")
+	if err = Refresh(ctx); err != nil {
+		log.Error(ctx).Err(err).Msg("redis: failed to refresh after endpoint change")
+	}
+}
+
+// Stop 再解決を停止する
+func (d *Discovery) Stop(ctx context.Context) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.stop != nil {
+		close(d.stop)
+		d.stop = nil
+	}
+	return nil
+}
+
+// tlsConfig ServerNameが空の場合は接続先のホスト名で証明書を検証する。
+// DNSで解決したアドレスに接続する場合は、元のホスト名を使う
+func (b *DefaultBuilder) tlsConfig(addr string) *tls.Config {
+	if b.TlsConfig == nil || b.TlsConfig.ServerName != "" {
+		return b.TlsConfig
+	}
+	name, ok := b.serverNames[addr]
+	if !ok {
+		name = hostname(addr)
+	}
+	cfg := b.TlsConfig.Clone()
+	cfg.ServerName = name
+	if verify := cfg.VerifyConnection; verify != nil {
+		// IPアドレスはSNIで送信されないため、ConnectionStateのServerNameが空になる
+		cfg.VerifyConnection = func(cs tls.ConnectionState) error {
+			if cs.ServerName == "" {
+				cs.ServerName = name
+			}
+			return verify(cs)
+		}
+	}
+	return cfg
+}
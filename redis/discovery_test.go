@@ -0,0 +1,77 @@
+package redis
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func srvRecord(t *testing.T, b *MemoryBuilder) *net.SRV {
+	if _, _, err := b.Build(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	_, port, _ := net.SplitHostPort(b.Server().Addr())
+	p, _ := strconv.Atoi(port)
+	return &net.SRV{Target: "127.0.0.1.", Port: uint16(p)}
+}
+
+func TestDiscovery(t *testing.T) {
+	ctx := context.Background()
+	first, second := &MemoryBuilder{}, &MemoryBuilder{}
+	defer first.Close()
+	defer second.Close()
+	resolver := &fakeResolver{srv: map[string][]*net.SRV{
+		"_redis._tcp.primary.example.com": {srvRecord(t, first)},
+	}}
+	b := &DefaultBuilder{
+		PrimaryHost: "_redis._tcp.primary.example.com",
+		Discovery:   &Discovery{SRV: true, Interval: 10 * time.Millisecond, Resolver: resolver},
+	}
+	if !assert.Nil(t, Setup(ctx, b)) {
+		return
+	}
+	defer b.Discovery.Stop(ctx)
+	assert.Nil(t, Primary().Set(ctx, "discovery://key", "1", time.Minute).Err())
+	assert.True(t, first.Server().Exists("discovery://key"))
+
+	old := Primary()
+	resolver.mu.Lock()
+	resolver.srv["_redis._tcp.primary.example.com"] = []*net.SRV{srvRecord(t, second)}
+	resolver.mu.Unlock()
+	assert.Eventually(t, func() bool { return Primary() != old }, time.Second, 5*time.Millisecond)
+	assert.Nil(t, Primary().Set(ctx, "discovery://key", "2", time.Minute).Err())
+	v, err := second.Server().Get("discovery://key")
+	assert.Nil(t, err)
+	assert.Equal(t, "2", v)
+}
+
+func TestDiscovery_Lookup(t *testing.T) {
+	ctx := context.Background()
+	d := &Discovery{Resolver: &fakeResolver{hosts: map[string][]string{
+		"primary.example.com": {"10.0.0.2", "10.0.0.1"},
+		"reader.example.com":  {"10.0.1.1"},
+	}}}
+	b := &DefaultBuilder{
+		PrimaryHost: "primary.example.com:6380",
+		ReaderHost:  "reader.example.com",
+		TlsConfig:   &tls.Config{MinVersion: tls.VersionTLS12},
+	}
+	resolved, snapshot, err := d.resolve(ctx, b)
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.Equal(t, "10.0.0.1:6380", resolved.PrimaryHost)
+	assert.Equal(t, "10.0.1.1:6379", resolved.ReaderHost)
+	assert.Equal(t, "10.0.0.1:6380,10.0.0.2:6380|10.0.1.1:6379", snapshot)
+	assert.Equal(t, "primary.example.com", resolved.tlsConfig(resolved.PrimaryHost).ServerName)
+	assert.Equal(t, "reader.example.com", resolved.tlsConfig(resolved.ReaderHost).ServerName)
+	assert.Equal(t, "", b.TlsConfig.ServerName)
+
+	_, _, err = d.resolve(ctx, &DefaultBuilder{PrimaryHost: "missing.example.com:6379"})
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,173 @@
+package redis
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// DecryptError 値を復号できない
+type DecryptError struct {
+	KeyID string
+	Err   error
+}
+
+func (err *DecryptError) Error() string {
+	return fmt.Sprintf("redis: failed to decrypt value (key %q): %v", err.KeyID, err.Err)
+}
+
+func (err *DecryptError) Unwrap() error {
+	return err.Err
+}
+
+func IsDecryptError(err error) bool {
+	var e *DecryptError
+	return errors.As(err, &e)
+}
+
+var errUnknownKey = errors.New("unknown key")
+
+// Keyring 暗号鍵の一覧。暗号化には現在の鍵を使い、復号には値に埋め込まれた鍵IDの鍵を使う
+type Keyring struct {
+	mu      sync.RWMutex
+	current string
+	keys    map[string]cipher.AEAD
+}
+
+// NewKeyring 鍵IDとAESの鍵(16、24、32バイト)で作成する
+func NewKeyring(id string, key []byte) (*Keyring, error) {
+	k := &Keyring{keys: make(map[string]cipher.AEAD)}
+	if err := k.Rotate(id, key); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// Add 復号だけに使う鍵を追加する
+func (k *Keyring) Add(id string, key []byte) error {
+	if id == "" || len(id) > 255 {
+		return fmt.Errorf("redis: key id must be 1 to 255 bytes: %q", id)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return err
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.keys[id] = aead
+	return nil
+}
+
+// Rotate 鍵を追加して暗号化に使う鍵にする。以前の鍵は復号に使う
+func (k *Keyring) Rotate(id string, key []byte) error {
+	if err := k.Add(id, key); err != nil {
+		return err
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.current = id
+	return nil
+}
+
+// Remove 鍵を削除する。現在の鍵は削除できない
+func (k *Keyring) Remove(id string) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if id == k.current {
+		return fmt.Errorf("redis: can't remove the current key %q", id)
+	}
+	delete(k.keys, id)
+	return nil
+}
+
+// Current 暗号化に使う鍵ID
+func (k *Keyring) Current() string {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.current
+}
+
+func (k *Keyring) key(id string) cipher.AEAD {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.keys[id]
+}
+
+func (k *Keyring) currentKey() (string, cipher.AEAD) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.current, k.keys[k.current]
+}
+
+// Encryption AES-GCMで値を暗号化するCodec。
+// 形式は CodecMagic | FormatEncryption | 版(1) | 鍵IDの長さ(1) | 鍵ID | nonce | 暗号文
+type Encryption struct {
+	Keyring *Keyring
+}
+
+func NewEncryption(k *Keyring) *Encryption {
+	return &Encryption{Keyring: k}
+}
+
+func (e *Encryption) Encode(value []byte) ([]byte, error) {
+	id, aead := e.Keyring.currentKey()
+	out := make([]byte, 0, len(CodecMagic)+3+len(id)+aead.NonceSize()+len(value)+aead.Overhead())
+	out = appendHeader(out, FormatEncryption)
+	out = append(out, byte(len(id)))
+	out = append(out, id...)
+	nonce := out[len(out) : len(out)+aead.NonceSize()]
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	out = out[:len(out)+len(nonce)]
+	return aead.Seal(out, nonce, value, nil), nil
+}
+
+func (e *Encryption) Decode(value []byte) ([]byte, error) {
+	id, body, ok, err := splitEncrypted(value)
+	if err != nil {
+		return nil, &DecryptError{Err: err}
+	} else if !ok {
+		return value, nil
+	}
+	aead := e.Keyring.key(id)
+	if aead == nil {
+		return nil, &DecryptError{KeyID: id, Err: errUnknownKey}
+	}
+	if len(body) < aead.NonceSize() {
+		return nil, &DecryptError{KeyID: id, Err: errors.New("value too short")}
+	}
+	plain, err := aead.Open(nil, body[:aead.NonceSize()], body[aead.NonceSize():], nil)
+	if err != nil {
+		return nil, &DecryptError{KeyID: id, Err: err}
+	}
+	return plain, nil
+}
+
+// KeyID 暗号化に使った鍵IDを返す。暗号化されていない値の場合はfalse
+func (e *Encryption) KeyID(value []byte) (string, bool) {
+	id, _, ok, err := splitEncrypted(value)
+	return id, ok && err == nil
+}
+
+func splitEncrypted(value []byte) (id string, body []byte, ok bool, err error) {
+	if value, ok, err = parseHeader(value, FormatEncryption); !ok || err != nil {
+		return "", nil, false, err
+	}
+	if len(value) < 1 {
+		return "", nil, false, errors.New("value too short")
+	}
+	n := int(value[0])
+	if n == 0 || len(value) < 1+n {
+		return "", nil, false, errors.New("invalid key id")
+	}
+	return string(value[1 : 1+n]), value[1+n:], true, nil
+}
@@ -0,0 +1,257 @@
+package redis
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEncryption(t *testing.T) {
+	k, err := NewKeyring("v1", bytes.Repeat([]byte{1}, 32))
+	assert.Nil(t, err)
+	e := NewEncryption(k)
+
+	v1, err := e.Encode([]byte("alice@example.com"))
+	assert.Nil(t, err)
+	assert.True(t, strings.HasPrefix(string(v1), CodecMagic+"E"))
+	assert.NotContains(t, string(v1), "alice")
+	id, ok := e.KeyID(v1)
+	assert.True(t, ok)
+	assert.Equal(t, "v1", id)
+
+	assert.Nil(t, k.Rotate("v2", bytes.Repeat([]byte{2}, 16)))
+	v2, err := e.Encode([]byte("bob@example.com"))
+	assert.Nil(t, err)
+	id, _ = e.KeyID(v2)
+	assert.Equal(t, "v2", id)
+	plain, err := e.Decode(v1)
+	assert.Nil(t, err)
+	assert.Equal(t, "alice@example.com", string(plain))
+
+	plain, err = e.Decode([]byte("plain text"))
+	assert.Nil(t, err)
+	assert.Equal(t, "plain text", string(plain))
+	// MessagePackのfixmapなど、0x81から始まるバイナリの値
+	msgpack := []byte{0x81, 0xa1, 'a', 0x01}
+	plain, err = e.Decode(msgpack)
+	assert.Nil(t, err)
+	assert.Equal(t, msgpack, plain)
+
+	future := append([]byte(CodecMagic+"E\x02"), v1[len(CodecMagic)+2:]...)
+	_, err = e.Decode(future)
+	assert.True(t, IsDecryptError(err))
+	assert.True(t, IsFormatError(err))
+
+	assert.NotNil(t, k.Remove("v2"))
+	assert.Nil(t, k.Remove("v1"))
+	_, err = e.Decode(v1)
+	assert.True(t, IsDecryptError(err))
+
+	v2[len(v2)-1] ^= 0xff
+	_, err = e.Decode(v2)
+	assert.True(t, IsDecryptError(err))
+
+	_, err = NewKeyring("v1", []byte("short"))
+	assert.NotNil(t, err)
+}
+
+func TestValueCodec(t *testing.T) {
+	ctx := context.Background()
+	k, _ := NewKeyring("v1", bytes.Repeat([]byte{1}, 32))
+	e := NewEncryption(k)
+	SetValueCodec(&ValueCodec{Codec: e, Keys: []string{"pii://*"}})
+	defer SetValueCodec(nil)
+	b := &MemoryBuilder{}
+	defer b.Close()
+	assert.Nil(t, Setup(ctx, b))
+
+	err := Universal(ctx, func(ctx context.Context, c Cmdable) error {
+		if err := c.Set(ctx, "pii://email", "alice@example.com", time.Minute).Err(); err != nil {
+			return err
+		}
+		if err := c.MSet(ctx, "pii://name", "alice", "public://name", "alice").Err(); err != nil {
+			return err
+		}
+		return c.HSet(ctx, "pii://profile", "phone", "090", "age", 20).Err()
+	})
+	assert.Nil(t, err)
+
+	raw, _ := b.Server().Get("pii://email")
+	assert.True(t, strings.HasPrefix(raw, CodecMagic+"E"))
+	assert.NotContains(t, raw, "alice")
+	raw, _ = b.Server().Get("public://name")
+	assert.Equal(t, "alice", raw)
+	assert.NotEqual(t, "20", b.Server().HGet("pii://profile", "age"))
+
+	err = ReadOnly(ctx, func(ctx context.Context, c Cmdable) error {
+		v, err := c.Get(ctx, "pii://email").Result()
+		assert.Equal(t, "alice@example.com", v)
+		if err != nil {
+			return err
+		}
+		values, err := c.MGet(ctx, "pii://name", "public://name", "pii://missing").Result()
+		assert.Equal(t, []interface{}{"alice", "alice", nil}, values)
+		if err != nil {
+			return err
+		}
+		m, err := c.HGetAll(ctx, "pii://profile").Result()
+		assert.Equal(t, map[string]string{"phone": "090", "age": "20"}, m)
+		return err
+	})
+	assert.Nil(t, err)
+
+	cmds, err := Primary().Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(ctx, "pii://pipe", "secret", 0)
+		pipe.Get(ctx, "pii://pipe")
+		pipe.HGet(ctx, "pii://profile", "phone")
+		return nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, "secret", cmds[1].(*redis.StringCmd).Val())
+	assert.Equal(t, "090", cmds[2].(*redis.StringCmd).Val())
+
+	raw, err = Primary().Get(WithoutCodec(ctx), "pii://pipe").Result()
+	assert.Nil(t, err)
+	assert.True(t, strings.HasPrefix(raw, CodecMagic+"E"))
+
+	// フェイルオーバー後の再実行と同じく、同じコマンドを再度実行しても二重に暗号化しない
+	cmd := redis.NewStatusCmd(ctx, "set", "pii://users/1/email", "carol@example.com")
+	assert.Nil(t, Primary().Process(ctx, cmd))
+	assert.Equal(t, "carol@example.com", cmd.Args()[2])
+	assert.Nil(t, Primary().Process(ctx, cmd))
+	raw, _ = b.Server().Get("pii://users/1/email")
+	assert.True(t, strings.HasPrefix(raw, CodecMagic+"E"))
+	v, err := Primary().Get(ctx, "pii://users/1/email").Result()
+	assert.Nil(t, err)
+	assert.Equal(t, "carol@example.com", v)
+
+	// 対象外のキーのカウンターとロックは変換しない
+	assert.Nil(t, Primary().Incr(ctx, "counter://{pii}/1").Err())
+	assert.Nil(t, Primary().SetNX(ctx, "lock://pii", "owner", time.Minute).Err())
+	raw, _ = b.Server().Get("counter://{pii}/1")
+	assert.Equal(t, "1", raw)
+	raw, _ = b.Server().Get("lock://pii")
+	assert.Equal(t, "owner", raw)
+
+	// 暗号化前に保存した値はそのまま読み込む
+	assert.Nil(t, b.Server().Set("pii://legacy", "plain"))
+	v, err = Primary().Get(ctx, "pii://legacy").Result()
+	assert.Nil(t, err)
+	assert.Equal(t, "plain", v)
+}
+
+func TestValueCodec_NoKeys(t *testing.T) {
+	ctx := context.Background()
+	k, _ := NewKeyring("v1", bytes.Repeat([]byte{1}, 32))
+	SetValueCodec(&ValueCodec{Codec: NewEncryption(k)})
+	defer SetValueCodec(nil)
+	b := &MemoryBuilder{}
+	defer b.Close()
+	assert.Nil(t, Setup(ctx, b))
+
+	// Keysが空の場合はどのキーも変換しない
+	assert.Nil(t, Primary().Set(ctx, "lock://a", "owner", time.Minute).Err())
+	assert.Nil(t, Primary().IncrBy(ctx, "counter://a", 2).Err())
+	assert.Nil(t, Primary().Incr(ctx, "counter://a").Err())
+	assert.Nil(t, Primary().HSet(ctx, "session://a", "user", "alice").Err())
+	raw, _ := b.Server().Get("lock://a")
+	assert.Equal(t, "owner", raw)
+	raw, _ = b.Server().Get("counter://a")
+	assert.Equal(t, "3", raw)
+	assert.Equal(t, "alice", b.Server().HGet("session://a", "user"))
+}
+
+func TestReEncrypter(t *testing.T) {
+	ctx := context.Background()
+	k, _ := NewKeyring("v1", bytes.Repeat([]byte{1}, 32))
+	e := NewEncryption(k)
+	SetValueCodec(&ValueCodec{Codec: e, Keys: []string{"pii://*"}})
+	defer SetValueCodec(nil)
+	b := &MemoryBuilder{}
+	defer b.Close()
+	assert.Nil(t, Setup(ctx, b))
+
+	assert.Nil(t, Primary().Set(ctx, "pii://a", "a", time.Minute).Err())
+	assert.Nil(t, Primary().Set(ctx, "pii://b", "b", 0).Err())
+	assert.Nil(t, Primary().HSet(ctx, "pii://h", "f1", "1", "f2", "2").Err())
+	assert.Nil(t, Primary().RPush(ctx, "pii://list", "x").Err())
+	assert.Nil(t, k.Rotate("v2", bytes.Repeat([]byte{2}, 32)))
+	assert.Nil(t, Primary().Set(ctx, "pii://c", "c", 0).Err())
+
+	// 鍵のない値は失敗として数え、他の値は暗号化し直す
+	other, _ := NewKeyring("unknown", bytes.Repeat([]byte{3}, 32))
+	broken, _ := NewEncryption(other).Encode([]byte("x"))
+	assert.Nil(t, b.Server().Set("pii://broken", string(broken)))
+
+	r := &ReEncrypter{Pattern: "pii://*", Encryption: e, Options: BulkOptions{BatchSize: 2}}
+	res, err := r.Run(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(4), res.Replaced)
+	assert.Equal(t, int64(1), res.Failed)
+	if assert.Len(t, res.Errors, 1) {
+		assert.True(t, IsDecryptError(res.Errors[0]))
+		assert.Contains(t, res.Errors[0].Error(), "pii://broken")
+	}
+	for _, key := range []string{"pii://a", "pii://b", "pii://c"} {
+		raw, _ := b.Server().Get(key)
+		id, _ := e.KeyID([]byte(raw))
+		assert.Equal(t, "v2", id, key)
+	}
+	id, _ := e.KeyID([]byte(b.Server().HGet("pii://h", "f2")))
+	assert.Equal(t, "v2", id)
+	assert.True(t, b.Server().TTL("pii://a") > 0)
+
+	assert.Nil(t, k.Remove("v1"))
+	v, err := Primary().Get(ctx, "pii://a").Result()
+	assert.Nil(t, err)
+	assert.Equal(t, "a", v)
+
+	res, err = r.Run(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), res.Replaced)
+}
+
+func TestReEncrypter_Frames(t *testing.T) {
+	ctx := context.Background()
+	k, _ := NewKeyring("v1", bytes.Repeat([]byte{1}, 32))
+	e := NewEncryption(k)
+	SetValueCodec(&ValueCodec{Codec: Codecs{e, &Compression{Threshold: 1}}, ChunkSize: 64, Keys: []string{"pii://*"}})
+	defer SetValueCodec(nil)
+	b := &MemoryBuilder{}
+	defer b.Close()
+	assert.Nil(t, Setup(ctx, b))
+
+	large := strings.Repeat("secret", 50)
+	assert.Nil(t, Primary().Set(ctx, "pii://small", "secret", 0).Err())
+	assert.Nil(t, Primary().Set(ctx, "pii://large", large, time.Minute).Err())
+	assert.Nil(t, Primary().Set(ctx, "pii://{tag}large", large, time.Minute).Err())
+	assert.True(t, b.Server().Exists("pii://{tag}large/chunk/1"))
+	raw, _ := b.Server().Get("pii://small")
+	assert.True(t, strings.HasPrefix(raw, CodecMagic+"Z"))
+	raw, _ = b.Server().Get("pii://large")
+	assert.True(t, strings.HasPrefix(raw, manifestHeader))
+	assert.Nil(t, k.Rotate("v2", bytes.Repeat([]byte{2}, 32)))
+
+	r := &ReEncrypter{Pattern: "pii://*", Encryption: e}
+	res, err := r.Run(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(3), res.Replaced)
+	assert.Zero(t, res.Failed)
+	assert.Nil(t, k.Remove("v1"))
+	for key, expected := range map[string]string{"pii://small": "secret", "pii://large": large, "pii://{tag}large": large} {
+		v, err := Primary().Get(ctx, key).Result()
+		assert.Nil(t, err, key)
+		assert.Equal(t, expected, v, key)
+	}
+	assert.True(t, b.Server().TTL("pii://large") > 0)
+	assert.True(t, b.Server().TTL("{pii://large}/chunk/1") > 0)
+
+	res, err = r.Run(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), res.Replaced)
+}
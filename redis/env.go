@@ -2,7 +2,6 @@ package redis
 
 import (
 	"context"
-	"crypto/tls"
 
 	"github.com/goccha/envar"
 )
@@ -14,24 +13,40 @@ func init() {
 	}
 }
 
-type EnvBuilder struct{}
+type EnvBuilder struct {
+	Reader ReaderResolver // REDIS_READER_ENDPOINTが未指定の場合に使用する。既定値はElastiCacheReader
+}
 
 func (b *EnvBuilder) Build(ctx context.Context, db ...int) (primary *PrimaryClient, reader *ReaderClient, err error) {
 	host := PrimaryEndpoint()
 	readerHost := ReaderEndpoint()
 	if readerHost == "" {
-		readerHost = getReaderHost(host)
+		resolver := b.Reader
+		if resolver == nil {
+			resolver = ElastiCacheReader{}
+		}
+		if readerHost, err = resolver.Resolve(ctx, host); err != nil {
+			return
+		}
+		if readerHost == "" {
+			return nil, nil, &ReaderNotFoundError{Primary: host}
+		}
 	}
 	builder := &DefaultBuilder{
 		ClusterEnable: ClusterEnable(),
 		PrimaryHost:   host,
 		ReaderHost:    readerHost,
 	}
-	if TlsEnable() {
-		builder.TlsConfig = &tls.Config{
-			MinVersion: tls.VersionTLS12,
-			ServerName: ServerName(),
-		}
+	tlsOptions := &TLSOptions{
+		Enable:             TlsEnable(),
+		ServerName:         ServerName(),
+		CAFile:             TlsCAFile(),
+		CertFile:           TlsCertFile(),
+		KeyFile:            TlsKeyFile(),
+		InsecureSkipVerify: TlsInsecureSkipVerify(),
+	}
+	if builder.TlsConfig, err = tlsOptions.Config(); err != nil {
+		return
 	}
 	return builder.Build(ctx, db...)
 }
@@ -39,12 +54,18 @@ func (b *EnvBuilder) Build(ctx context.Context, db ...int) (primary *PrimaryClie
 var _env *Env
 
 type Env struct {
-	RedisPrimaryEndpoint string `envar:"REDIS_PRIMARY_ENDPOINT;default=localhost:6379"`
-	RedisReaderEndpoint  string `envar:"REDIS_READER_ENDPOINT"`
-	RedisClusterEnable   bool   `envar:"REDIS_CLUSTER_ENABLE"`
-	RedisDatabaseNumber  int    `envar:"REDIS_DATABASE_NUMBER;default=0"`
-	TlsEnable            bool   `envar:"REDIS_TLS_ENABLE"`
-	RedisServerName      string `envar:"REDIS_SERVER_NAME"`
+	RedisPrimaryEndpoint  string `envar:"REDIS_PRIMARY_ENDPOINT;default=localhost:6379"`
+	RedisReaderEndpoint   string `envar:"REDIS_READER_ENDPOINT"`
+	RedisClusterEnable    bool   `envar:"REDIS_CLUSTER_ENABLE"`
+	RedisDatabaseNumber   int    `envar:"REDIS_DATABASE_NUMBER;default=0"`
+	TlsEnable             bool   `envar:"REDIS_TLS_ENABLE"`
+	RedisServerName       string `envar:"REDIS_SERVER_NAME"`
+	TlsCAFile             string `envar:"REDIS_TLS_CA_FILE"`
+	TlsCertFile           string `envar:"REDIS_TLS_CERT_FILE"`
+	TlsKeyFile            string `envar:"REDIS_TLS_KEY_FILE"`
+	TlsInsecureSkipVerify bool   `envar:"REDIS_TLS_INSECURE_SKIP_VERIFY"`
+	RedisConfigFile       string `envar:"REDIS_CONFIG_FILE"`
+	RedisProfile          string `envar:"REDIS_PROFILE"`
 }
 
 func PrimaryEndpoint() string {
@@ -70,3 +91,28 @@ func TlsEnable() bool {
 func ServerName() string {
 	return _env.RedisServerName
 }
+
+func TlsCAFile() string {
+	return _env.TlsCAFile
+}
+
+func TlsCertFile() string {
+	return _env.TlsCertFile
+}
+
+func TlsKeyFile() string {
+	return _env.TlsKeyFile
+}
+
+// TlsInsecureSkipVerify 開発環境専用
+func TlsInsecureSkipVerify() bool {
+	return _env.TlsInsecureSkipVerify
+}
+
+func ConfigFile() string {
+	return _env.RedisConfigFile
+}
+
+func Profile() string {
+	return _env.RedisProfile
+}
@@ -0,0 +1,138 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	errPoolTimeout   = "redis: connection pool timeout"
+	errPoolExhausted = "redis: connection pool exhausted"
+)
+
+// serverError Redisサーバーが返したエラーメッセージ
+func serverError(err error) string {
+	var e redis.Error
+	if errors.As(err, &e) {
+		return e.Error()
+	}
+	return ""
+}
+
+func hasPrefix(err error, prefix string) bool {
+	return err != nil && strings.HasPrefix(serverError(err), prefix)
+}
+
+func IsTimeout(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, context.DeadlineExceeded) || IsPoolExhausted(err) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+func IsConnectionRefused(err error) bool {
+	return errors.Is(err, syscall.ECONNREFUSED)
+}
+
+func IsConnectionReset(err error) bool {
+	return errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.EPIPE) ||
+		errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// IsPoolExhausted コネクションプールから接続を取得できない
+func IsPoolExhausted(err error) bool {
+	for ; err != nil; err = errors.Unwrap(err) {
+		if s := err.Error(); s == errPoolTimeout || s == errPoolExhausted {
+			return true
+		}
+	}
+	return false
+}
+
+func IsClosed(err error) bool {
+	return errors.Is(err, redis.ErrClosed)
+}
+
+// IsReadOnly レプリカに書き込もうとした
+func IsReadOnly(err error) bool {
+	return hasPrefix(err, "READONLY ")
+}
+
+func IsMoved(err error) bool {
+	return hasPrefix(err, "MOVED ")
+}
+
+func IsAsk(err error) bool {
+	return hasPrefix(err, "ASK ")
+}
+
+// Redirection MOVED/ASKエラーのスロットと転送先を返す
+func Redirection(err error) (slot int, addr string, ok bool) {
+	if !IsMoved(err) && !IsAsk(err) {
+		return 0, "", false
+	}
+	v := strings.Fields(serverError(err))
+	if len(v) != 3 {
+		return 0, "", false
+	}
+	slot, e := strconv.Atoi(v[1])
+	if e != nil {
+		return 0, "", false
+	}
+	return slot, v[2], true
+}
+
+func IsLoading(err error) bool {
+	return hasPrefix(err, "LOADING ")
+}
+
+func IsOOM(err error) bool {
+	return hasPrefix(err, "OOM ")
+}
+
+func IsNoScript(err error) bool {
+	return hasPrefix(err, "NOSCRIPT ")
+}
+
+func IsTryAgain(err error) bool {
+	return hasPrefix(err, "TRYAGAIN ") || hasPrefix(err, "CLUSTERDOWN ")
+}
+
+// IsUnavailable Redisに接続できない状態のエラーか判定する
+func IsUnavailable(err error) bool {
+	switch {
+	case err == nil:
+		return false
+	case IsCircuitOpen(err), IsTimeout(err), IsConnectionRefused(err), IsConnectionReset(err):
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+// IsRetryable 時間をおいて再実行すれば成功する可能性があるか判定する
+func IsRetryable(err error) bool {
+	switch {
+	case err == nil, IsNil(err), IsCircuitOpen(err), IsClosed(err):
+		return false
+	case errors.Is(err, context.Canceled):
+		return false
+	case IsTimeout(err), IsConnectionRefused(err), IsConnectionReset(err):
+		return true
+	case IsReadOnly(err), IsLoading(err), IsMoved(err), IsAsk(err), IsTryAgain(err):
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
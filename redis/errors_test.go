@@ -0,0 +1,106 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+
+	pkgerrors "github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+type serverErr string
+
+func (e serverErr) Error() string { return string(e) }
+func (e serverErr) RedisError()   {}
+
+func TestClassification(t *testing.T) {
+	readOnly := pkgerrors.WithStack(serverErr("READONLY You can't write against a read only replica."))
+	assert.True(t, IsReadOnly(readOnly))
+	assert.True(t, IsRetryable(readOnly))
+	assert.False(t, IsReadOnly(errors.New("READONLY not a server error")))
+
+	moved := serverErr("MOVED 3999 127.0.0.1:6381")
+	assert.True(t, IsMoved(moved))
+	assert.False(t, IsAsk(moved))
+	slot, addr, ok := Redirection(moved)
+	assert.True(t, ok)
+	assert.Equal(t, 3999, slot)
+	assert.Equal(t, "127.0.0.1:6381", addr)
+	assert.True(t, IsAsk(serverErr("ASK 1 127.0.0.1:6382")))
+
+	assert.True(t, IsLoading(serverErr("LOADING Redis is loading the dataset in memory")))
+	assert.True(t, IsOOM(serverErr("OOM command not allowed when used memory > 'maxmemory'.")))
+	noScript := serverErr("NOSCRIPT No matching script. Please use EVAL.")
+	assert.True(t, IsNoScript(noScript))
+	assert.False(t, IsRetryable(noScript))
+
+	refused := &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}
+	assert.True(t, IsConnectionRefused(refused))
+	assert.True(t, IsUnavailable(refused))
+	assert.True(t, IsRetryable(refused))
+
+	assert.True(t, IsPoolExhausted(pkgerrors.WithStack(errors.New("redis: connection pool timeout"))))
+	assert.True(t, IsTimeout(context.DeadlineExceeded))
+	assert.False(t, IsRetryable(context.Canceled))
+	assert.False(t, IsRetryable(Nil))
+	assert.False(t, IsRetryable(&CircuitOpenError{}))
+}
+
+func TestRetry(t *testing.T) {
+	ctx := context.Background()
+	clock := &testClock{now: time.Now()}
+	SetClock(clock)
+	defer SetClock(nil)
+	start := clock.Now()
+	policy := RetryPolicy{
+		MaxAttempts:    4,
+		InitialBackoff: 100 * time.Millisecond,
+		MaxBackoff:     time.Second,
+		Multiplier:     2,
+	}
+	cnt := 0
+	v, err := Retry(ctx, policy, func(ctx context.Context) (string, error) {
+		if cnt++; cnt < 3 {
+			return "", serverErr("LOADING Redis is loading the dataset in memory")
+		}
+		return "ok", nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, "ok", v)
+	assert.Equal(t, 3, cnt)
+	assert.Equal(t, 300*time.Millisecond, clock.Now().Sub(start))
+
+	cnt = 0
+	_, err = Retry(ctx, policy, func(ctx context.Context) (int, error) {
+		cnt++
+		return 0, serverErr("ERR syntax error")
+	})
+	assert.NotNil(t, err)
+	assert.Equal(t, 1, cnt)
+
+	cnt = 0
+	_, err = Retry(ctx, policy, func(ctx context.Context) (int, error) {
+		cnt++
+		return 0, &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}
+	})
+	assert.True(t, IsConnectionRefused(err))
+	assert.Equal(t, 4, cnt)
+}
+
+func TestRetryPolicy_Backoff(t *testing.T) {
+	policy := RetryPolicy{
+		InitialBackoff: 100 * time.Millisecond,
+		MaxBackoff:     time.Second,
+		Multiplier:     2,
+		Jitter:         0.5,
+	}
+	for i := 0; i < 100; i++ {
+		d := policy.Backoff(2)
+		assert.True(t, d >= 100*time.Millisecond && d <= 300*time.Millisecond, d)
+		assert.True(t, policy.Backoff(10) <= time.Second)
+	}
+}
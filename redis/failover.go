@@ -0,0 +1,136 @@
+package redis
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/goccha/logging/log"
+	"github.com/redis/go-redis/v9"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+const instrumentationName = "github.com/goccha/redis-verse/redis"
+
+// Failover プライマリのフェイルオーバーを検知して再接続する
+type Failover struct {
+	MinInterval time.Duration                        // Refreshの最小間隔
+	OnRefresh   func(ctx context.Context, err error) // Refresh後に呼び出す。errは検知したエラー
+	mu          sync.Mutex
+	refreshedAt time.Time
+	counter     metric.Int64Counter
+}
+
+var failover *Failover
+
+// SetFailover Setup/Refreshで作成するプライマリにフェイルオーバー検知を設定する
+func SetFailover(f *Failover) {
+	if f != nil {
+		counter, err := otel.Meter(instrumentationName).Int64Counter("redis.failover.refresh",
+			metric.WithDescription("The number of client refreshes triggered by READONLY or connection reset errors"))
+		if err != nil {
+			log.Error(context.Background()).Err(err).Send()
+		}
+		f.counter = counter
+	}
+	failover = f
+}
+
+func (f *Failover) minInterval() time.Duration {
+	if f.MinInterval <= 0 {
+		return 5 * time.Second
+	}
+	return f.MinInterval
+}
+
+func (f *Failover) install(primary *PrimaryClient) {
+	primary.Client.AddHook(&failoverHook{failover: f, client: primary.Client})
+}
+
+func (f *Failover) detect(err error) (string, bool) {
+	switch {
+	case IsReadOnly(err):
+		return "readonly", true
+	case IsConnectionReset(err):
+		return "connection_reset", true
+	}
+	return "", false
+}
+
+// refresh 直前にRefreshしていなければ、保存されたBuilderでクライアントを作り直す
+func (f *Failover) refresh(ctx context.Context, reason string, cause error) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	now := Now()
+	if !f.refreshedAt.IsZero() && now.Sub(f.refreshedAt) < f.minInterval() {
+		return false
+	}
+	f.refreshedAt = now
+	err := Refresh(ctx)
+	result := "success"
+	if err != nil {
+		result = "failure"
+		log.Error(ctx).Err(err).Str("reason", reason).Msg("redis: failover refresh failed")
+	} else {
+		log.Warn(ctx).Err(cause).Str("reason", reason).Msg("redis: primary refreshed")
+	}
+	if f.counter != nil {
+		f.counter.Add(ctx, 1, metric.WithAttributes(
+			attribute.String("reason", reason), attribute.String("result", result)))
+	}
+	if f.OnRefresh != nil {
+		f.OnRefresh(ctx, cause)
+	}
+	return err == nil
+}
+
+type failoverHook struct {
+	failover *Failover
+	client   redis.UniversalClient
+}
+
+func (h *failoverHook) recover(ctx context.Context, err error) (redis.UniversalClient, bool) {
+	reason, ok := h.failover.detect(err)
+	if !ok {
+		return nil, false
+	}
+	if Primary() == h.client {
+		h.failover.refresh(ctx, reason, err)
+	}
+	if c := Primary(); c != h.client {
+		return c, true
+	}
+	return nil, false
+}
+
+func (h *failoverHook) DialHook(next redis.DialHook) redis.DialHook {
+	return next
+}
+
+// retryable 再実行しても結果が変わらない場合はtrue。
+// 接続が切断された書き込みはサーバーで実行済みの可能性があるため、READONLYで拒否された場合だけ再実行する
+func retryable(cmd redis.Cmder, err error) bool {
+	return IsReadOnly(err) || isReadCommand(cmd.Name())
+}
+
+func (h *failoverHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		err := next(ctx, cmd)
+		if c, ok := h.recover(ctx, err); ok && retryable(cmd, err) { // 新しいクライアントで1回だけ再実行する
+			return c.Process(ctx, cmd)
+		}
+		return err
+	}
+}
+
+func (h *failoverHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		err := next(ctx, cmds)
+		if err != nil {
+			_, _ = h.recover(ctx, err)
+		}
+		return err
+	}
+}
@@ -0,0 +1,77 @@
+package redis
+
+import (
+	"context"
+	"io"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+// replicaBuilder 初回だけレプリカに接続したクライアントを返す
+type replicaBuilder struct {
+	MemoryBuilder
+	replica MemoryBuilder
+	builds  int
+}
+
+func (b *replicaBuilder) Build(ctx context.Context, db ...int) (*PrimaryClient, *ReaderClient, error) {
+	if b.builds++; b.builds == 1 {
+		w, r, err := b.replica.Build(ctx, db...)
+		if err == nil {
+			b.replica.Server().SetError("READONLY You can't write against a read only replica.")
+		}
+		return w, r, err
+	}
+	return b.MemoryBuilder.Build(ctx, db...)
+}
+
+func TestFailover(t *testing.T) {
+	ctx := context.Background()
+	var refreshed []error
+	SetFailover(&Failover{
+		MinInterval: time.Minute,
+		OnRefresh: func(ctx context.Context, err error) {
+			refreshed = append(refreshed, err)
+		},
+	})
+	defer SetFailover(nil)
+	b := &replicaBuilder{}
+	defer b.Close()
+	defer b.replica.Close()
+	assert.Nil(t, Setup(ctx, b))
+
+	old := Primary()
+	assert.Nil(t, Primary().Set(ctx, "failover://key", "1", time.Minute).Err())
+	assert.NotEqual(t, old, Primary())
+	assert.Equal(t, 2, b.builds)
+	assert.Len(t, refreshed, 1)
+	assert.True(t, IsReadOnly(refreshed[0]))
+	v, err := b.Server().Get("failover://key")
+	assert.Nil(t, err)
+	assert.Equal(t, "1", v)
+
+	err = old.Set(ctx, "failover://key", "2", time.Minute).Err()
+	assert.Nil(t, err)
+	assert.Equal(t, 2, b.builds)
+	assert.Len(t, refreshed, 1)
+
+	reason, ok := (&Failover{}).detect(io.EOF)
+	assert.True(t, ok)
+	assert.Equal(t, "connection_reset", reason)
+	_, ok = (&Failover{}).detect(serverErr("ERR syntax error"))
+	assert.False(t, ok)
+}
+
+func TestFailover_Retryable(t *testing.T) {
+	ctx := context.Background()
+	readOnly := serverErr("READONLY You can't write against a read only replica.")
+	assert.True(t, retryable(redis.NewIntCmd(ctx, "incr", "key"), readOnly))
+	assert.True(t, retryable(redis.NewStringCmd(ctx, "get", "key"), io.EOF))
+	// 実行済みの可能性がある書き込みは再実行しない
+	assert.False(t, retryable(redis.NewIntCmd(ctx, "incr", "key"), io.EOF))
+	assert.False(t, retryable(redis.NewCmd(ctx, "evalsha", "sha", 0), syscall.ECONNRESET))
+}
@@ -13,7 +13,8 @@ func WithLock(ctx context.Context, key string, f func() error, tryMax ...int) er
 	if len(tryMax) > 0 {
 		limit = tryMax[0]
 	}
-	if err := tryLock(ctx, key, limit); err != nil {
+	lockCtx, done := WithBudget(ctx, BudgetLock)
+	if err := done(tryLock(lockCtx, key, limit)); err != nil {
 		return err
 	}
 	defer func() {
@@ -26,6 +27,8 @@ func WithLock(ctx context.Context, key string, f func() error, tryMax ...int) er
 
 var LockTime = 15 * time.Second
 
+const lockInterval = 100 * time.Millisecond
+
 type LockFailure struct{}
 
 func (err *LockFailure) Error() string {
@@ -42,7 +45,10 @@ func tryLock(ctx context.Context, key string, tryMax int) error {
 		} else if ok {
 			return nil
 		}
-		time.Sleep(100 * time.Millisecond)
+		if err := CheckDeadline(ctx, lockInterval); err != nil {
+			return err
+		}
+		Sleep(lockInterval)
 	}
 	return &LockFailure{}
 }
@@ -0,0 +1,184 @@
+package redis
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/goccha/logging/log"
+	"github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// CommandLogger 閾値より遅いコマンドとエラーになったコマンドをログに出力する
+type CommandLogger struct {
+	SlowThreshold time.Duration           // 既定値は100ms。負の場合は遅延を出力しない
+	IgnoreErrors  bool                    // エラーを出力しない
+	Redact        func(key string) string // キーの秘匿化。既定値はRedactHash
+	SampleRate    float64                 // 出力する割合(0〜1)。0の場合はすべて出力する
+	MaxPerSecond  int                     // 1秒あたりの最大出力数。0の場合は制限しない
+	mu            sync.Mutex
+	window        time.Time
+	count         int
+	dropped       int
+}
+
+var commandLogger *CommandLogger
+
+// SetCommandLogger DefaultBuilderで作成するクライアントにコマンドのログ出力を設定する
+func SetCommandLogger(l *CommandLogger) {
+	commandLogger = l
+}
+
+// RedactNone キーをそのまま出力する
+func RedactNone(key string) string {
+	return key
+}
+
+// RedactAll キーを出力しない
+func RedactAll(key string) string {
+	return "[redacted]"
+}
+
+// RedactHash "prefix://"を残し、残りをSHA-256の先頭8バイトに置き換える
+func RedactHash(key string) string {
+	prefix := ""
+	if i := strings.Index(key, "://"); i >= 0 {
+		prefix, key = key[:i+3], key[i+3:]
+	}
+	sum := sha256.Sum256([]byte(key))
+	return prefix + hex.EncodeToString(sum[:8])
+}
+
+func (l *CommandLogger) slowThreshold() time.Duration {
+	if l.SlowThreshold == 0 {
+		return 100 * time.Millisecond
+	}
+	return l.SlowThreshold
+}
+
+func (l *CommandLogger) redact(key string) string {
+	if l.Redact == nil {
+		return RedactHash(key)
+	}
+	return l.Redact(key)
+}
+
+// sample 出力するかを判定する。上限で捨てた数を次の出力に含める
+func (l *CommandLogger) sample() (bool, int) {
+	if l.SampleRate > 0 && l.SampleRate < 1 && rand.Float64() >= l.SampleRate { //nolint:gosec
+		return false, 0
+	}
+	if l.MaxPerSecond <= 0 {
+		return true, 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := Now()
+	if now.Sub(l.window) >= time.Second {
+		l.window, l.count = now, 0
+	}
+	if l.count >= l.MaxPerSecond {
+		l.dropped++
+		return false, 0
+	}
+	l.count++
+	dropped := l.dropped
+	l.dropped = 0
+	return true, dropped
+}
+
+// logCommands SetCommandLoggerが設定されていればフックを追加する
+func logCommands(c redis.UniversalClient, role, endpoint string) {
+	if commandLogger != nil {
+		commandLogger.install(c, role, endpoint)
+	}
+}
+
+func (l *CommandLogger) install(c redis.UniversalClient, role, endpoint string) {
+	c.AddHook(&loggingHook{logger: l, role: role, endpoint: endpoint})
+}
+
+type loggingHook struct {
+	logger   *CommandLogger
+	role     string
+	endpoint string
+}
+
+func (h *loggingHook) DialHook(next redis.DialHook) redis.DialHook {
+	return next
+}
+
+func (h *loggingHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		start := Now()
+		err := next(ctx, cmd)
+		h.log(ctx, Now().Sub(start), cmd.Name(), CommandKeys(cmd), 1, err)
+		return err
+	}
+}
+
+func (h *loggingHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		start := Now()
+		err := next(ctx, cmds)
+		var keys []string
+		name := "pipeline"
+		for _, cmd := range cmds {
+			if e := cmd.Err(); e != nil && !IsNil(e) {
+				name, keys = cmd.Name(), CommandKeys(cmd)
+				if err == nil {
+					err = e
+				}
+				break
+			}
+		}
+		h.log(ctx, Now().Sub(start), name, keys, len(cmds), err)
+		return err
+	}
+}
+
+func (h *loggingHook) log(ctx context.Context, elapsed time.Duration, name string, keys []string, size int, err error) {
+	l := h.logger
+	failed := err != nil && !IsNil(err) && !l.IgnoreErrors
+	slow := l.slowThreshold() > 0 && elapsed >= l.slowThreshold()
+	if !failed && !slow {
+		return
+	}
+	ok, dropped := l.sample()
+	if !ok {
+		return
+	}
+	var event *zerolog.Event
+	if failed {
+		event = log.Error(ctx).Err(err)
+	} else {
+		event = log.Warn(ctx)
+	}
+	event = event.Str("command", name).Str("role", h.role).Str("endpoint", h.endpoint).Dur("elapsed", elapsed)
+	if len(keys) > 0 {
+		event = event.Str("key", l.redact(keys[0]))
+		if len(keys) > 1 {
+			event = event.Int("keys", len(keys))
+		}
+	}
+	if size > 1 {
+		event = event.Int("pipeline", size)
+	}
+	if sc := trace.SpanContextFromContext(ctx); sc.HasTraceID() {
+		event = event.Str("trace_id", sc.TraceID().String())
+	}
+	if dropped > 0 {
+		event = event.Int("dropped", dropped)
+	}
+	if failed {
+		event.Msg("redis: command failed")
+	} else {
+		event.Msg("redis: slow command")
+	}
+}
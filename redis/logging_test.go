@@ -0,0 +1,119 @@
+package redis
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/goccha/logging/log"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func captureLog(t *testing.T) *bytes.Buffer {
+	var buf bytes.Buffer
+	log.SetGlobalOut(&buf)
+	log.SetGlobalErr(&buf)
+	t.Cleanup(func() {
+		log.SetGlobalOut(os.Stdout)
+		log.SetGlobalErr(os.Stderr)
+	})
+	return &buf
+}
+
+func logEntries(t *testing.T, buf *bytes.Buffer) []map[string]interface{} {
+	var entries []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		var e map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &e); err != nil {
+			t.Fatal(err)
+		}
+		if msg, _ := e["message"].(string); strings.HasPrefix(msg, "redis: ") && e["command"] != nil {
+			entries = append(entries, e)
+		}
+	}
+	return entries
+}
+
+func TestCommandLogger(t *testing.T) {
+	ctx := context.Background()
+	SetCommandLogger(&CommandLogger{SlowThreshold: -1, Redact: RedactNone})
+	defer SetCommandLogger(nil)
+	b := &MemoryBuilder{}
+	defer b.Close()
+	assert.Nil(t, Setup(ctx, b))
+	buf := captureLog(t)
+
+	traceID, _ := trace.TraceIDFromHex("0102030405060708090a0b0c0d0e0f10")
+	spanID, _ := trace.SpanIDFromHex("0102030405060708")
+	tctx := trace.ContextWithSpanContext(ctx, trace.NewSpanContext(trace.SpanContextConfig{TraceID: traceID, SpanID: spanID}))
+	assert.Nil(t, Primary().Set(tctx, "logging://key", "1", time.Minute).Err())
+	assert.True(t, IsNil(Primary().Get(ctx, "logging://missing").Err()))
+	assert.Nil(t, Primary().HSet(ctx, "logging://hash", "f", "v").Err())
+	assert.NotNil(t, Primary().Incr(tctx, "logging://hash").Err())
+
+	entries := logEntries(t, buf)
+	if assert.Len(t, entries, 1) {
+		e := entries[0]
+		assert.Equal(t, "redis: command failed", e["message"])
+		assert.Equal(t, "incr", e["command"])
+		assert.Equal(t, "logging://hash", e["key"])
+		assert.Equal(t, RolePrimary, e["role"])
+		assert.Equal(t, b.Server().Addr(), e["endpoint"])
+		assert.Equal(t, traceID.String(), e["trace_id"])
+	}
+}
+
+func TestCommandLogger_Slow(t *testing.T) {
+	ctx := context.Background()
+	clock := &testClock{now: time.Unix(1700000000, 0)}
+	SetClock(clock)
+	defer SetClock(nil)
+	SetCommandLogger(&CommandLogger{SlowThreshold: time.Nanosecond, MaxPerSecond: 2})
+	defer SetCommandLogger(nil)
+	b := &MemoryBuilder{}
+	defer b.Close()
+	assert.Nil(t, Setup(ctx, b))
+	buf := captureLog(t)
+
+	SetClock(&tickClock{testClock: clock, step: time.Millisecond})
+	for i := 0; i < 5; i++ {
+		assert.Nil(t, Primary().Set(ctx, "logging://slow", "1", time.Minute).Err())
+	}
+	clock.Sleep(time.Second)
+	_, err := Primary().Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Get(ctx, "logging://slow")
+		pipe.Get(ctx, "logging://slow")
+		return nil
+	})
+	assert.Nil(t, err)
+
+	entries := logEntries(t, buf)
+	if assert.Len(t, entries, 3) {
+		assert.Equal(t, "redis: slow command", entries[0]["message"])
+		assert.Equal(t, RedactHash("logging://slow"), entries[0]["key"])
+		assert.True(t, strings.HasPrefix(entries[0]["key"].(string), "logging://"))
+		assert.Equal(t, "pipeline", entries[2]["command"])
+		assert.Equal(t, float64(2), entries[2]["pipeline"])
+		assert.Equal(t, float64(3), entries[2]["dropped"])
+	}
+}
+
+// tickClock Nowを呼び出すたびにstepだけ進む
+type tickClock struct {
+	*testClock
+	step time.Duration
+}
+
+func (c *tickClock) Now() time.Time {
+	c.testClock.Sleep(c.step)
+	return c.testClock.Now()
+}
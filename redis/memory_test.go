@@ -0,0 +1,73 @@
+package redis
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+// MemoryBuilder プロセス内で起動するRedis互換サーバーに接続する。
+// redistestはこのパッケージをインポートするため、このパッケージのテストではredistest.MemoryBuilderと同じものをここで定義する
+type MemoryBuilder struct {
+	server *miniredis.Miniredis
+}
+
+func (b *MemoryBuilder) Build(ctx context.Context, db ...int) (primary *PrimaryClient, reader *ReaderClient, err error) {
+	if b.server == nil {
+		if b.server, err = miniredis.Run(); err != nil {
+			return
+		}
+	}
+	builder := &DefaultBuilder{
+		PrimaryHost: b.server.Addr(),
+		ReaderHost:  b.server.Addr(),
+	}
+	return builder.Build(ctx, db...)
+}
+
+func (b *MemoryBuilder) Server() *miniredis.Miniredis {
+	return b.server
+}
+
+func (b *MemoryBuilder) Close() {
+	if b.server != nil {
+		b.server.Close()
+		b.server = nil
+	}
+}
+
+func TestMemoryBuilder_Build(t *testing.T) {
+	ctx := context.Background()
+	b := &MemoryBuilder{}
+	defer b.Close()
+	assert.Nil(t, Setup(ctx, b))
+	assert.Nil(t, WaitForActivation(ctx))
+
+	assert.Nil(t, Primary().Set(ctx, "memory://string", "value", time.Minute).Err())
+	assert.Equal(t, "value", Reader().Get(ctx, "memory://string").Val())
+	b.Server().FastForward(2 * time.Minute)
+	assert.True(t, IsNil(Reader().Get(ctx, "memory://string").Err()))
+
+	assert.Nil(t, Primary().HSet(ctx, "memory://hash", "field", "1").Err())
+	assert.Equal(t, "1", Reader().HGet(ctx, "memory://hash", "field").Val())
+
+	assert.Nil(t, Primary().ZAdd(ctx, "memory://zset", redis.Z{Score: 2, Member: "b"}, redis.Z{Score: 1, Member: "a"}).Err())
+	assert.Equal(t, []string{"a", "b"}, Reader().ZRange(ctx, "memory://zset", 0, -1).Val())
+
+	v, err := Primary().Eval(ctx, "return redis.call('INCRBY', KEYS[1], ARGV[1])", []string{"memory://script"}, 3).Int()
+	assert.Nil(t, err)
+	assert.Equal(t, 3, v)
+
+	err = Universal(ctx, func(ctx context.Context, c redis.Cmdable) error {
+		return c.Set(ctx, "memory://select", "1", 0).Err()
+	}, 1)
+	assert.Nil(t, err)
+	v2, err := b.Server().DB(1).Get("memory://select")
+	assert.Nil(t, err)
+	assert.Equal(t, "1", v2)
+	assert.False(t, b.Server().Exists("memory://select"))
+}
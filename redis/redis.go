@@ -4,8 +4,8 @@ import (
 	"context"
 	"crypto/tls"
 	"errors"
-	"fmt"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/goccha/logging/log"
@@ -19,11 +19,22 @@ const (
 	Nil         = redis.Nil
 )
 
+type Cmdable = redis.Cmdable
+
 func IsNil(err error) bool {
 	return errors.Is(err, redis.Nil)
 }
 
 func Universal(ctx context.Context, f func(ctx context.Context, c redis.Cmdable) error, db ...int) (err error) {
+	if !calls.enter() {
+		return ErrClosed
+	}
+	defer calls.leave()
+	ctx, done := WithBudget(ctx, BudgetWrite)
+	defer func() {
+		err = done(err)
+	}()
+	primary, _ := clients()
 	c := primary.Client
 	cmdable := redis.Cmdable(c)
 	var pipe redis.Pipeliner
@@ -36,8 +47,13 @@ func Universal(ctx context.Context, f func(ctx context.Context, c redis.Cmdable)
 	}
 	defer func() {
 		if pipe != nil {
-			if err = primary.Reset(ctx, pipe); err != nil {
-				_, err = pipe.Exec(ctx)
+			if e := primary.Reset(ctx, pipe); e != nil {
+				pipe.Discard()
+				if err == nil {
+					err = e
+				}
+			} else if _, e = pipe.Exec(ctx); e != nil && err == nil {
+				err = e
 			}
 		}
 	}()
@@ -45,6 +61,15 @@ func Universal(ctx context.Context, f func(ctx context.Context, c redis.Cmdable)
 }
 
 func ReadOnly(ctx context.Context, f func(ctx context.Context, c redis.Cmdable) error, db ...int) (err error) {
+	if !calls.enter() {
+		return ErrClosed
+	}
+	defer calls.leave()
+	ctx, done := WithBudget(ctx, BudgetRead)
+	defer func() {
+		err = done(err)
+	}()
+	_, reader := clients()
 	c := reader.Client
 	cmdable := c
 	var pipe redis.Pipeliner
@@ -56,18 +81,27 @@ func ReadOnly(ctx context.Context, f func(ctx context.Context, c redis.Cmdable)
 		cmdable = pipe
 	}
 	defer func() {
-		if err = reader.Reset(ctx, pipe); err != nil {
-			_, err = pipe.Exec(ctx)
+		if pipe != nil {
+			if e := reader.Reset(ctx, pipe); e != nil {
+				pipe.Discard()
+				if err == nil {
+					err = e
+				}
+			} else if _, e = pipe.Exec(ctx); e != nil && err == nil {
+				err = e
+			}
 		}
 	}()
 	return f(ctx, cmdable)
 }
 
 func Primary() redis.UniversalClient {
-	return primary.Client
+	p, _ := clients()
+	return p.Client
 }
 func Reader() redis.Cmdable {
-	return reader.Client
+	_, r := clients()
+	return r.Client
 }
 
 func Ping(ctx context.Context) error {
@@ -77,13 +111,21 @@ func Ping(ctx context.Context) error {
 	return nil
 }
 
+var mu sync.RWMutex
 var primary *PrimaryClient
 var reader *ReaderClient
 
+func clients() (*PrimaryClient, *ReaderClient) {
+	mu.RLock()
+	defer mu.RUnlock()
+	return primary, reader
+}
+
 var _builder Builder
 
 func Setup(ctx context.Context, b Builder) error {
 	_builder = b
+	calls.reset()
 	return Refresh(ctx, b)
 }
 
@@ -107,6 +149,7 @@ func WaitForActivation(ctx context.Context, waitMax ...int) error {
 	return nil
 }
 
+// Refresh クライアントを作り直す。置き換えたクライアントは保持している呼び出し元があるため閉じず、Shutdownで閉じる
 func Refresh(ctx context.Context, b ...Builder) error {
 	var builder Builder
 	if len(b) > 0 {
@@ -118,9 +161,20 @@ func Refresh(ctx context.Context, b ...Builder) error {
 		if w, r, err := builder.Build(ctx, DatabaseNumber()); err != nil {
 			return err
 		} else {
+			if breaker != nil {
+				breaker.install(w, r)
+			}
+			if failover != nil {
+				failover.install(w)
+			}
+			if valueCodec != nil {
+				valueCodec.install(w, r)
+			}
+			mu.Lock()
+			retire(primary, reader)
 			primary = w
 			reader = r
-
+			mu.Unlock()
 		}
 		return nil
 	}
@@ -131,20 +185,36 @@ type Builder interface {
 	Build(ctx context.Context, db ...int) (*PrimaryClient, *ReaderClient, error)
 }
 
-func getReaderHost(host string) string {
-	if host == DefaultHost {
-		return host
-	}
-	index := strings.Index(host, ".")
-	groupID := host[0:index]
-	return fmt.Sprintf("%s-ro%s", groupID, host[index:])
-}
-
 type DefaultBuilder struct {
 	ClusterEnable bool
 	PrimaryHost   string
 	ReaderHost    string
 	TlsConfig     *tls.Config
+	Username      string
+	Password      string
+	Sentinel      *SentinelOptions
+	Pool          PoolOptions
+	Discovery     *Discovery
+	serverNames   map[string]string
+}
+
+type SentinelOptions struct {
+	MasterName string
+	Addrs      []string
+	Username   string
+	Password   string
+}
+
+// PoolOptions 接続プールの設定。ゼロ値の場合はgo-redisの既定値を使用する
+type PoolOptions struct {
+	PoolSize        int
+	MinIdleConns    int
+	MaxIdleConns    int
+	PoolTimeout     time.Duration
+	ConnMaxIdleTime time.Duration
+	DialTimeout     time.Duration
+	ReadTimeout     time.Duration
+	WriteTimeout    time.Duration
 }
 
 type PrimaryClient struct {
@@ -171,43 +241,99 @@ func (c ReaderClient) Reset(ctx context.Context, cmdable redis.StatefulCmdable)
 	return nil
 }
 
+func (b *DefaultBuilder) options(addr string, db int) *redis.Options {
+	return &redis.Options{
+		Addr:                  addr,
+		DB:                    db,
+		Username:              b.Username,
+		Password:              b.Password,
+		TLSConfig:             b.tlsConfig(addr),
+		PoolSize:              b.Pool.PoolSize,
+		MinIdleConns:          b.Pool.MinIdleConns,
+		MaxIdleConns:          b.Pool.MaxIdleConns,
+		PoolTimeout:           b.Pool.PoolTimeout,
+		ConnMaxIdleTime:       b.Pool.ConnMaxIdleTime,
+		DialTimeout:           b.Pool.DialTimeout,
+		ReadTimeout:           b.Pool.ReadTimeout,
+		WriteTimeout:          b.Pool.WriteTimeout,
+		ContextTimeoutEnabled: true,
+	}
+}
+
+func (b *DefaultBuilder) failoverOptions(db int, replicaOnly bool) *redis.FailoverOptions {
+	return &redis.FailoverOptions{
+		MasterName:            b.Sentinel.MasterName,
+		SentinelAddrs:         b.Sentinel.Addrs,
+		SentinelUsername:      b.Sentinel.Username,
+		SentinelPassword:      b.Sentinel.Password,
+		ReplicaOnly:           replicaOnly,
+		DB:                    db,
+		Username:              b.Username,
+		Password:              b.Password,
+		TLSConfig:             b.TlsConfig,
+		PoolSize:              b.Pool.PoolSize,
+		MinIdleConns:          b.Pool.MinIdleConns,
+		MaxIdleConns:          b.Pool.MaxIdleConns,
+		PoolTimeout:           b.Pool.PoolTimeout,
+		ConnMaxIdleTime:       b.Pool.ConnMaxIdleTime,
+		DialTimeout:           b.Pool.DialTimeout,
+		ReadTimeout:           b.Pool.ReadTimeout,
+		WriteTimeout:          b.Pool.WriteTimeout,
+		ContextTimeoutEnabled: true,
+	}
+}
+
 func (b *DefaultBuilder) Build(ctx context.Context, db ...int) (primary *PrimaryClient, reader *ReaderClient, err error) {
+	d := 0
+	if len(db) > 0 {
+		d = db[0]
+	}
+	if b.Sentinel != nil {
+		return b.buildSentinel(ctx, d)
+	}
+	if b.Discovery != nil {
+		return b.Discovery.build(ctx, b, db...)
+	}
 	if b.ClusterEnable {
 		c := redis.NewClusterClient(&redis.ClusterOptions{
-			Addrs: []string{b.PrimaryHost},
+			Addrs:                 []string{b.PrimaryHost},
+			Username:              b.Username,
+			Password:              b.Password,
+			TLSConfig:             b.tlsConfig(b.PrimaryHost),
+			PoolSize:              b.Pool.PoolSize,
+			MinIdleConns:          b.Pool.MinIdleConns,
+			MaxIdleConns:          b.Pool.MaxIdleConns,
+			PoolTimeout:           b.Pool.PoolTimeout,
+			ConnMaxIdleTime:       b.Pool.ConnMaxIdleTime,
+			DialTimeout:           b.Pool.DialTimeout,
+			ReadTimeout:           b.Pool.ReadTimeout,
+			WriteTimeout:          b.Pool.WriteTimeout,
+			ContextTimeoutEnabled: true,
 		})
 		host, port := splitEndpoint(b.PrimaryHost)
 		if err = redisotel.InstrumentTracing(c, redisotel.WithAttributes(semconv.NetPeerNameKey.String(host), semconv.NetPeerPortKey.String(port))); err != nil {
 			return
 		}
+		logCommands(c, RolePrimary, b.PrimaryHost)
 		primary = &PrimaryClient{Client: c, Db: -1}
 		log.Info(ctx).Str("primary_endpoint", b.PrimaryHost).Send()
 		reader = &ReaderClient{Client: c, Db: -1}
 	} else {
-		d := 0
-		if len(db) > 0 {
-			d = db[0]
-		}
-		c := redis.NewClient(&redis.Options{
-			Addr:      b.PrimaryHost,
-			DB:        d,
-			TLSConfig: b.TlsConfig,
-		})
+		c := redis.NewClient(b.options(b.PrimaryHost, d))
 		host, port := splitEndpoint(b.PrimaryHost)
 		if err = redisotel.InstrumentTracing(c, redisotel.WithAttributes(semconv.NetPeerNameKey.String(host), semconv.NetPeerPortKey.String(port))); err != nil {
 			return
 		}
+		logCommands(c, RolePrimary, b.PrimaryHost)
 		primary = &PrimaryClient{Client: c, Db: d}
 		log.Info(ctx).Str("primary_endpoint", b.PrimaryHost).Send()
 		if b.PrimaryHost != b.ReaderHost {
-			c = redis.NewClient(&redis.Options{
-				Addr: b.ReaderHost,
-				DB:   d,
-			})
+			c = redis.NewClient(b.options(b.ReaderHost, d))
 			host, port = splitEndpoint(b.ReaderHost)
 			if err = redisotel.InstrumentTracing(c, redisotel.WithAttributes(semconv.NetPeerNameKey.String(host), semconv.NetPeerPortKey.String(port))); err != nil {
 				return
 			}
+			logCommands(c, RoleReader, b.ReaderHost)
 			reader = &ReaderClient{
 				Client: c,
 				Db:     d,
@@ -223,6 +349,23 @@ func (b *DefaultBuilder) Build(ctx context.Context, db ...int) (primary *Primary
 	return
 }
 
+func (b *DefaultBuilder) buildSentinel(ctx context.Context, db int) (primary *PrimaryClient, reader *ReaderClient, err error) {
+	c := redis.NewFailoverClient(b.failoverOptions(db, false))
+	if err = redisotel.InstrumentTracing(c, redisotel.WithAttributes(semconv.DBRedisDBIndexKey.Int(db))); err != nil {
+		return
+	}
+	logCommands(c, RolePrimary, b.Sentinel.MasterName)
+	primary = &PrimaryClient{Client: c, Db: db}
+	log.Info(ctx).Str("sentinel_master", b.Sentinel.MasterName).Strs("sentinel_addrs", b.Sentinel.Addrs).Send()
+	r := redis.NewFailoverClient(b.failoverOptions(db, true))
+	if err = redisotel.InstrumentTracing(r, redisotel.WithAttributes(semconv.DBRedisDBIndexKey.Int(db))); err != nil {
+		return
+	}
+	logCommands(r, RoleReader, b.Sentinel.MasterName)
+	reader = &ReaderClient{Client: r, Db: db}
+	return
+}
+
 func splitEndpoint(endpoint string) (host, port string) {
 	v := strings.Split(endpoint, ":")
 	switch len(v) {
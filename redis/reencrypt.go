@@ -0,0 +1,381 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/goccha/logging/log"
+	"github.com/redis/go-redis/v9"
+)
+
+// replaceString 値が読み込んだときのままの場合だけ有効期限を維持して置き換える
+var replaceString = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	redis.call('SET', KEYS[1], ARGV[2], 'KEEPTTL')
+	return 1
+end
+return 0`)
+
+// replaceField ハッシュのフィールドが読み込んだときのままの場合だけ置き換える
+var replaceField = redis.NewScript(`
+if redis.call('HGET', KEYS[1], ARGV[1]) == ARGV[2] then
+	redis.call('HSET', KEYS[1], ARGV[1], ARGV[3])
+	return 1
+end
+return 0`)
+
+// replaceChunks 目録と分割した値が読み込んだときのままの場合だけ、有効期限を維持して分割した値を置き換える。
+// KEYS: 元のキー, 分割キー... ARGV: 読み込んだ目録, 新しい目録, 読み込んだ分割数, 新しい分割数, 読み込んだ値..., 新しい値...
+var replaceChunks = redis.NewScript(`
+local old, new = tonumber(ARGV[3]), tonumber(ARGV[4])
+if redis.call('GET', KEYS[1]) ~= ARGV[1] then
+	return 0
+end
+for i = 1, old do
+	if redis.call('GET', KEYS[i + 1]) ~= ARGV[4 + i] then
+		return 0
+	end
+end
+local ttl = redis.call('PTTL', KEYS[1])
+if ttl <= 0 then
+	return 0
+end
+redis.call('SET', KEYS[1], ARGV[2], 'KEEPTTL')
+for i = 1, new do
+	redis.call('SET', KEYS[i + 1], ARGV[4 + old + i], 'PX', ttl)
+end
+for i = new + 1, old do
+	redis.call('DEL', KEYS[i + 1])
+end
+return 1`)
+
+// maxReEncryptErrors ReEncryptResultに保持するエラーの数
+const maxReEncryptErrors = 10
+
+// ReEncryptResult Runの結果
+type ReEncryptResult struct {
+	Replaced int64   // 暗号化し直した値の数
+	Failed   int64   // 暗号化し直せなかったキーの数
+	Errors   []error // 失敗したキーのエラー。最大maxReEncryptErrors件
+}
+
+func (r *ReEncryptResult) fail(key string, err error) {
+	r.Failed++
+	if len(r.Errors) < maxReEncryptErrors {
+		r.Errors = append(r.Errors, fmt.Errorf("%s: %w", key, err))
+	}
+}
+
+func (r *ReEncryptResult) add(o *ReEncryptResult) {
+	r.Replaced += o.Replaced
+	r.Failed += o.Failed
+	for _, err := range o.Errors {
+		if len(r.Errors) >= maxReEncryptErrors {
+			break
+		}
+		r.Errors = append(r.Errors, err)
+	}
+}
+
+// ReEncrypter パターンに一致する文字列とハッシュの値をSCANで走査し、現在の鍵で暗号化し直す。
+// 圧縮や分割した値の中で暗号化されている場合も暗号化し直す
+type ReEncrypter struct {
+	Pattern    string
+	Encryption *Encryption
+	Interval   time.Duration // Startで繰り返す間隔。既定値は1時間
+	Options    BulkOptions
+	mu         sync.Mutex
+	stop       chan struct{}
+}
+
+func (r *ReEncrypter) interval() time.Duration {
+	if r.Interval <= 0 {
+		return time.Hour
+	}
+	return r.Interval
+}
+
+// Run 1回走査して暗号化し直す。
+// 走査中に更新された値は置き換えない。復号できない値などキーごとの失敗は結果に数えて走査を続け、接続のエラーの場合は中断する
+func (r *ReEncrypter) Run(ctx context.Context) (ReEncryptResult, error) {
+	o := bulkOptions([]BulkOptions{r.Options})
+	p := &pacer{rate: o.KeysPerSecond}
+	var mu sync.Mutex
+	var total ReEncryptResult
+	ctx = WithoutCodec(ctx)
+	err := scanBatches(ctx, r.Pattern, o.ScanCount, func(ctx context.Context, c redis.UniversalClient, keys []string) error {
+		for len(keys) > 0 {
+			n := len(keys)
+			if n > o.BatchSize {
+				n = o.BatchSize
+			}
+			batch := keys[:n]
+			keys = keys[n:]
+			if err := p.wait(ctx, n); err != nil {
+				return err
+			}
+			res, err := r.batch(ctx, c, batch)
+			mu.Lock()
+			total.add(&res)
+			mu.Unlock()
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return total, err
+}
+
+// fatal 走査を中断するエラー
+func fatal(err error) bool {
+	return IsUnavailable(err) || IsClosed(err) || errors.Is(err, context.Canceled)
+}
+
+func (r *ReEncrypter) batch(ctx context.Context, c redis.UniversalClient, keys []string) (res ReEncryptResult, err error) {
+	types := make([]*redis.StatusCmd, len(keys))
+	if _, err = c.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, key := range keys {
+			types[i] = pipe.Type(ctx, key)
+		}
+		return nil
+	}); fatal(err) {
+		return res, err
+	}
+	values := make([]redis.Cmder, len(keys))
+	if _, err = c.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, key := range keys {
+			if isChunkKey(key) {
+				continue // 元のキーと一緒に暗号化し直す
+			}
+			if types[i].Err() != nil {
+				res.fail(key, types[i].Err())
+				continue
+			}
+			switch types[i].Val() {
+			case "string":
+				values[i] = pipe.Get(ctx, key)
+			case "hash":
+				values[i] = pipe.HGetAll(ctx, key)
+			}
+		}
+		return nil
+	}); fatal(err) {
+		return res, err
+	}
+	current := r.Encryption.Keyring.Current()
+	var replaced []*redis.Cmd
+	var replacedKeys []string
+	var chunked []int
+	_, err = c.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, key := range keys {
+			if values[i] == nil {
+				continue
+			}
+			if err := values[i].Err(); err != nil {
+				if !IsNil(err) {
+					res.fail(key, err)
+				}
+				continue
+			}
+			switch cmd := values[i].(type) {
+			case *redis.StringCmd:
+				if strings.HasPrefix(cmd.Val(), manifestHeader) {
+					chunked = append(chunked, i)
+					continue
+				}
+				v, ok, err := r.reEncrypt(cmd.Val(), current)
+				if err != nil {
+					res.fail(key, err)
+				} else if ok {
+					replaced = append(replaced, replaceString.Eval(ctx, pipe, []string{key}, cmd.Val(), v))
+					replacedKeys = append(replacedKeys, key)
+				}
+			case *redis.MapStringStringCmd:
+				for field, value := range cmd.Val() {
+					v, ok, err := r.reEncrypt(value, current)
+					if err != nil {
+						res.fail(key, err)
+						break
+					}
+					if ok {
+						replaced = append(replaced, replaceField.Eval(ctx, pipe, []string{key}, field, value, v))
+						replacedKeys = append(replacedKeys, key)
+					}
+				}
+			}
+		}
+		return nil
+	})
+	if fatal(err) {
+		return res, err
+	}
+	for i, cmd := range replaced {
+		if n, err := cmd.Int64(); err != nil {
+			res.fail(replacedKeys[i], err)
+		} else if n > 0 {
+			res.Replaced += n
+		}
+	}
+	for _, i := range chunked {
+		ok, err := r.reEncryptChunks(ctx, c, keys[i], values[i].(*redis.StringCmd).Val(), current)
+		if fatal(err) {
+			return res, err
+		} else if err != nil {
+			res.fail(keys[i], err)
+		} else if ok {
+			res.Replaced++
+		}
+	}
+	return res, nil
+}
+
+// reEncryptChunks 分割した値を結合して暗号化し直し、同じ大きさで分割し直して保存する
+func (r *ReEncrypter) reEncryptChunks(ctx context.Context, c redis.UniversalClient, key, m, current string) (bool, error) {
+	n, _, ok, err := parseManifest(m)
+	if err != nil || !ok {
+		return false, err
+	}
+	values, err := c.MGet(ctx, append([]string{key}, chunkKeys(key, n)...)...).Result()
+	if err != nil {
+		return false, err
+	}
+	if values[0] != m {
+		return false, nil // 読み込む間に置き換えられた
+	}
+	chunks := make([]interface{}, 0, n)
+	var b strings.Builder
+	for j, v := range values[1:] {
+		s, ok := v.(string)
+		if !ok {
+			return false, &ChunkError{Key: key, Chunk: j + 1}
+		}
+		chunks = append(chunks, s)
+		b.WriteString(s)
+	}
+	v, ok, err := r.reEncrypt(b.String(), current)
+	if err != nil || !ok {
+		return false, err
+	}
+	size := len(chunks[0].(string))
+	var split []interface{}
+	for i := 0; i < len(v); i += size {
+		end := i + size
+		if end > len(v) {
+			end = len(v)
+		}
+		split = append(split, v[i:end])
+	}
+	keys := chunkKeys(key, n)
+	if len(split) > n {
+		keys = chunkKeys(key, len(split))
+	}
+	args := make([]interface{}, 0, 4+len(chunks)+len(split))
+	args = append(args, m, manifest(len(split), len(v)), n, len(split))
+	args = append(args, chunks...)
+	args = append(args, split...)
+	replaced, err := replaceChunks.Run(ctx, c, append([]string{key}, keys...), args...).Int64()
+	return replaced > 0, err
+}
+
+// reEncrypt 現在の鍵以外で暗号化されている値を暗号化し直す。
+// 暗号化した値を圧縮している場合は展開してから暗号化し直し、同じアルゴリズムで圧縮し直す
+func (r *ReEncrypter) reEncrypt(value, current string) (string, bool, error) {
+	b := []byte(value)
+	var algorithms []Algorithm
+	for {
+		body, ok, err := parseHeader(b, FormatCompression)
+		if err != nil {
+			return "", false, err
+		} else if !ok || len(body) == 0 {
+			break
+		}
+		algorithms = append(algorithms, Algorithm(body[0]))
+		if b, err = (&Compression{}).Decode(b); err != nil {
+			return "", false, err
+		}
+	}
+	id, ok := r.Encryption.KeyID(b)
+	if !ok || id == current {
+		return "", false, nil
+	}
+	plain, err := r.Encryption.Decode(b)
+	if err != nil {
+		return "", false, err
+	}
+	if b, err = r.Encryption.Encode(plain); err != nil {
+		return "", false, err
+	}
+	for i := len(algorithms) - 1; i >= 0; i-- {
+		if b, err = compress(algorithms[i], b); err != nil {
+			return "", false, err
+		}
+	}
+	return string(b), true, nil
+}
+
+// compress 読み込んだ値と同じアルゴリズムで圧縮する
+func compress(a Algorithm, value []byte) ([]byte, error) {
+	if a == uncompressed {
+		return frame(uncompressed, value), nil
+	}
+	return (&Compression{Algorithm: a, Threshold: 1}).Encode(value)
+}
+
+// Start バックグラウンドでIntervalごとにRunを実行する。Shutdownで停止する
+func (r *ReEncrypter) Start(ctx context.Context) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.stop != nil {
+		return
+	}
+	r.stop = make(chan struct{})
+	go r.loop(ctx, r.stop)
+	Register(ComponentFunc(r.Stop))
+}
+
+func (r *ReEncrypter) loop(ctx context.Context, stop chan struct{}) {
+	ticker := time.NewTicker(r.interval())
+	defer ticker.Stop()
+	for {
+		r.run(ctx)
+		select {
+		case <-stop:
+			return
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (r *ReEncrypter) run(ctx context.Context) {
+	res, err := r.Run(ctx)
+	if err != nil {
+		log.Error(ctx).Err(err).Str("pattern", r.Pattern).Msg("redis: failed to re-encrypt values")
+	}
+	if res.Failed > 0 {
+		for _, e := range res.Errors {
+			log.Warn(ctx).Err(e).Str("pattern", r.Pattern).Msg("redis: failed to re-encrypt a value")
+		}
+		log.Warn(ctx).Str("pattern", r.Pattern).Int64("failed", res.Failed).Msg("redis: some values were not re-encrypted")
+	}
+	if res.Replaced > 0 {
+		log.Info(ctx).Str("pattern", r.Pattern).Int64("count", res.Replaced).Str("key_id", r.Encryption.Keyring.Current()).Msg("redis: re-encrypted values")
+	}
+}
+
+// Stop 再暗号化を停止する
+func (r *ReEncrypter) Stop(ctx context.Context) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.stop != nil {
+		close(r.stop)
+		r.stop = nil
+	}
+	return nil
+}
@@ -0,0 +1,143 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ReaderNotFoundError リーダーのエンドポイントを解決できなかった
+type ReaderNotFoundError struct {
+	Primary string
+	Err     error
+}
+
+func (err *ReaderNotFoundError) Error() string {
+	if err.Err != nil {
+		return fmt.Sprintf("redis: reader endpoint not found for %s: %v", err.Primary, err.Err)
+	}
+	return fmt.Sprintf("redis: reader endpoint not found for %s", err.Primary)
+}
+
+func (err *ReaderNotFoundError) Unwrap() error {
+	return err.Err
+}
+
+func IsReaderNotFound(err error) bool {
+	var e *ReaderNotFoundError
+	return errors.As(err, &e)
+}
+
+// Resolver DNSの名前解決。*net.Resolverを満たす
+type Resolver interface {
+	LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error)
+	LookupHost(ctx context.Context, host string) ([]string, error)
+}
+
+// ReaderResolver プライマリのエンドポイントからリーダーのエンドポイントを解決する
+type ReaderResolver interface {
+	Resolve(ctx context.Context, primary string) (string, error)
+}
+
+type ReaderResolverFunc func(ctx context.Context, primary string) (string, error)
+
+func (f ReaderResolverFunc) Resolve(ctx context.Context, primary string) (string, error) {
+	return f(ctx, primary)
+}
+
+// ElastiCacheReader ElastiCacheの命名規則(<group-id>.xxx -> <group-id>-ro.xxx)でリーダーを解決する
+type ElastiCacheReader struct{}
+
+func (ElastiCacheReader) Resolve(ctx context.Context, primary string) (string, error) {
+	if primary == DefaultHost {
+		return primary, nil
+	}
+	index := strings.Index(primary, ".")
+	if index <= 0 {
+		return "", &ReaderNotFoundError{Primary: primary, Err: errors.New("not an ElastiCache endpoint")}
+	}
+	return fmt.Sprintf("%s-ro%s", primary[0:index], primary[index:]), nil
+}
+
+// SamePrimary プライマリをリーダーとして使用する
+type SamePrimary struct{}
+
+func (SamePrimary) Resolve(ctx context.Context, primary string) (string, error) {
+	if primary == "" {
+		return "", &ReaderNotFoundError{Primary: primary}
+	}
+	return primary, nil
+}
+
+// ReaderList 指定されたエンドポイントのうち、接続できる最初のものを使用する
+type ReaderList struct {
+	Endpoints   []string
+	DialTimeout time.Duration // 接続確認のタイムアウト。既定値は1秒
+	Dialer      func(ctx context.Context, network, addr string) (net.Conn, error)
+}
+
+func (r *ReaderList) dial(ctx context.Context, addr string) error {
+	timeout := r.DialTimeout
+	if timeout <= 0 {
+		timeout = time.Second
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	dial := r.Dialer
+	if dial == nil {
+		dial = (&net.Dialer{}).DialContext
+	}
+	conn, err := dial(ctx, "tcp", addr)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+func (r *ReaderList) Resolve(ctx context.Context, primary string) (string, error) {
+	var last error
+	for _, endpoint := range r.Endpoints {
+		if endpoint == "" {
+			continue
+		}
+		if last = r.dial(ctx, endpoint); last == nil {
+			return endpoint, nil
+		}
+	}
+	return "", &ReaderNotFoundError{Primary: primary, Err: last}
+}
+
+// SRVReader DNSのSRVレコードからリーダーを解決する。優先度が最も高いレコードを使用する
+type SRVReader struct {
+	Service  string // 例: "redis"
+	Proto    string // 既定値は"tcp"
+	Name     string // 例: "reader.example.com"
+	Resolver Resolver
+}
+
+func (r *SRVReader) Resolve(ctx context.Context, primary string) (string, error) {
+	resolver := r.Resolver
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+	proto := r.Proto
+	if proto == "" {
+		proto = "tcp"
+	}
+	_, records, err := resolver.LookupSRV(ctx, r.Service, proto, r.Name)
+	if err != nil {
+		return "", &ReaderNotFoundError{Primary: primary, Err: err}
+	}
+	if len(records) == 0 {
+		return "", &ReaderNotFoundError{Primary: primary}
+	}
+	return srvEndpoint(records[0]), nil
+}
+
+func srvEndpoint(srv *net.SRV) string {
+	return net.JoinHostPort(strings.TrimSuffix(srv.Target, "."), strconv.Itoa(int(srv.Port)))
+}
@@ -0,0 +1,111 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeResolver テスト用のDNS
+type fakeResolver struct {
+	mu    sync.Mutex
+	srv   map[string][]*net.SRV
+	hosts map[string][]string
+	err   error
+}
+
+func (r *fakeResolver) LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err != nil {
+		return "", nil, r.err
+	}
+	cname := name
+	if service != "" || proto != "" {
+		cname = "_" + service + "._" + proto + "." + name
+	}
+	return cname, r.srv[cname], nil
+}
+
+func (r *fakeResolver) LookupHost(ctx context.Context, host string) ([]string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err != nil {
+		return nil, r.err
+	}
+	return r.hosts[host], nil
+}
+
+func TestElastiCacheReader(t *testing.T) {
+	ctx := context.Background()
+	v, err := ElastiCacheReader{}.Resolve(ctx, "group.abc123.cache.amazonaws.com:6379")
+	assert.Nil(t, err)
+	assert.Equal(t, "group-ro.abc123.cache.amazonaws.com:6379", v)
+	v, err = ElastiCacheReader{}.Resolve(ctx, DefaultHost)
+	assert.Nil(t, err)
+	assert.Equal(t, DefaultHost, v)
+	_, err = ElastiCacheReader{}.Resolve(ctx, "redis:6379")
+	assert.True(t, IsReaderNotFound(err))
+}
+
+func TestSamePrimary(t *testing.T) {
+	v, err := SamePrimary{}.Resolve(context.Background(), "redis:6379")
+	assert.Nil(t, err)
+	assert.Equal(t, "redis:6379", v)
+}
+
+func TestReaderList(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed.Close()
+
+	r := &ReaderList{Endpoints: []string{closed.Addr().String(), ln.Addr().String()}}
+	v, err := r.Resolve(context.Background(), "redis:6379")
+	assert.Nil(t, err)
+	assert.Equal(t, ln.Addr().String(), v)
+
+	r = &ReaderList{Endpoints: []string{closed.Addr().String()}}
+	_, err = r.Resolve(context.Background(), "redis:6379")
+	assert.True(t, IsReaderNotFound(err))
+	assert.True(t, IsConnectionRefused(err), err)
+}
+
+func TestSRVReader(t *testing.T) {
+	resolver := &fakeResolver{srv: map[string][]*net.SRV{
+		"_redis._tcp.reader.example.com": {
+			{Target: "replica-1.example.com.", Port: 6380, Priority: 10},
+			{Target: "replica-2.example.com.", Port: 6380, Priority: 20},
+		},
+	}}
+	r := &SRVReader{Service: "redis", Name: "reader.example.com", Resolver: resolver}
+	v, err := r.Resolve(context.Background(), "redis:6379")
+	assert.Nil(t, err)
+	assert.Equal(t, "replica-1.example.com:6380", v)
+
+	r.Name = "missing.example.com"
+	_, err = r.Resolve(context.Background(), "redis:6379")
+	assert.True(t, IsReaderNotFound(err))
+
+	resolver.err = errors.New("no such host")
+	_, err = r.Resolve(context.Background(), "redis:6379")
+	assert.True(t, IsReaderNotFound(err))
+}
+
+func TestEnvBuilder_Reader(t *testing.T) {
+	primary := _env.RedisPrimaryEndpoint
+	_env.RedisPrimaryEndpoint = "redis:6379"
+	defer func() { _env.RedisPrimaryEndpoint = primary }()
+	_, _, err := (&EnvBuilder{}).Build(context.Background())
+	assert.True(t, IsReaderNotFound(err))
+}
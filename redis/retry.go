@@ -0,0 +1,89 @@
+package redis
+
+import (
+	"context"
+	"math/rand"
+	"time"
+)
+
+// RetryPolicy 再実行の間隔と回数
+type RetryPolicy struct {
+	MaxAttempts    int              // 最大実行回数
+	InitialBackoff time.Duration    // 初回の待機時間
+	MaxBackoff     time.Duration    // 最大の待機時間
+	Multiplier     float64          // 待機時間の増加率
+	Jitter         float64          // 待機時間のゆらぎ(0〜1)
+	Retryable      func(error) bool // 再実行するエラーの判定。未指定の場合はIsRetryable
+}
+
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:    3,
+	InitialBackoff: 50 * time.Millisecond,
+	MaxBackoff:     2 * time.Second,
+	Multiplier:     2,
+	Jitter:         0.2,
+}
+
+func (p RetryPolicy) retryable(err error) bool {
+	if p.Retryable != nil {
+		return p.Retryable(err)
+	}
+	return IsRetryable(err)
+}
+
+// Backoff n回目の失敗後の待機時間
+func (p RetryPolicy) Backoff(n int) time.Duration {
+	d := float64(p.InitialBackoff)
+	multiplier := p.Multiplier
+	if multiplier < 1 {
+		multiplier = 1
+	}
+	for i := 1; i < n; i++ {
+		d *= multiplier
+		if p.MaxBackoff > 0 && d >= float64(p.MaxBackoff) {
+			d = float64(p.MaxBackoff)
+			break
+		}
+	}
+	if p.Jitter > 0 {
+		d += d * p.Jitter * (rand.Float64()*2 - 1)
+	}
+	if p.MaxBackoff > 0 && d > float64(p.MaxBackoff) {
+		d = float64(p.MaxBackoff)
+	}
+	if d < 0 {
+		d = 0
+	}
+	return time.Duration(d)
+}
+
+// Retry 再実行可能なエラーの間、指数バックオフでfを再実行する
+func Retry[T any](ctx context.Context, policy RetryPolicy, fn func(ctx context.Context) (T, error)) (result T, err error) {
+	attempts := policy.MaxAttempts
+	if attempts <= 0 {
+		attempts = 1
+	}
+	for i := 1; ; i++ {
+		if result, err = fn(ctx); err == nil || i >= attempts || !policy.retryable(err) {
+			return
+		}
+		if e := wait(ctx, policy.Backoff(i)); e != nil {
+			return result, err
+		}
+	}
+}
+
+func wait(ctx context.Context, d time.Duration) error {
+	if _, ok := currentClock().(systemClock); !ok {
+		Sleep(d)
+		return ctx.Err()
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
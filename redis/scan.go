@@ -0,0 +1,168 @@
+package redis
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// BulkOptions パターン指定の一括操作の設定
+type BulkOptions struct {
+	BatchSize     int   // 1回のパイプラインで処理するキーの数。既定値は100
+	KeysPerSecond int   // 1秒あたりに処理するキーの上限。0の場合は制限しない
+	ScanCount     int64 // SCANのCOUNT。既定値はBatchSize
+}
+
+func bulkOptions(opts []BulkOptions) BulkOptions {
+	var o BulkOptions
+	if len(opts) > 0 {
+		o = opts[0]
+	}
+	if o.BatchSize <= 0 {
+		o.BatchSize = 100
+	}
+	if o.ScanCount <= 0 {
+		o.ScanCount = int64(o.BatchSize)
+	}
+	return o
+}
+
+// forEachMaster クラスターの場合はすべてのマスターで、それ以外はプライマリでfを実行する
+func forEachMaster(ctx context.Context, f func(ctx context.Context, c redis.UniversalClient) error) error {
+	if !calls.enter() {
+		return ErrClosed
+	}
+	defer calls.leave()
+	primary, _ := clients()
+	if cluster, ok := primary.Client.(*redis.ClusterClient); ok {
+		return cluster.ForEachMaster(ctx, func(ctx context.Context, c *redis.Client) error {
+			return f(ctx, c)
+		})
+	}
+	return f(ctx, primary.Client)
+}
+
+// scanBatches パターンに一致するキーをノードごとにcount件ずつ取得してfに渡す
+func scanBatches(ctx context.Context, pattern string, count int64, f func(ctx context.Context, c redis.UniversalClient, keys []string) error) error {
+	return forEachMaster(ctx, func(ctx context.Context, c redis.UniversalClient) error {
+		var cursor uint64
+		for {
+			keys, next, err := c.Scan(ctx, cursor, pattern, count).Result()
+			if err != nil {
+				return err
+			}
+			if len(keys) > 0 {
+				if err = f(ctx, c, keys); err != nil {
+					return err
+				}
+			}
+			if cursor = next; cursor == 0 {
+				return nil
+			}
+		}
+	})
+}
+
+// ScanKeys パターンに一致するキーごとにfnを呼び出す。
+// クラスターの場合はマスターごとに並行して呼び出されるため、fnは並行実行に対応する必要がある。
+// SCANの性質上、走査中に追加・削除されたキーは含まれないことがあり、同じキーが複数回渡されることもある
+func ScanKeys(ctx context.Context, pattern string, fn func(ctx context.Context, key string) error) error {
+	return scanBatches(ctx, pattern, 100, func(ctx context.Context, c redis.UniversalClient, keys []string) error {
+		for _, key := range keys {
+			if err := fn(ctx, key); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// DeleteByPattern パターンに一致するキーを削除し、削除した数を返す
+func DeleteByPattern(ctx context.Context, pattern string, opts ...BulkOptions) (int64, error) {
+	return bulk(ctx, pattern, bulkOptions(opts), func(ctx context.Context, pipe redis.Pipeliner, key string) {
+		pipe.Unlink(ctx, key)
+	})
+}
+
+// ExpireByPattern パターンに一致するキーに有効期限を設定し、設定した数を返す
+func ExpireByPattern(ctx context.Context, pattern string, expiration time.Duration, opts ...BulkOptions) (int64, error) {
+	return bulk(ctx, pattern, bulkOptions(opts), func(ctx context.Context, pipe redis.Pipeliner, key string) {
+		pipe.Expire(ctx, key, expiration)
+	})
+}
+
+func boolToInt(v bool) int64 {
+	if v {
+		return 1
+	}
+	return 0
+}
+
+func bulk(ctx context.Context, pattern string, o BulkOptions, f func(ctx context.Context, pipe redis.Pipeliner, key string)) (int64, error) {
+	var mu sync.Mutex
+	var total int64
+	p := &pacer{rate: o.KeysPerSecond}
+	err := scanBatches(ctx, pattern, o.ScanCount, func(ctx context.Context, c redis.UniversalClient, keys []string) error {
+		for len(keys) > 0 {
+			n := len(keys)
+			if n > o.BatchSize {
+				n = o.BatchSize
+			}
+			batch := keys[:n]
+			keys = keys[n:]
+			if err := p.wait(ctx, n); err != nil {
+				return err
+			}
+			cmds, err := c.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+				for _, key := range batch {
+					f(ctx, pipe, key)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			var count int64
+			for _, cmd := range cmds {
+				switch v := cmd.(type) {
+				case *redis.IntCmd:
+					count += v.Val()
+				case *redis.BoolCmd:
+					count += boolToInt(v.Val())
+				}
+			}
+			mu.Lock()
+			total += count
+			mu.Unlock()
+		}
+		return nil
+	})
+	return total, err
+}
+
+// pacer 全ノード合計でrate件/秒を超えないように待機する
+type pacer struct {
+	rate int
+	mu   sync.Mutex
+	next time.Time
+}
+
+func (p *pacer) wait(ctx context.Context, n int) error {
+	if p.rate <= 0 {
+		return ctx.Err()
+	}
+	p.mu.Lock()
+	now := Now()
+	if p.next.Before(now) {
+		p.next = now
+	}
+	at := p.next
+	p.next = p.next.Add(time.Duration(n) * time.Second / time.Duration(p.rate))
+	p.mu.Unlock()
+	if d := at.Sub(now); d > 0 {
+		return wait(ctx, d)
+	}
+	return ctx.Err()
+}
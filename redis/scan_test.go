@@ -0,0 +1,85 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScanKeys(t *testing.T) {
+	ctx := context.Background()
+	b := &MemoryBuilder{}
+	defer b.Close()
+	assert.Nil(t, Setup(ctx, b))
+	for i := 0; i < 250; i++ {
+		assert.Nil(t, b.Server().Set(fmt.Sprintf("lock-count://%03d", i), "1"))
+	}
+	assert.Nil(t, b.Server().Set("lock://other", "1"))
+
+	var mu sync.Mutex
+	var keys []string
+	err := ScanKeys(ctx, "lock-count://*", func(ctx context.Context, key string) error {
+		mu.Lock()
+		defer mu.Unlock()
+		keys = append(keys, key)
+		return nil
+	})
+	assert.Nil(t, err)
+	sort.Strings(keys)
+	assert.Len(t, keys, 250)
+	assert.Equal(t, "lock-count://000", keys[0])
+
+	n, err := ExpireByPattern(ctx, "lock-count://1*", time.Minute, BulkOptions{BatchSize: 30})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(100), n)
+	assert.Equal(t, time.Minute, b.Server().TTL("lock-count://150"))
+	assert.Equal(t, time.Duration(0), b.Server().TTL("lock-count://050"))
+
+	n, err = DeleteByPattern(ctx, "lock-count://*", BulkOptions{BatchSize: 40})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(250), n)
+	assert.Equal(t, []string{"lock://other"}, b.Server().Keys())
+}
+
+func TestScanKeys_Cluster(t *testing.T) {
+	ctx := context.Background()
+	b := &MemoryBuilder{}
+	defer b.Close()
+	if _, _, err := b.Build(ctx); err != nil {
+		t.Fatal(err)
+	}
+	assert.Nil(t, Setup(ctx, &DefaultBuilder{ClusterEnable: true, PrimaryHost: b.Server().Addr()}))
+	_, ok := Primary().(*redis.ClusterClient)
+	assert.True(t, ok)
+	for i := 0; i < 20; i++ {
+		assert.Nil(t, b.Server().Set(fmt.Sprintf("cluster://%d", i), "1"))
+	}
+	n, err := DeleteByPattern(ctx, "cluster://*", BulkOptions{BatchSize: 3})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(20), n)
+	assert.Empty(t, b.Server().Keys())
+}
+
+func TestDeleteByPattern_Rate(t *testing.T) {
+	ctx := context.Background()
+	b := &MemoryBuilder{}
+	defer b.Close()
+	assert.Nil(t, Setup(ctx, b))
+	clock := &testClock{now: time.Unix(1700000000, 0)}
+	SetClock(clock)
+	defer SetClock(nil)
+	for i := 0; i < 50; i++ {
+		assert.Nil(t, b.Server().Set(fmt.Sprintf("rate://%d", i), "1"))
+	}
+	start := clock.Now()
+	n, err := DeleteByPattern(ctx, "rate://*", BulkOptions{BatchSize: 10, KeysPerSecond: 10})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(50), n)
+	assert.Equal(t, 4*time.Second, clock.Now().Sub(start))
+}
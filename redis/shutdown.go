@@ -0,0 +1,176 @@
+package redis
+
+import (
+	"context"
+	"io"
+	"sort"
+	"sync"
+
+	"github.com/goccha/logging/log"
+	"github.com/redis/go-redis/v9"
+)
+
+// ErrClosed Shutdown後に呼び出された
+var ErrClosed = redis.ErrClosed
+
+// Component Shutdownで停止するバックグラウンド処理
+type Component interface {
+	Stop(ctx context.Context) error
+}
+
+type ComponentFunc func(ctx context.Context) error
+
+func (f ComponentFunc) Stop(ctx context.Context) error {
+	return f(ctx)
+}
+
+type registry struct {
+	mu         sync.Mutex
+	seq        int
+	components map[int]Component
+}
+
+var components = &registry{components: make(map[int]Component)}
+
+// Register Shutdownで停止するコンポーネントを登録する。戻り値の関数で登録を解除する
+func Register(c Component) func() {
+	components.mu.Lock()
+	defer components.mu.Unlock()
+	components.seq++
+	id := components.seq
+	components.components[id] = c
+	return func() {
+		components.mu.Lock()
+		defer components.mu.Unlock()
+		delete(components.components, id)
+	}
+}
+
+// stop 登録と逆の順番で停止する
+func (r *registry) stop(ctx context.Context) (err error) {
+	r.mu.Lock()
+	ids := make([]int, 0, len(r.components))
+	for id := range r.components {
+		ids = append(ids, id)
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(ids)))
+	list := make([]Component, 0, len(ids))
+	for _, id := range ids {
+		list = append(list, r.components[id])
+		delete(r.components, id)
+	}
+	r.mu.Unlock()
+	for _, c := range list {
+		if e := c.Stop(ctx); e != nil {
+			log.Error(ctx).Err(e).Msg("redis: failed to stop component")
+			if err == nil {
+				err = e
+			}
+		}
+	}
+	return
+}
+
+// inflight 実行中のUniversal/ReadOnlyの数
+type inflight struct {
+	mu      sync.Mutex
+	count   int
+	closing bool
+	done    chan struct{}
+}
+
+var calls = &inflight{}
+
+func (i *inflight) enter() bool {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if i.closing {
+		return false
+	}
+	i.count++
+	return true
+}
+
+func (i *inflight) leave() {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.count--
+	if i.count == 0 && i.done != nil {
+		close(i.done)
+		i.done = nil
+	}
+}
+
+func (i *inflight) close() <-chan struct{} {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.closing = true
+	if i.done != nil {
+		return i.done
+	}
+	done := make(chan struct{})
+	if i.count == 0 {
+		close(done)
+	} else {
+		i.done = done
+	}
+	return done
+}
+
+func (i *inflight) reset() {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.closing = false
+}
+
+// retired Refreshで置き換えたクライアント。
+// Primary()等で取得したクライアントを保持し続ける呼び出し元があるため、置き換えた時点では閉じずにShutdownで閉じる
+var retired []io.Closer
+
+// retire 置き換えるクライアントを記録する。muをロックして呼び出す
+func retire(w *PrimaryClient, r *ReaderClient) {
+	retired = append(retired, closers(w, r)...)
+}
+
+// closers プライマリとリーダーのクライアント。同じクライアントは1つにする
+func closers(w *PrimaryClient, r *ReaderClient) []io.Closer {
+	var list []io.Closer
+	if w != nil && w.Client != nil {
+		list = append(list, w.Client)
+	}
+	if r != nil && r.Client != nil && (w == nil || r.Client != Cmdable(w.Client)) {
+		if c, ok := r.Client.(io.Closer); ok {
+			list = append(list, c)
+		}
+	}
+	return list
+}
+
+// Shutdown コンポーネントを停止し、実行中の処理を待ってから、Refreshで置き換えたものを含めてクライアントを閉じる
+func Shutdown(ctx context.Context) error {
+	err := components.stop(ctx)
+	select {
+	case <-calls.close():
+	case <-ctx.Done():
+		log.Warn(ctx).Err(ctx.Err()).Msg("redis: shutdown before in-flight calls completed")
+		if err == nil {
+			err = ctx.Err()
+		}
+	}
+	mu.Lock()
+	list := append(closers(primary, reader), retired...)
+	retired = nil
+	mu.Unlock()
+	closed := make(map[io.Closer]struct{}, len(list))
+	for _, c := range list {
+		if _, ok := closed[c]; ok {
+			continue
+		}
+		closed[c] = struct{}{}
+		// 以前のShutdownで閉じたクライアントが置き換えられている場合がある
+		if e := c.Close(); e != nil && !IsClosed(e) && err == nil {
+			err = e
+		}
+	}
+	return err
+}
@@ -0,0 +1,109 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShutdown(t *testing.T) {
+	ctx := context.Background()
+	b := &MemoryBuilder{}
+	defer b.Close()
+	assert.Nil(t, Setup(ctx, b))
+
+	var stopped []string
+	Register(ComponentFunc(func(ctx context.Context) error {
+		stopped = append(stopped, "renewer")
+		return nil
+	}))
+	unregister := Register(ComponentFunc(func(ctx context.Context) error {
+		stopped = append(stopped, "unregistered")
+		return nil
+	}))
+	Register(ComponentFunc(func(ctx context.Context) error {
+		stopped = append(stopped, "subscriber")
+		return errors.New("stop failure")
+	}))
+	unregister()
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	finished := make(chan error)
+	go func() {
+		finished <- Universal(ctx, func(ctx context.Context, c Cmdable) error {
+			close(started)
+			<-release
+			return c.Set(ctx, "shutdown://key", "1", time.Minute).Err()
+		})
+	}()
+	<-started
+
+	done := make(chan error)
+	go func() {
+		done <- Shutdown(ctx)
+	}()
+	select {
+	case <-done:
+		t.Fatal("shutdown did not wait for in-flight calls")
+	case <-time.After(50 * time.Millisecond):
+	}
+	err := ReadOnly(ctx, func(ctx context.Context, c Cmdable) error {
+		return nil
+	})
+	assert.True(t, IsClosed(err))
+
+	close(release)
+	assert.Nil(t, <-finished)
+	assert.EqualError(t, <-done, "stop failure")
+	assert.Equal(t, []string{"subscriber", "renewer"}, stopped)
+	assert.True(t, IsClosed(Primary().Ping(ctx).Err()))
+	v, err := b.Server().Get("shutdown://key")
+	assert.Nil(t, err)
+	assert.Equal(t, "1", v)
+}
+
+func TestShutdown_Deadline(t *testing.T) {
+	ctx := context.Background()
+	b := &MemoryBuilder{}
+	defer b.Close()
+	assert.Nil(t, Setup(ctx, b))
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	finished := make(chan error)
+	go func() {
+		finished <- Universal(ctx, func(ctx context.Context, c Cmdable) error {
+			close(started)
+			<-release
+			return nil
+		})
+	}()
+	<-started
+
+	timeout, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	err := Shutdown(timeout)
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+	close(release)
+	assert.Nil(t, <-finished)
+}
+
+func TestShutdown_Refreshed(t *testing.T) {
+	ctx := context.Background()
+	b := &MemoryBuilder{}
+	defer b.Close()
+	assert.Nil(t, Setup(ctx, b))
+	old := Primary()
+	assert.Nil(t, Refresh(ctx))
+	assert.NotEqual(t, old, Primary())
+
+	// 置き換えたクライアントを保持している呼び出し元はそのまま使える
+	assert.Nil(t, old.Ping(ctx).Err())
+	assert.Nil(t, Shutdown(ctx))
+	assert.True(t, IsClosed(old.Ping(ctx).Err()))
+	assert.True(t, IsClosed(Primary().Ping(ctx).Err()))
+}
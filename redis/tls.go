@@ -0,0 +1,157 @@
+package redis
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/goccha/logging/log"
+)
+
+// CertReloadInterval 証明書ファイルの更新を確認する間隔
+var CertReloadInterval = 10 * time.Second
+
+type TLSOptions struct {
+	Enable             bool   `yaml:"enable" json:"enable"`
+	ServerName         string `yaml:"server_name" json:"server_name"`                   // 空の場合は接続先のホスト名。Sentinelでは必須
+	CAFile             string `yaml:"ca_file" json:"ca_file"`                           // CAバンドル
+	CertFile           string `yaml:"cert_file" json:"cert_file"`                       // mTLSのクライアント証明書
+	KeyFile            string `yaml:"key_file" json:"key_file"`                         // mTLSの秘密鍵
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify" json:"insecure_skip_verify"` // 開発環境専用
+}
+
+// Config tls.Configを作成する。CAバンドルとクライアント証明書はファイルが更新されると読み込み直す
+func (t *TLSOptions) Config() (*tls.Config, error) {
+	if t == nil || !t.Enable {
+		return nil, nil
+	}
+	if (t.CertFile == "") != (t.KeyFile == "") {
+		return nil, errors.New("redis: tls cert file and key file must be set together")
+	}
+	r := &certReloader{options: *t}
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+	cfg := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ServerName: t.ServerName,
+	}
+	if t.InsecureSkipVerify {
+		log.Warn(context.Background()).Msg("redis: tls certificate verification is disabled")
+		cfg.InsecureSkipVerify = true
+	} else if t.CAFile != "" {
+		// 標準の検証ではRootCAsを差し替えられないため、VerifyConnectionで最新のCAバンドルを使って検証する
+		cfg.InsecureSkipVerify = true
+		cfg.VerifyConnection = r.verify
+	}
+	if t.CertFile != "" {
+		cfg.GetClientCertificate = r.clientCertificate
+	}
+	return cfg, nil
+}
+
+type certReloader struct {
+	options   TLSOptions
+	mu        sync.Mutex
+	checkedAt time.Time
+	modTimes  map[string]time.Time
+	roots     *x509.CertPool
+	cert      *tls.Certificate
+}
+
+func (r *certReloader) files() []string {
+	files := make([]string, 0, 3)
+	for _, f := range []string{r.options.CAFile, r.options.CertFile, r.options.KeyFile} {
+		if f != "" {
+			files = append(files, f)
+		}
+	}
+	return files
+}
+
+// load 更新されたファイルがあれば読み込む
+func (r *certReloader) load() error {
+	modTimes := make(map[string]time.Time)
+	changed := r.modTimes == nil
+	for _, f := range r.files() {
+		info, err := os.Stat(f)
+		if err != nil {
+			return err
+		}
+		modTimes[f] = info.ModTime()
+		if !info.ModTime().Equal(r.modTimes[f]) {
+			changed = true
+		}
+	}
+	if !changed {
+		return nil
+	}
+	var roots *x509.CertPool
+	if r.options.CAFile != "" {
+		b, err := os.ReadFile(r.options.CAFile)
+		if err != nil {
+			return err
+		}
+		roots = x509.NewCertPool()
+		if !roots.AppendCertsFromPEM(b) {
+			return fmt.Errorf("redis: no certificates found in %s", r.options.CAFile)
+		}
+	}
+	var cert *tls.Certificate
+	if r.options.CertFile != "" {
+		c, err := tls.LoadX509KeyPair(r.options.CertFile, r.options.KeyFile)
+		if err != nil {
+			return err
+		}
+		cert = &c
+	}
+	if r.modTimes != nil {
+		log.Info(context.Background()).Strs("files", r.files()).Msg("redis: tls certificates reloaded")
+	}
+	r.roots, r.cert, r.modTimes = roots, cert, modTimes
+	return nil
+}
+
+// current 確認間隔が過ぎていれば再読み込みしてから証明書を返す。読み込みに失敗した場合は以前の証明書を使い続ける
+func (r *certReloader) current() (*x509.CertPool, *tls.Certificate) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := Now()
+	if now.Sub(r.checkedAt) >= CertReloadInterval {
+		r.checkedAt = now
+		if err := r.load(); err != nil {
+			log.Error(context.Background()).Err(err).Msg("redis: failed to reload tls certificates")
+		}
+	}
+	return r.roots, r.cert
+}
+
+func (r *certReloader) clientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	_, cert := r.current()
+	return cert, nil
+}
+
+func (r *certReloader) verify(cs tls.ConnectionState) error {
+	if len(cs.PeerCertificates) == 0 {
+		return errors.New("redis: server presented no certificate")
+	}
+	if cs.ServerName == "" { // ホスト名を検証せずに接続しない
+		return errors.New("redis: tls server name is required to verify the server certificate")
+	}
+	roots, _ := r.current()
+	opts := x509.VerifyOptions{
+		Roots:         roots,
+		DNSName:       cs.ServerName,
+		Intermediates: x509.NewCertPool(),
+	}
+	for _, c := range cs.PeerCertificates[1:] {
+		opts.Intermediates.AddCert(c)
+	}
+	_, err := cs.PeerCertificates[0].Verify(opts)
+	return err
+}
@@ -0,0 +1,195 @@
+package redis
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newTestCA(t *testing.T, name string) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return &testCA{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+// issue 署名した証明書と秘密鍵をPEMで返す
+func (ca *testCA) issue(t *testing.T, name string, usage x509.ExtKeyUsage) (certPEM, keyPEM []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: b})
+}
+
+func writeFile(t *testing.T, path string, b []byte, modTime time.Time) {
+	if err := os.WriteFile(path, b, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTLS(t *testing.T) {
+	ctx := context.Background()
+	ca := newTestCA(t, "redis-ca")
+	serverCert, serverKey := ca.issue(t, "redis", x509.ExtKeyUsageServerAuth)
+	cert, err := tls.X509KeyPair(serverCert, serverKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(ca.cert)
+	server := miniredis.NewMiniRedis()
+	if err = server.StartTLS(&tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    clientCAs,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	dir := t.TempDir()
+	opts := &TLSOptions{
+		Enable:   true,
+		CAFile:   filepath.Join(dir, "ca.pem"),
+		CertFile: filepath.Join(dir, "client.pem"),
+		KeyFile:  filepath.Join(dir, "client-key.pem"),
+	}
+	modTime := time.Now().Add(-time.Minute)
+	clientCert, clientKey := ca.issue(t, "client", x509.ExtKeyUsageClientAuth)
+	writeFile(t, opts.CAFile, ca.pem, modTime)
+	writeFile(t, opts.CertFile, clientCert, modTime)
+	writeFile(t, opts.KeyFile, clientKey, modTime)
+	cfg, err := opts.Config()
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	_, port, _ := net.SplitHostPort(server.Addr())
+	b := &DefaultBuilder{
+		PrimaryHost: "127.0.0.1:" + port,
+		ReaderHost:  "localhost:" + port,
+		TlsConfig:   cfg,
+	}
+	w, r, err := b.Build(ctx)
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer w.Client.Close()
+	assert.Nil(t, w.Client.Set(ctx, "tls://key", "1", time.Minute).Err())
+	v, err := r.Client.Get(ctx, "tls://key").Result()
+	assert.Nil(t, err)
+	assert.Equal(t, "1", v)
+
+	// 接続先のホスト名で証明書を検証する
+	assert.Equal(t, "127.0.0.1", b.tlsConfig(b.PrimaryHost).ServerName)
+	leaf, _ := pem.Decode(serverCert)
+	peer, err := x509.ParseCertificate(leaf.Bytes)
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.Nil(t, cfg.VerifyConnection(tls.ConnectionState{ServerName: "localhost", PeerCertificates: []*x509.Certificate{peer}}))
+	var hostErr x509.HostnameError
+	err = cfg.VerifyConnection(tls.ConnectionState{ServerName: "redis.example.com", PeerCertificates: []*x509.Certificate{peer}})
+	assert.True(t, errors.As(err, &hostErr), err)
+	assert.NotNil(t, cfg.VerifyConnection(tls.ConnectionState{PeerCertificates: []*x509.Certificate{peer}}))
+
+	// 別のCAで発行された証明書に差し替えると、次のハンドシェイクから使われる
+	old, _ := cfg.GetClientCertificate(nil)
+	other := newTestCA(t, "other-ca")
+	clientCert, clientKey = other.issue(t, "client", x509.ExtKeyUsageClientAuth)
+	writeFile(t, opts.CertFile, clientCert, modTime.Add(time.Second))
+	writeFile(t, opts.KeyFile, clientKey, modTime.Add(time.Second))
+	interval := CertReloadInterval
+	CertReloadInterval = 0
+	defer func() { CertReloadInterval = interval }()
+	renewed, _ := cfg.GetClientCertificate(nil)
+	assert.NotEqual(t, old.Certificate[0], renewed.Certificate[0])
+
+	// 読み込めないファイルに更新された場合は以前の証明書を使い続ける
+	writeFile(t, opts.CertFile, []byte("broken"), modTime.Add(2*time.Second))
+	current, _ := cfg.GetClientCertificate(nil)
+	assert.Equal(t, renewed.Certificate[0], current.Certificate[0])
+
+	// 信頼していないCAのサーバー証明書は拒否する
+	clientCert, clientKey = ca.issue(t, "client", x509.ExtKeyUsageClientAuth)
+	writeFile(t, opts.CertFile, clientCert, modTime.Add(3*time.Second))
+	writeFile(t, opts.KeyFile, clientKey, modTime.Add(3*time.Second))
+	writeFile(t, opts.CAFile, other.pem, modTime.Add(3*time.Second))
+	c, _, err := (&DefaultBuilder{PrimaryHost: b.PrimaryHost, ReaderHost: b.PrimaryHost, TlsConfig: cfg}).Build(ctx)
+	assert.Nil(t, err)
+	defer c.Client.Close()
+	err = c.Client.Ping(ctx).Err()
+	var unknown x509.UnknownAuthorityError
+	assert.True(t, errors.As(err, &unknown), err)
+}
+
+func TestTLSOptions(t *testing.T) {
+	cfg, err := (&TLSOptions{}).Config()
+	assert.Nil(t, err)
+	assert.Nil(t, cfg)
+	_, err = (&TLSOptions{Enable: true, CertFile: "client.pem"}).Config()
+	assert.NotNil(t, err)
+	cfg, err = (&TLSOptions{Enable: true, InsecureSkipVerify: true}).Config()
+	assert.Nil(t, err)
+	assert.True(t, cfg.InsecureSkipVerify)
+	assert.Nil(t, cfg.VerifyConnection)
+}
@@ -0,0 +1,88 @@
+package redistest
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/goccha/redis-verse/redis"
+)
+
+// Clock 手動で進める時計。MemoryBuilderのサーバー時刻も合わせて進める
+type Clock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+// active 使用中のClock。時計とMemoryBuilderのサーバー時刻はテスト間で共有される
+var active struct {
+	sync.Mutex
+	name string
+}
+
+func acquire(name string) error {
+	active.Lock()
+	defer active.Unlock()
+	if active.name != "" {
+		return fmt.Errorf("redistest: clock is already used by %s; tests using Clock cannot run in parallel", active.name)
+	}
+	active.name = name
+	return nil
+}
+
+func release() {
+	active.Lock()
+	defer active.Unlock()
+	active.name = ""
+}
+
+// NewClock redisパッケージの時計を差し替え、テスト終了時に戻す。
+// 時計はプロセス全体で共有するため、t.Parallelで他のテストと同時に実行することはできない
+func NewClock(t *testing.T, start ...time.Time) *Clock {
+	t.Helper()
+	if err := acquire(t.Name()); err != nil {
+		t.Fatal(err)
+	}
+	c := &Clock{now: time.Now()}
+	if len(start) > 0 {
+		c.now = start[0]
+	}
+	redis.SetClock(c)
+	if builder != nil && builder.Server() != nil {
+		builder.Server().SetTime(c.now)
+	}
+	t.Cleanup(func() {
+		redis.SetClock(nil)
+		if builder != nil && builder.Server() != nil {
+			builder.Server().SetTime(time.Time{})
+		}
+		release()
+	})
+	return c
+}
+
+func (c *Clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// Sleep 待機せずに時計を進める
+func (c *Clock) Sleep(d time.Duration) {
+	c.Advance(d)
+}
+
+// Advance 時計を進め、期限切れのキーを削除する
+func (c *Clock) Advance(d time.Duration) {
+	if d <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	if builder != nil && builder.Server() != nil {
+		builder.Server().SetTime(c.now)
+		builder.Server().FastForward(d)
+	}
+}
@@ -0,0 +1,69 @@
+package redistest
+
+import (
+	"context"
+	"sync"
+
+	"github.com/goccha/redis-verse/redis"
+	goredis "github.com/redis/go-redis/v9"
+)
+
+// trackerHook 実行されたコマンドのキーを各テストに振り分ける
+type trackerHook struct {
+	mu     sync.Mutex
+	client goredis.UniversalClient
+	tests  map[*Redis]struct{}
+}
+
+var tracker = &trackerHook{tests: make(map[*Redis]struct{})}
+
+func (h *trackerHook) register(r *Redis) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if c := redis.Primary(); c != nil && c != h.client {
+		c.AddHook(h)
+		h.client = c
+	}
+	h.tests[r] = struct{}{}
+}
+
+func (h *trackerHook) unregister(r *Redis) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.tests, r)
+}
+
+func (h *trackerHook) track(cmds ...goredis.Cmder) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.tests) == 0 {
+		return
+	}
+	for _, cmd := range cmds {
+		for _, k := range redis.CommandKeys(cmd) {
+			for r := range h.tests {
+				if r.match(k) {
+					r.Track(k)
+				}
+			}
+		}
+	}
+}
+
+func (h *trackerHook) DialHook(next goredis.DialHook) goredis.DialHook {
+	return next
+}
+
+func (h *trackerHook) ProcessHook(next goredis.ProcessHook) goredis.ProcessHook {
+	return func(ctx context.Context, cmd goredis.Cmder) error {
+		h.track(cmd)
+		return next(ctx, cmd)
+	}
+}
+
+func (h *trackerHook) ProcessPipelineHook(next goredis.ProcessPipelineHook) goredis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []goredis.Cmder) error {
+		h.track(cmds...)
+		return next(ctx, cmds)
+	}
+}
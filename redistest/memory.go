@@ -0,0 +1,37 @@
+package redistest
+
+import (
+	"context"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/goccha/redis-verse/redis"
+)
+
+// MemoryBuilder プロセス内で起動するRedis互換サーバーに接続する。テスト専用のパッケージに置き、本番のビルドにはリンクしない
+type MemoryBuilder struct {
+	server *miniredis.Miniredis
+}
+
+func (b *MemoryBuilder) Build(ctx context.Context, db ...int) (primary *redis.PrimaryClient, reader *redis.ReaderClient, err error) {
+	if b.server == nil {
+		if b.server, err = miniredis.Run(); err != nil {
+			return
+		}
+	}
+	builder := &redis.DefaultBuilder{
+		PrimaryHost: b.server.Addr(),
+		ReaderHost:  b.server.Addr(),
+	}
+	return builder.Build(ctx, db...)
+}
+
+func (b *MemoryBuilder) Server() *miniredis.Miniredis {
+	return b.server
+}
+
+func (b *MemoryBuilder) Close() {
+	if b.server != nil {
+		b.server.Close()
+		b.server = nil
+	}
+}
@@ -0,0 +1,165 @@
+package redistest
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/goccha/redis-verse/redis"
+)
+
+const MaxDatabase = 16
+
+var builder *MemoryBuilder
+
+// Setup MemoryBuilderでredisパッケージをセットアップする。TestMainから呼び出す
+func Setup(ctx context.Context) (*MemoryBuilder, error) {
+	b := &MemoryBuilder{}
+	if err := redis.Setup(ctx, b); err != nil {
+		return nil, err
+	}
+	if err := redis.WaitForActivation(ctx); err != nil {
+		return nil, err
+	}
+	builder = b
+	return b, nil
+}
+
+// Main Setupでredisパッケージをセットアップしてテストを実行し、サーバーを停止して終了する。TestMainから呼び出す
+func Main(m *testing.M) {
+	b, err := Setup(context.Background())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "redistest: %v\n", err)
+		os.Exit(1)
+	}
+	code := m.Run()
+	b.Close()
+	os.Exit(code)
+}
+
+// Server Setupで起動したサーバー
+func Server() *miniredis.Miniredis {
+	if builder == nil {
+		return nil
+	}
+	return builder.Server()
+}
+
+var seq uint64
+
+// Redis テスト単位で分離されたキー空間
+type Redis struct {
+	t      *testing.T
+	Prefix string // キーの接頭辞
+	db     int
+	mu     sync.Mutex
+	keys   map[string]struct{}
+}
+
+// New テスト終了時に作成したキーを削除する
+func New(t *testing.T) *Redis {
+	t.Helper()
+	r := &Redis{
+		t:      t,
+		Prefix: fmt.Sprintf("redistest:%s:%d:", t.Name(), atomic.AddUint64(&seq, 1)),
+		db:     -1,
+		keys:   make(map[string]struct{}),
+	}
+	tracker.register(r)
+	t.Cleanup(func() {
+		tracker.unregister(r)
+		r.cleanup()
+	})
+	return r
+}
+
+// Key テスト固有のキーを返す
+func (r *Redis) Key(name string) string {
+	k := r.Prefix + name
+	r.Track(k)
+	return k
+}
+
+// Track テスト終了時に削除するキーを登録する
+func (r *Redis) Track(keys ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, k := range keys {
+		r.keys[k] = struct{}{}
+	}
+}
+
+func (r *Redis) Keys() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	keys := make([]string, 0, len(r.keys))
+	for k := range r.keys {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+func (r *Redis) match(key string) bool {
+	return strings.Contains(key, r.Prefix)
+}
+
+// DB テスト専用のデータベース番号を割り当てる
+func (r *Redis) DB() int {
+	r.t.Helper()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.db < 0 {
+		if r.db = databases.acquire(); r.db < 0 {
+			r.t.Fatal("redistest: no database available")
+		}
+	}
+	return r.db
+}
+
+func (r *Redis) cleanup() {
+	ctx := context.Background()
+	for _, k := range r.Keys() {
+		if cmd := redis.Primary().Del(ctx, k); cmd.Err() != nil {
+			r.t.Logf("redistest: %s: %v", k, cmd.Err())
+		}
+	}
+	if r.db >= 0 {
+		err := redis.Universal(ctx, func(ctx context.Context, c redis.Cmdable) error {
+			return c.FlushDB(ctx).Err()
+		}, r.db)
+		if err != nil {
+			r.t.Logf("redistest: flushdb %d: %v", r.db, err)
+		}
+		databases.release(r.db)
+	}
+}
+
+type pool struct {
+	mu   sync.Mutex
+	used [MaxDatabase]bool
+}
+
+var databases = &pool{}
+
+func (p *pool) acquire() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for i := 1; i < MaxDatabase; i++ {
+		if i != redis.DatabaseNumber() && !p.used[i] {
+			p.used[i] = true
+			return i
+		}
+	}
+	return -1
+}
+
+func (p *pool) release(db int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.used[db] = false
+}
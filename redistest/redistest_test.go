@@ -0,0 +1,65 @@
+package redistest
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/goccha/redis-verse/redis"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMain(m *testing.M) {
+	Main(m)
+}
+
+func TestRedis_Cleanup(t *testing.T) {
+	ctx := context.Background()
+	var keys []string
+	t.Run("track", func(t *testing.T) {
+		r := New(t)
+		key := "blocks://" + r.Prefix + "user"
+		assert.Nil(t, redis.Primary().Set(ctx, key, "1", 0).Err())
+		assert.Nil(t, redis.Primary().Set(ctx, r.Key("counter"), "1", 0).Err())
+		keys = r.Keys()
+		assert.Contains(t, keys, key)
+		assert.Len(t, keys, 2)
+	})
+	cmd := redis.Reader().Exists(ctx, keys...)
+	assert.Nil(t, cmd.Err())
+	assert.Equal(t, int64(0), cmd.Val())
+}
+
+func TestRedis_DB(t *testing.T) {
+	ctx := context.Background()
+	var db int
+	t.Run("isolate", func(t *testing.T) {
+		r := New(t)
+		db = r.DB()
+		assert.NotEqual(t, redis.DatabaseNumber(), db)
+		err := redis.Universal(ctx, func(ctx context.Context, c redis.Cmdable) error {
+			return c.Set(ctx, "isolated", "1", 0).Err()
+		}, db)
+		assert.Nil(t, err)
+		assert.True(t, Server().DB(db).Exists("isolated"))
+		assert.False(t, Server().Exists("isolated"))
+	})
+	assert.False(t, Server().DB(db).Exists("isolated"))
+}
+
+func TestClock(t *testing.T) {
+	ctx := context.Background()
+	r := New(t)
+	clock := NewClock(t)
+	start := redis.Now()
+	key := r.Key("ttl")
+	assert.Nil(t, redis.Primary().Set(ctx, key, "1", time.Minute).Err())
+	redis.Sleep(59 * time.Second)
+	assert.Equal(t, 59*time.Second, redis.Now().Sub(start))
+	assert.Nil(t, redis.Reader().Get(ctx, key).Err())
+	clock.Advance(time.Second)
+	assert.True(t, redis.IsNil(redis.Reader().Get(ctx, key).Err()))
+
+	// 並列に実行されたテストは時計を差し替えられない
+	assert.NotNil(t, acquire("TestOther"))
+}
@@ -0,0 +1,76 @@
+package sessions
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/goccha/logging/log"
+)
+
+type contextKey struct{}
+
+var sessionKey = contextKey{}
+
+func WithSession(ctx context.Context, session *Session) context.Context {
+	return context.WithValue(ctx, sessionKey, session)
+}
+
+func FromContext(ctx context.Context) *Session {
+	if v, ok := ctx.Value(sessionKey).(*Session); ok {
+		return v
+	}
+	return nil
+}
+
+// Middleware Cookieのセッションを読み込みcontextに設定する
+func (s *Store) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		cookie, err := r.Cookie(s.cookieName())
+		if err != nil || cookie.Value == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		session, err := s.Get(ctx, cookie.Value)
+		if err != nil {
+			if !IsNotFound(err) {
+				log.Error(ctx).Err(err).Send()
+			}
+			s.ClearCookie(w)
+			next.ServeHTTP(w, r)
+			return
+		}
+		if err = s.Touch(ctx, session); err != nil {
+			log.Error(ctx).Err(err).Send()
+		} else {
+			s.SetCookie(w, session)
+		}
+		next.ServeHTTP(w, r.WithContext(WithSession(ctx, session)))
+	})
+}
+
+func (s *Store) SetCookie(w http.ResponseWriter, session *Session) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     s.cookieName(),
+		Value:    session.ID,
+		Path:     s.Path,
+		Domain:   s.Domain,
+		MaxAge:   int(s.expiration().Seconds()),
+		Secure:   s.Secure,
+		HttpOnly: true,
+		SameSite: s.SameSite,
+	})
+}
+
+func (s *Store) ClearCookie(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     s.cookieName(),
+		Value:    "",
+		Path:     s.Path,
+		Domain:   s.Domain,
+		MaxAge:   -1,
+		Secure:   s.Secure,
+		HttpOnly: true,
+		SameSite: s.SameSite,
+	})
+}
@@ -0,0 +1,267 @@
+package sessions
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/goccha/logging/log"
+	"github.com/goccha/redis-verse/redis"
+	goredis "github.com/redis/go-redis/v9"
+)
+
+const (
+	SessionPrefix     = "sessions"
+	UserSessionPrefix = "user-sessions"
+	DefaultCookieName = "session_id"
+)
+
+var DefaultExpiration = 30 * time.Minute
+
+type NotFoundError struct{}
+
+func (err *NotFoundError) Error() string {
+	return "sessions: session not found"
+}
+
+func IsNotFound(err error) bool {
+	notFound := &NotFoundError{}
+	return errors.As(err, &notFound)
+}
+
+func SessionKey(id string) string {
+	return fmt.Sprintf("%s://%s", SessionPrefix, id)
+}
+
+// UserKey ユーザーのセッションIDの索引。セッションの有効期限をスコアにしたソート済みセットで、期限切れのIDは更新時に削除する
+func UserKey(userID string) string {
+	return fmt.Sprintf("%s://%s", UserSessionPrefix, userID)
+}
+
+// Session Redisに保存するセッション
+type Session struct {
+	ID        string            `json:"id"`
+	UserID    string            `json:"user_id,omitempty"`
+	Values    map[string]string `json:"values,omitempty"`
+	CreatedAt int64             `json:"created_at"`
+}
+
+func (s *Session) Get(key string) string {
+	if s.Values == nil {
+		return ""
+	}
+	return s.Values[key]
+}
+
+func (s *Session) Set(key, value string) {
+	if s.Values == nil {
+		s.Values = make(map[string]string)
+	}
+	s.Values[key] = value
+}
+
+// Store セッションの保存先
+type Store struct {
+	Expiration time.Duration // 最終アクセスからの有効期間
+	CookieName string
+	Path       string
+	Domain     string
+	Secure     bool
+	SameSite   http.SameSite
+}
+
+func NewStore(expiration time.Duration) *Store {
+	return &Store{
+		Expiration: expiration,
+		CookieName: DefaultCookieName,
+		Path:       "/",
+		Secure:     true,
+		SameSite:   http.SameSiteLaxMode,
+	}
+}
+
+func (s *Store) expiration() time.Duration {
+	if s.Expiration <= 0 {
+		return DefaultExpiration
+	}
+	return s.Expiration
+}
+
+func (s *Store) cookieName() string {
+	if s.CookieName == "" {
+		return DefaultCookieName
+	}
+	return s.CookieName
+}
+
+func NewID() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// Create 新しいセッションを作成する
+func (s *Store) Create(ctx context.Context, userID string) (*Session, error) {
+	id, err := NewID()
+	if err != nil {
+		return nil, err
+	}
+	session := &Session{
+		ID:        id,
+		UserID:    userID,
+		CreatedAt: redis.Now().Unix(),
+	}
+	if err = s.Save(ctx, session); err != nil {
+		return nil, err
+	}
+	return session, nil
+}
+
+// Save セッションを保存し有効期間を延長する
+func (s *Store) Save(ctx context.Context, session *Session) error {
+	v, err := json.Marshal(session)
+	if err != nil {
+		return err
+	}
+	exp := s.expiration()
+	return redis.Universal(ctx, func(ctx context.Context, c redis.Cmdable) error {
+		if cmd := c.Set(ctx, SessionKey(session.ID), v, exp); cmd.Err() != nil {
+			return cmd.Err()
+		}
+		if session.UserID != "" {
+			return s.index(ctx, c, session.UserID, session.ID)
+		}
+		return nil
+	})
+}
+
+func (s *Store) index(ctx context.Context, c redis.Cmdable, userID, id string) error {
+	key := UserKey(userID)
+	now := redis.Now()
+	exp := s.expiration()
+	if cmd := c.ZAdd(ctx, key, goredis.Z{Score: float64(now.Add(exp).UnixMilli()), Member: id}); cmd.Err() != nil {
+		return cmd.Err()
+	}
+	return s.prune(ctx, c, key, now)
+}
+
+// prune 期限切れのセッションIDを索引から削除し、索引の有効期間を延長する
+func (s *Store) prune(ctx context.Context, c redis.Cmdable, key string, now time.Time) error {
+	if cmd := c.ZRemRangeByScore(ctx, key, "-inf", strconv.FormatInt(now.UnixMilli(), 10)); cmd.Err() != nil {
+		return cmd.Err()
+	}
+	if cmd := c.Expire(ctx, key, s.expiration()); cmd.Err() != nil {
+		return cmd.Err()
+	}
+	return nil
+}
+
+// Get セッションを取得する
+func (s *Store) Get(ctx context.Context, id string) (session *Session, err error) {
+	err = redis.ReadOnly(ctx, func(ctx context.Context, c redis.Cmdable) error {
+		cmd := c.Get(ctx, SessionKey(id))
+		if cmd.Err() != nil {
+			if redis.IsNil(cmd.Err()) {
+				return &NotFoundError{}
+			}
+			return cmd.Err()
+		}
+		b, err := cmd.Bytes()
+		if err != nil {
+			return err
+		}
+		session = &Session{}
+		return json.Unmarshal(b, session)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return session, nil
+}
+
+// Touch セッションの有効期間を延長する
+func (s *Store) Touch(ctx context.Context, session *Session) error {
+	exp := s.expiration()
+	return redis.Universal(ctx, func(ctx context.Context, c redis.Cmdable) error {
+		if cmd := c.Expire(ctx, SessionKey(session.ID), exp); cmd.Err() != nil {
+			return cmd.Err()
+		} else if !cmd.Val() {
+			return &NotFoundError{}
+		}
+		if session.UserID != "" {
+			return s.index(ctx, c, session.UserID, session.ID)
+		}
+		return nil
+	})
+}
+
+// Rotate セッションIDを振り直す。権限が変わったタイミングで呼び出す
+func (s *Store) Rotate(ctx context.Context, session *Session, userID ...string) (*Session, error) {
+	id, err := NewID()
+	if err != nil {
+		return nil, err
+	}
+	rotated := &Session{
+		ID:        id,
+		UserID:    session.UserID,
+		CreatedAt: redis.Now().Unix(),
+	}
+	// 元のセッションと値を共有しない
+	for k, v := range session.Values {
+		rotated.Set(k, v)
+	}
+	if len(userID) > 0 {
+		rotated.UserID = userID[0]
+	}
+	if err = s.Save(ctx, rotated); err != nil {
+		return nil, err
+	}
+	if err = s.Destroy(ctx, session); err != nil {
+		return nil, err
+	}
+	return rotated, nil
+}
+
+// Destroy セッションを削除する
+func (s *Store) Destroy(ctx context.Context, session *Session) error {
+	return redis.Universal(ctx, func(ctx context.Context, c redis.Cmdable) error {
+		if cmd := c.Del(ctx, SessionKey(session.ID)); cmd.Err() != nil {
+			return cmd.Err()
+		}
+		if session.UserID != "" {
+			if cmd := c.ZRem(ctx, UserKey(session.UserID), session.ID); cmd.Err() != nil {
+				return cmd.Err()
+			}
+		}
+		return nil
+	})
+}
+
+// DestroyAll ユーザーの全セッションを削除する
+func (s *Store) DestroyAll(ctx context.Context, userID string) error {
+	key := UserKey(userID)
+	return redis.Universal(ctx, func(ctx context.Context, c redis.Cmdable) error {
+		// 期限切れのセッションは削除済みのため対象にしない
+		cmd := c.ZRangeByScore(ctx, key, &goredis.ZRangeBy{Min: "(" + strconv.FormatInt(redis.Now().UnixMilli(), 10), Max: "+inf"})
+		if cmd.Err() != nil {
+			return cmd.Err()
+		}
+		for _, id := range cmd.Val() {
+			if del := c.Del(ctx, SessionKey(id)); del.Err() != nil {
+				log.Error(ctx).Err(del.Err()).Str("session_id", id).Send()
+			}
+		}
+		if del := c.Del(ctx, key); del.Err() != nil {
+			return del.Err()
+		}
+		return nil
+	})
+}
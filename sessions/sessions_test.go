@@ -0,0 +1,142 @@
+package sessions
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/goccha/redis-verse/redis"
+	"github.com/goccha/redis-verse/redistest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMain(m *testing.M) {
+	redistest.Main(m)
+}
+
+func TestStore_Create(t *testing.T) {
+	ctx := context.Background()
+	store := NewStore(time.Minute)
+	userID := redistest.New(t).Prefix + "user"
+	s, err := store.Create(ctx, userID)
+	assert.Nil(t, err)
+	assert.Len(t, s.ID, 43)
+	s.Set("role", "guest")
+	assert.Nil(t, store.Save(ctx, s))
+
+	got, err := store.Get(ctx, s.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, userID, got.UserID)
+	assert.Equal(t, "guest", got.Get("role"))
+
+	cmd := redis.Reader().TTL(ctx, SessionKey(s.ID))
+	assert.Nil(t, cmd.Err())
+	assert.True(t, cmd.Val() > 0 && cmd.Val() <= time.Minute)
+
+	assert.Nil(t, store.Destroy(ctx, s))
+	_, err = store.Get(ctx, s.ID)
+	assert.True(t, IsNotFound(err))
+}
+
+func TestStore_Rotate(t *testing.T) {
+	ctx := context.Background()
+	store := NewStore(time.Minute)
+	userID := redistest.New(t).Prefix + "user"
+	s, err := store.Create(ctx, userID)
+	assert.Nil(t, err)
+	s.Set("role", "guest")
+	assert.Nil(t, store.Save(ctx, s))
+
+	rotated, err := store.Rotate(ctx, s)
+	assert.Nil(t, err)
+	assert.NotEqual(t, s.ID, rotated.ID)
+	assert.Equal(t, "guest", rotated.Get("role"))
+	_, err = store.Get(ctx, s.ID)
+	assert.True(t, IsNotFound(err))
+	// 値は元のセッションと共有しない
+	s.Set("role", "admin")
+	assert.Equal(t, "guest", rotated.Get("role"))
+
+	cmd := redis.Reader().ZRange(ctx, UserKey(userID), 0, -1)
+	assert.Nil(t, cmd.Err())
+	assert.Equal(t, []string{rotated.ID}, cmd.Val())
+	assert.Nil(t, store.DestroyAll(ctx, userID))
+}
+
+func TestStore_DestroyAll(t *testing.T) {
+	ctx := context.Background()
+	store := NewStore(time.Minute)
+	userID := redistest.New(t).Prefix + "user"
+	ids := make([]string, 0, 3)
+	for i := 0; i < 3; i++ {
+		s, err := store.Create(ctx, userID)
+		assert.Nil(t, err)
+		ids = append(ids, s.ID)
+	}
+	assert.Nil(t, store.DestroyAll(ctx, userID))
+	for _, id := range ids {
+		_, err := store.Get(ctx, id)
+		assert.True(t, IsNotFound(err))
+	}
+	cmd := redis.Reader().Exists(ctx, UserKey(userID))
+	assert.Nil(t, cmd.Err())
+	assert.Equal(t, int64(0), cmd.Val())
+}
+
+func TestStore_Middleware(t *testing.T) {
+	ctx := context.Background()
+	store := NewStore(time.Minute)
+	userID := redistest.New(t).Prefix + "user"
+	s, err := store.Create(ctx, userID)
+	assert.Nil(t, err)
+
+	var got *Session
+	h := store.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = FromContext(r.Context())
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.AddCookie(&http.Cookie{Name: DefaultCookieName, Value: s.ID})
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if assert.NotNil(t, got) {
+		assert.Equal(t, s.ID, got.ID)
+	}
+
+	got = nil
+	req = httptest.NewRequest(http.MethodGet, "/", nil)
+	req.AddCookie(&http.Cookie{Name: DefaultCookieName, Value: "unknown"})
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	assert.Nil(t, got)
+	assert.Contains(t, rec.Header().Get("Set-Cookie"), "Max-Age=0")
+
+	assert.Nil(t, store.DestroyAll(ctx, userID))
+}
+
+func TestStore_Expired(t *testing.T) {
+	ctx := context.Background()
+	clock := redistest.NewClock(t)
+	store := NewStore(time.Minute)
+	userID := redistest.New(t).Prefix + "user"
+	expired, err := store.Create(ctx, userID)
+	assert.Nil(t, err)
+	clock.Advance(40 * time.Second)
+	active, err := store.Create(ctx, userID)
+	assert.Nil(t, err)
+	clock.Advance(30 * time.Second)
+	_, err = store.Get(ctx, expired.ID)
+	assert.True(t, IsNotFound(err))
+
+	// 期限切れのセッションIDは更新時に索引から削除する
+	assert.Nil(t, store.Touch(ctx, active))
+	cmd := redis.Reader().ZRange(ctx, UserKey(userID), 0, -1)
+	assert.Nil(t, cmd.Err())
+	assert.Equal(t, []string{active.ID}, cmd.Val())
+
+	assert.Nil(t, store.DestroyAll(ctx, userID))
+	_, err = store.Get(ctx, active.ID)
+	assert.True(t, IsNotFound(err))
+}
@@ -2,10 +2,10 @@ package throttles
 
 import (
 	"context"
-	"time"
 
 	"github.com/go-redis/redis_rate/v10"
 	"github.com/goccha/logging/log"
+	"github.com/goccha/redis-verse/redis"
 	"github.com/pkg/errors"
 )
 
@@ -32,9 +32,21 @@ func IsOverflow(err error) bool {
 	return errors.As(err, &overflow)
 }
 
+// waitError 待機が期限を超える
+type waitError struct {
+	err error
+}
+
+func (e *waitError) Error() string {
+	return e.err.Error()
+}
+
 var defaultMax = 5
 var limiter *redis_rate.Limiter
 
+// Policy Redisに接続できない場合の振る舞い
+var Policy = redis.FailClosed
+
 func Limit(ctx context.Context, id string, rate int, f func() error, retryMax ...int) (err error) {
 	return LimitPer(ctx, id, redis_rate.PerSecond(rate), f, retryMax...)
 }
@@ -47,7 +59,10 @@ func try(ctx context.Context, id string, limit redis_rate.Limit) (retry bool, er
 			Int("remaining", result.Remaining).
 			Dur("retryAfter", result.RetryAfter).
 			Dur("resetAfter", result.ResetAfter).Send()
-		time.Sleep(result.RetryAfter)
+		if err = redis.CheckDeadline(ctx, result.RetryAfter); err != nil {
+			return false, &waitError{err: err}
+		}
+		redis.Sleep(result.RetryAfter)
 		return true, nil
 	}
 	return false, nil
@@ -58,15 +73,38 @@ func LimitPer(ctx context.Context, id string, limit redis_rate.Limit, f func() e
 	if len(retryMax) > 0 {
 		maxCnt = retryMax[0]
 	}
+	ctx, done := redis.WithBudget(ctx, redis.BudgetThrottle)
 	retry := true
 	for i := 0; retry && i < maxCnt; i++ {
 		if retry, err = try(ctx, id, limit); err != nil {
+			var wait *waitError
+			if errors.As(err, &wait) { // 待機が期限を超える場合はRedisの障害ではないためPolicyを適用しない
+				return done(wait.err)
+			}
+			if err = done(err); Policy.Open(err) {
+				log.Warn(ctx).Err(err).Str("id", id).Msg("throttles: fail open")
+				return f()
+			}
 			return err
 		}
 	}
+	_ = done(nil)
 	if retry {
 		return &OverflowError{}
 	}
 	err = f()
 	return
 }
+
+// Usage 消費せずにidの現在の使用状況を取得する
+func Usage(ctx context.Context, id string, limit redis_rate.Limit) (*redis_rate.Result, error) {
+	l := limiter
+	if l == nil {
+		l = redis_rate.NewLimiter(redis.Primary())
+	}
+	result, err := l.AllowN(ctx, id, limit, 0)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return result, nil
+}
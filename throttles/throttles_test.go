@@ -11,12 +11,13 @@ import (
 
 	"github.com/go-redis/redis_rate/v10"
 	"github.com/goccha/redis-verse/redis"
+	"github.com/goccha/redis-verse/redistest"
 	"github.com/stretchr/testify/assert"
 )
 
 func TestMain(m *testing.M) {
 	ctx := context.Background()
-	if err := redis.Setup(ctx, &redis.EnvBuilder{}); err != nil {
+	if err := redis.Setup(ctx, &redistest.MemoryBuilder{}); err != nil {
 		panic(err)
 	}
 	if err := redis.WaitForActivation(ctx); err != nil {
@@ -94,3 +95,23 @@ func TestOverflowError_Error(t *testing.T) {
 	err = errors.New("test")
 	assert.Equal(t, IsOverflow(err), false)
 }
+
+func TestLimit_Deadline(t *testing.T) {
+	ctx := context.Background()
+	redis.SetDeadlines(redis.Deadlines{Throttle: 100 * time.Millisecond})
+	defer redis.SetDeadlines(redis.Deadlines{})
+	Policy = redis.FailOpen
+	defer func() { Policy = redis.FailClosed }()
+	limit := redis_rate.PerMinute(1)
+	assert.Nil(t, LimitPer(ctx, "deadline", limit, func() error { return nil }))
+	called := false
+	err := LimitPer(ctx, "deadline", limit, func() error {
+		called = true
+		return nil
+	})
+	assert.False(t, called)
+	var deadlineErr *redis.DeadlineError
+	if assert.ErrorAs(t, err, &deadlineErr) {
+		assert.Equal(t, redis.BudgetThrottle, deadlineErr.Budget)
+	}
+}